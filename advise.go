@@ -0,0 +1,79 @@
+package sparkey
+
+import "time"
+
+// CompactionAction is the action recommended by Advise.
+type CompactionAction uint8
+
+const (
+	// COMPACT_NEVER means that a compaction would not save enough to be
+	// worth it.
+	COMPACT_NEVER CompactionAction = iota
+	// COMPACT_LATER means that a compaction should be scheduled when
+	// convenient.
+	COMPACT_LATER
+	// COMPACT_NOW means that the log is mostly garbage and should be
+	// compacted as soon as possible.
+	COMPACT_NOW
+)
+
+// Default thresholds of Advise
+const (
+	defaultAdviseNowRatio   = 0.5
+	defaultAdviseLaterRatio = 0.2
+	defaultAdviseMinSavings = MiB
+	defaultAdviseThroughput = 100 * MiB
+)
+
+// CompactionAdvice is returned by Advise
+type CompactionAdvice struct {
+	// Recommended action
+	Action CompactionAction
+	// Ratio of garbage (overwritten and deleted entries) to all log entries
+	GarbageRatio float64
+	// Estimated number of bytes saved by a compaction
+	Savings uint64
+	// Estimated time to rewrite the log and rebuild the hash
+	BuildTime time.Duration
+}
+
+// Advise recommends whether the log associated with a reader should be
+// compacted, based on the garbage accounting in the headers the reader was
+// opened with. Supported
+// options are WithCompactionRatios, WithMinSavings and WithRebuildThroughput.
+func Advise(r *HashReader, opts ...Option) (*CompactionAdvice, error) {
+	conf := newConfig(opts)
+	if err := conf.check(adviseOptions); err != nil {
+		return nil, err
+	}
+
+	hash, log := r.Header(), r.Log().Header()
+
+	// Garbage is accounted in uncompressed bytes, use the uncompressed
+	// entry sizes as total, but savings apply to the bytes on disk.
+	advice := new(CompactionAdvice)
+	if total := log.PutSize + log.DeleteSize; total > 0 {
+		advice.GarbageRatio = float64(hash.GarbageSize) / float64(total)
+		if advice.GarbageRatio > 1 {
+			advice.GarbageRatio = 1
+		}
+	}
+
+	var size uint64
+	if hash.DataEnd > logHeaderSize {
+		size = hash.DataEnd - logHeaderSize
+	}
+	advice.Savings = uint64(advice.GarbageRatio * float64(size))
+	live := size - advice.Savings
+	advice.BuildTime = time.Duration(float64(live) / float64(conf.adviseThroughput) * float64(time.Second))
+
+	switch {
+	case advice.Savings < conf.adviseMinSavings:
+		advice.Action = COMPACT_NEVER
+	case advice.GarbageRatio >= conf.adviseNowRatio:
+		advice.Action = COMPACT_NOW
+	case advice.GarbageRatio >= conf.adviseLaterRatio:
+		advice.Action = COMPACT_LATER
+	}
+	return advice, nil
+}
@@ -0,0 +1,82 @@
+package sparkey
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Advise", func() {
+
+	var advise = func(overwrites int, opts ...Option) *CompactionAdvice {
+		fname, err := writeTestHash(testDir, func(w *LogWriter) error {
+			val := bytes.Repeat([]byte{'x'}, 100)
+			for n := 0; n <= overwrites; n++ {
+				for i := 0; i < 100; i++ {
+					if err := w.Put([]byte(fmt.Sprintf("k%03d", i)), val); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		advice, err := Advise(reader, opts...)
+		Expect(err).NotTo(HaveOccurred())
+		return advice
+	}
+
+	It("should not recommend compaction without garbage", func() {
+		advice := advise(0, WithMinSavings(1))
+		Expect(advice.Action).To(Equal(COMPACT_NEVER))
+		Expect(advice.GarbageRatio).To(BeZero())
+		Expect(advice.Savings).To(BeZero())
+		Expect(advice.BuildTime).To(BeNumerically(">", 0))
+	})
+
+	It("should recommend compaction based on ratios", func() {
+		advice := advise(1, WithMinSavings(1))
+		Expect(advice.GarbageRatio).To(BeNumerically("~", 0.5, 0.01))
+		Expect(advice.Savings).To(Equal(uint64(10600)))
+		Expect(advice.Action).To(Equal(COMPACT_NOW))
+
+		advice = advise(1, WithMinSavings(1), WithCompactionRatios(0.2, 0.6))
+		Expect(advice.Action).To(Equal(COMPACT_LATER))
+
+		advice = advise(3, WithMinSavings(1))
+		Expect(advice.GarbageRatio).To(BeNumerically("~", 0.75, 0.01))
+		Expect(advice.Action).To(Equal(COMPACT_NOW))
+	})
+
+	It("should ignore small savings", func() {
+		advice := advise(3)
+		Expect(advice.GarbageRatio).To(BeNumerically("~", 0.75, 0.01))
+		Expect(advice.Action).To(Equal(COMPACT_NEVER))
+	})
+
+	It("should not underflow on corrupt data ends", func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		// zero the data end of the hash header
+		reader.header.DataEnd = 0
+
+		advice, err := Advise(reader, WithMinSavings(1))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(advice.Savings).To(BeZero())
+		Expect(advice.BuildTime).To(BeZero())
+		Expect(advice.Action).To(Equal(COMPACT_NEVER))
+	})
+
+})
@@ -0,0 +1,215 @@
+package sparkey
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AtomicWriter writes a new hash/log pair to temporary files next to its
+// final location and moves both into place on Commit, so readers never
+// observe partially written files. The pair cannot be replaced in a single
+// step: while Commit moves the files, readers which open the pair may not
+// find it and fail with ERROR_FILE_NOT_FOUND, but they never see a new log
+// next to an old hash. Readers which have opened the previous pair keep
+// their snapshot. Writers are not threadsafe.
+//
+// A typical use is:
+//
+//	w, err := sparkey.NewAtomicWriter("data/snapshot")
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Abort()
+//
+//	// ... w.Put(key, value)
+//
+//	return w.Commit()
+type AtomicWriter struct {
+	name    string // final base name
+	tmpname string // temporary base name
+	log     *LogWriter
+	conf    *config
+}
+
+// NewAtomicWriter creates a writer for the hash/log pair at fname. Supported
+// options are those of NewLogWriter and BuildHashFile.
+func NewAtomicWriter(fname string, opts ...Option) (*AtomicWriter, error) {
+	conf := newConfig(opts)
+	if err := conf.check(logWriterOptions | hashOptions); err != nil {
+		return nil, err
+	}
+	return newAtomicWriter(fname, conf)
+}
+
+// newAtomicWriter creates an atomic writer with a checked configuration.
+func newAtomicWriter(fname string, conf *config) (*AtomicWriter, error) {
+	name := strings.TrimSuffix(LogFileName(fname), ".spl")
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*.spl")
+	if err != nil {
+		return nil, fileError(err)
+	}
+	tmp.Close()
+
+	w := &AtomicWriter{name: name, tmpname: strings.TrimSuffix(tmp.Name(), ".spl"), conf: conf}
+	if w.log, err = newLogWriter(w.tmpname, conf); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return w, nil
+}
+
+// Name returns the final log file name
+func (w *AtomicWriter) Name() string { return LogFileName(w.name) }
+
+// Put appends a key/value pair, see LogWriter.Put.
+func (w *AtomicWriter) Put(key, value []byte) error {
+	if w.log == nil {
+		return ERROR_LOG_CLOSED
+	}
+	return w.log.Put(key, value)
+}
+
+// Delete appends a delete operation for a key, see LogWriter.Delete.
+func (w *AtomicWriter) Delete(key []byte) error {
+	if w.log == nil {
+		return ERROR_LOG_CLOSED
+	}
+	return w.log.Delete(key)
+}
+
+// PutReader appends a key/value pair, streaming the value from r, see
+// LogWriter.PutReader.
+func (w *AtomicWriter) PutReader(key []byte, r io.Reader, size int64) error {
+	if w.log == nil {
+		return ERROR_LOG_CLOSED
+	}
+	return w.log.PutReader(key, r, size)
+}
+
+// PutBatch appends many entries at once, see LogWriter.PutBatch.
+func (w *AtomicWriter) PutBatch(entries []Entry) error {
+	if w.log == nil {
+		return ERROR_LOG_CLOSED
+	}
+	return w.log.PutBatch(entries)
+}
+
+// Stats returns statistics about the log, see LogWriter.Stats.
+func (w *AtomicWriter) Stats() (LogWriterStats, error) {
+	if w.log == nil {
+		return LogWriterStats{}, ERROR_LOG_CLOSED
+	}
+	return w.log.Stats()
+}
+
+// Commit closes the log, builds its hash file and moves both into place,
+// replacing existing files. The files are synced to disk before they are
+// moved, the directory afterwards. Existing files are moved aside first and
+// restored if the new ones cannot be moved into place. The writer cannot
+// be used after Commit, on errors all temporary files are removed.
+func (w *AtomicWriter) Commit() error {
+	if w.log == nil {
+		return ERROR_LOG_CLOSED
+	}
+	if err := w.commit(); err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+func (w *AtomicWriter) commit() error {
+	log := w.log
+	log.sync = true
+	w.log = nil
+	if err := log.Close(); err != nil {
+		return err
+	}
+
+	if err := buildHash(context.Background(), w.tmpname, w.conf); err != nil {
+		return err
+	}
+	if err := syncFile(HashFileName(w.tmpname)); err != nil {
+		return err
+	}
+
+	if err := w.publish(); err != nil {
+		return err
+	}
+	return syncFile(filepath.Dir(w.name))
+}
+
+// renameFile renames files, it is replaced in tests.
+var renameFile = os.Rename
+
+// publish moves the existing files aside, hashes first, then moves the new
+// log and hash into place. On errors, the previous state is restored.
+func (w *AtomicWriter) publish() error {
+	aside := w.tmpname + ".old"
+
+	var moved []string
+	restore := func() {
+		for _, ext := range moved {
+			renameFile(aside+ext, w.name+ext)
+		}
+	}
+	for _, ext := range []string{".spi", ".spl"} {
+		if err := renameFile(w.name+ext, aside+ext); err == nil {
+			moved = append(moved, ext)
+		} else if !os.IsNotExist(err) {
+			restore()
+			return fileError(err)
+		}
+	}
+
+	var placed []string
+	for _, ext := range []string{".spl", ".spi"} {
+		if err := renameFile(w.tmpname+ext, w.name+ext); err != nil {
+			for _, ext := range placed {
+				renameFile(w.name+ext, w.tmpname+ext)
+			}
+			restore()
+			return fileError(err)
+		}
+		placed = append(placed, ext)
+	}
+
+	for _, ext := range moved {
+		os.Remove(aside + ext)
+	}
+	return nil
+}
+
+// Abort discards the log and removes all temporary files. It is a no-op
+// after Commit, so it can be deferred safely.
+func (w *AtomicWriter) Abort() error {
+	var err error
+	if w.log != nil {
+		err = w.log.Close()
+		w.log = nil
+	}
+	for _, name := range []string{LogFileName(w.tmpname), HashFileName(w.tmpname)} {
+		if e := os.Remove(name); err == nil && e != nil && !os.IsNotExist(e) {
+			err = fileError(e)
+		}
+	}
+	return err
+}
+
+// syncFile syncs a file or directory to disk.
+func syncFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fileError(err)
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fileError(err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package sparkey
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AtomicWriter", func() {
+	var fname string
+	var subject *AtomicWriter
+
+	files := func() []string {
+		entries, err := filepath.Glob(filepath.Join(testDir, "*"))
+		Expect(err).NotTo(HaveOccurred())
+		for i, name := range entries {
+			entries[i] = filepath.Base(name)
+		}
+		return entries
+	}
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		subject, err = NewAtomicWriter(fname, WithHashSize(HASH_SIZE_32BIT))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Abort()
+	})
+
+	It("should write to temporary files", func() {
+		Expect(subject.Name()).To(Equal(fname + ".spl"))
+		Expect(subject.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(files()).To(HaveLen(3))
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(reader.Get([]byte("k1"))).To(BeNil())
+	})
+
+	It("should commit", func() {
+		old, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer old.Close()
+
+		Expect(subject.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(subject.PutBatch([]Entry{{Type: ENTRY_PUT, Key: []byte("k2"), Value: []byte("v2")}})).To(Succeed())
+		Expect(subject.Delete([]byte("k1"))).To(Succeed())
+		Expect(subject.Commit()).To(Succeed())
+		Expect(files()).To(ConsistOf("test.spi", "test.spl"))
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Header().HashSize).To(Equal(HASH_SIZE_32BIT))
+		Expect(reader.Get([]byte("xk"))).To(BeNil())
+		Expect(reader.Get([]byte("k1"))).To(BeNil())
+		Expect(reader.Get([]byte("k2"))).To(Equal([]byte("v2")))
+
+		// existing readers keep their snapshot
+		Expect(old.Get([]byte("xk"))).To(Equal([]byte("short")))
+
+		Expect(subject.Put([]byte("k3"), []byte("v3"))).To(Equal(ERROR_LOG_CLOSED))
+		Expect(subject.Commit()).To(Equal(ERROR_LOG_CLOSED))
+		Expect(subject.Abort()).To(Succeed())
+		Expect(files()).To(ConsistOf("test.spi", "test.spl"))
+	})
+
+	It("should abort", func() {
+		Expect(subject.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(subject.Abort()).To(Succeed())
+		Expect(files()).To(ConsistOf("test.spi", "test.spl"))
+		Expect(subject.Commit()).To(Equal(ERROR_LOG_CLOSED))
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+	})
+
+	It("should clean up when commit fails", func() {
+		subject.conf.hashSize = HashSize(3)
+		Expect(subject.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(subject.Commit()).To(Equal(ERROR_HASH_SIZE_INVALID))
+		Expect(files()).To(ConsistOf("test.spi", "test.spl"))
+
+		_, err := os.Stat(fname + ".spl")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should restore existing files when they cannot be replaced", func() {
+		defer func() { renameFile = os.Rename }()
+		renameFile = func(oldpath, newpath string) error {
+			// fail to move the new hash into place, but allow restores
+			if newpath == fname+".spi" && !strings.HasSuffix(oldpath, ".old.spi") {
+				return os.ErrPermission
+			}
+			return os.Rename(oldpath, newpath)
+		}
+
+		Expect(subject.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(subject.Commit()).To(Equal(ERROR_PERMISSION_DENIED))
+		Expect(files()).To(ConsistOf("test.spi", "test.spl"))
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(reader.Get([]byte("k1"))).To(BeNil())
+	})
+
+})
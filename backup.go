@@ -0,0 +1,264 @@
+package sparkey
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBackupCorrupt is returned by Restore when a bundle is malformed or
+// its checksums do not match.
+var ErrBackupCorrupt = errors.New("sparkey: backup bundle is corrupt")
+
+const backupChecksums = "SHA256SUMS"
+
+// Backup streams a consistent snapshot of a hash/log pair to w.
+// The bundle is a tar archive containing the log and hash files, followed by
+// a SHA256SUMS entry with their checksums.
+//
+// It is safe to back up files while a writer is appending to the log or
+// re-writing the hash. The log is included up to the end of the data that
+// was flushed when the backup started, which may contain entries that were
+// appended after the hash file was written. Such entries are not indexed by
+// the restored hash file until it is re-written.
+func Backup(fname string, w io.Writer) error {
+	// Open the hash first, as re-written hash files are always
+	// preceded by a flush of the log
+	hash, err := os.Open(HashFileName(fname))
+	if err != nil {
+		return fileError(err)
+	}
+	defer hash.Close()
+
+	log, err := os.Open(LogFileName(fname))
+	if err != nil {
+		return fileError(err)
+	}
+	defer log.Close()
+
+	// Capture the log header, it is re-written on every flush
+	header := make([]byte, logHeaderSize)
+	if _, err := io.ReadFull(log, header); err != nil {
+		return ERROR_LOG_TOO_SMALL
+	}
+	lhdr, err := decodeLogHeader(header)
+	if err != nil {
+		return err
+	}
+	dataEnd := int64(lhdr.DataEnd)
+
+	hstat, err := hash.Stat()
+	if err != nil {
+		return fileError(err)
+	}
+
+	tw := tar.NewWriter(w)
+	sums := new(strings.Builder)
+
+	logReader := io.MultiReader(bytes.NewReader(header), io.LimitReader(log, dataEnd-logHeaderSize))
+	if err := writeBackupEntry(tw, sums, filepath.Base(LogFileName(fname)), logReader, dataEnd); err != nil {
+		return err
+	}
+	if err := writeBackupEntry(tw, sums, filepath.Base(HashFileName(fname)), hash, hstat.Size()); err != nil {
+		return err
+	}
+	if err := writeBackupEntry(tw, nil, backupChecksums, strings.NewReader(sums.String()), int64(sums.Len())); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeBackupEntry(tw *tar.Writer, sums io.Writer, name string, r io.Reader, size int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if n, err := io.Copy(io.MultiWriter(tw, h), r); err != nil {
+		return fileError(err)
+	} else if n != size {
+		return ERROR_UNEXPECTED_EOF
+	}
+
+	if sums != nil {
+		_, err := fmt.Fprintf(sums, "%x  %s\n", h.Sum(nil), name)
+		return err
+	}
+	return nil
+}
+
+// Restore reads a bundle created by Backup and restores the contained files
+// into dir. All checksums and file identifiers are verified before any of the
+// files are moved into place, existing files with the same names are
+// replaced. If a file cannot be moved into place, the files that were
+// already replaced are restored. The directory is synced once all files are
+// in place.
+func Restore(r io.Reader, dir string) error {
+	type restored struct{ name, tmpname, sum string }
+
+	var files []restored
+	defer func() {
+		for _, f := range files {
+			os.Remove(f.tmpname)
+		}
+	}()
+
+	var sums map[string]string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if hdr.Name == backupChecksums {
+			if sums, err = readBackupChecksums(tr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := hdr.Name
+		if name != filepath.Base(name) || (filepath.Ext(name) != ".spl" && filepath.Ext(name) != ".spi") {
+			return ErrBackupCorrupt
+		}
+
+		tmp, err := ioutil.TempFile(dir, "."+name+".")
+		if err != nil {
+			return fileError(err)
+		}
+		files = append(files, restored{name: name, tmpname: tmp.Name()})
+
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(tmp, h), tr)
+		if err == nil {
+			err = tmp.Chmod(0644)
+		}
+		if err == nil {
+			err = tmp.Sync()
+		}
+		if e := tmp.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return fileError(err)
+		}
+		files[len(files)-1].sum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if len(files) == 0 || len(sums) != len(files) {
+		return ErrBackupCorrupt
+	}
+	for _, f := range files {
+		if sums[f.name] != f.sum {
+			return ErrBackupCorrupt
+		}
+	}
+
+	// Log and hash files with the same name must belong together
+	idents := make(map[string]uint32, len(files))
+	for _, f := range files {
+		ident, err := readFileIdentifier(f.tmpname, filepath.Ext(f.name))
+		if err != nil {
+			return err
+		}
+		stem := strings.TrimSuffix(f.name, filepath.Ext(f.name))
+		if prev, ok := idents[stem]; ok && prev != ident {
+			return ERROR_FILE_IDENTIFIER_MISMATCH
+		}
+		idents[stem] = ident
+	}
+
+	// Set existing files aside, hashes before their logs
+	var moved []restored
+	restore := func() {
+		for _, f := range moved {
+			renameFile(f.tmpname+".old", filepath.Join(dir, f.name))
+		}
+	}
+	for _, ext := range []string{".spi", ".spl"} {
+		for _, f := range files {
+			if filepath.Ext(f.name) != ext {
+				continue
+			}
+			if err := renameFile(filepath.Join(dir, f.name), f.tmpname+".old"); err == nil {
+				moved = append(moved, f)
+			} else if !os.IsNotExist(err) {
+				restore()
+				return fileError(err)
+			}
+		}
+	}
+
+	// Move log files into place before their hashes
+	var placed []restored
+	for _, ext := range []string{".spl", ".spi"} {
+		for _, f := range files {
+			if filepath.Ext(f.name) != ext {
+				continue
+			}
+			if err := renameFile(f.tmpname, filepath.Join(dir, f.name)); err != nil {
+				for _, f := range placed {
+					renameFile(filepath.Join(dir, f.name), f.tmpname)
+				}
+				restore()
+				return fileError(err)
+			}
+			placed = append(placed, f)
+		}
+	}
+
+	for _, f := range moved {
+		os.Remove(f.tmpname + ".old")
+	}
+	files = nil
+	return syncFile(dir)
+}
+
+// readFileIdentifier returns the file identifier from the header of a
+// restored log or hash file.
+func readFileIdentifier(name, ext string) (uint32, error) {
+	if ext == ".spl" {
+		header, err := readLogHeader(name)
+		if err != nil {
+			return 0, err
+		}
+		return header.FileIdentifier, nil
+	}
+
+	header, err := readHashHeader(name)
+	if err != nil {
+		return 0, err
+	}
+	return header.FileIdentifier, nil
+}
+
+func readBackupChecksums(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(parts) != 2 {
+			return nil, ErrBackupCorrupt
+		}
+		sums[parts[1]] = parts[0]
+	}
+	return sums, scanner.Err()
+}
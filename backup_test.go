@@ -0,0 +1,173 @@
+package sparkey
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backup", func() {
+	var fname, restoreDir string
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+
+		restoreDir = filepath.Join(testDir, "restored")
+		Expect(os.Mkdir(restoreDir, 0755)).NotTo(HaveOccurred())
+	})
+
+	It("should backup and restore", func() {
+		buf := new(bytes.Buffer)
+		Expect(Backup(fname, buf)).NotTo(HaveOccurred())
+		Expect(Restore(buf, restoreDir)).NotTo(HaveOccurred())
+
+		entries, _ := filepath.Glob(filepath.Join(restoreDir, "*"))
+		Expect(entries).To(ConsistOf([]string{
+			filepath.Join(restoreDir, "test.spi"),
+			filepath.Join(restoreDir, "test.spl"),
+		}))
+
+		reader, err := Open(filepath.Join(restoreDir, "test"))
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		val, err := reader.Get([]byte("zk"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(val)).To(Equal(veryLongString))
+	})
+
+	It("should only include flushed data", func() {
+		writer, err := OpenLogWriter(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+		Expect(writer.Put([]byte("ak"), []byte("flushed"))).To(Succeed())
+		Expect(writer.Flush()).To(Succeed())
+		Expect(writer.Put([]byte("bk"), []byte("unflushed"))).To(Succeed())
+
+		header, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		buf := new(bytes.Buffer)
+		Expect(Backup(fname, buf)).To(Succeed())
+		Expect(Restore(buf, restoreDir)).To(Succeed())
+
+		restored := filepath.Join(restoreDir, "test")
+		stat, err := os.Stat(LogFileName(restored))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stat.Size()).To(Equal(int64(header.DataEnd)))
+
+		reader, err := Open(restored)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		// the hash is unchanged, the flushed entry is only in the log
+		Expect(reader.Len()).To(Equal(2))
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(reader.Get([]byte("zk"))).To(Equal([]byte(veryLongString)))
+		Expect(reader.Get([]byte("ak"))).To(BeNil())
+		Expect(reader.Get([]byte("bk"))).To(BeNil())
+
+		iter, err := reader.Log().Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+
+		var keys []string
+		for {
+			Expect(iter.Next()).To(Succeed())
+			if !iter.Valid() {
+				break
+			}
+			key, err := iter.Key()
+			Expect(err).NotTo(HaveOccurred())
+			keys = append(keys, string(key))
+		}
+		Expect(keys).To(Equal([]string{"xk", "yk", "zk", "yk", "ak"}))
+	})
+
+	It("should verify checksums on restore", func() {
+		buf := new(bytes.Buffer)
+		Expect(Backup(fname, buf)).NotTo(HaveOccurred())
+
+		data := buf.Bytes()
+		pos := bytes.Index(data, []byte("longvalue"))
+		Expect(pos).To(BeNumerically(">", 0))
+		data[pos] = 'L'
+
+		Expect(Restore(bytes.NewReader(data), restoreDir)).To(Equal(ErrBackupCorrupt))
+		entries, err := ioutil.ReadDir(restoreDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("should reject mismatching files", func() {
+		other := filepath.Join(testDir, "other")
+		Expect(os.Mkdir(other, 0755)).To(Succeed())
+		ofname, err := writeTestHash(other, func(w *LogWriter) error {
+			return w.Put([]byte("ok"), []byte("other"))
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		logData, err := ioutil.ReadFile(LogFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		hashData, err := ioutil.ReadFile(HashFileName(ofname))
+		Expect(err).NotTo(HaveOccurred())
+
+		buf := new(bytes.Buffer)
+		sums := new(strings.Builder)
+		tw := tar.NewWriter(buf)
+		Expect(writeBackupEntry(tw, sums, "test.spl", bytes.NewReader(logData), int64(len(logData)))).To(Succeed())
+		Expect(writeBackupEntry(tw, sums, "test.spi", bytes.NewReader(hashData), int64(len(hashData)))).To(Succeed())
+		Expect(writeBackupEntry(tw, nil, backupChecksums, strings.NewReader(sums.String()), int64(sums.Len()))).To(Succeed())
+		Expect(tw.Close()).To(Succeed())
+
+		Expect(Restore(buf, restoreDir)).To(Equal(ERROR_FILE_IDENTIFIER_MISMATCH))
+		entries, err := ioutil.ReadDir(restoreDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("should keep existing files when they cannot be replaced", func() {
+		ofname, err := writeTestHash(restoreDir, func(w *LogWriter) error {
+			return w.Put([]byte("ok"), []byte("other"))
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() { renameFile = os.Rename }()
+		renameFile = func(oldpath, newpath string) error {
+			// fail to move the restored hash into place, but allow restores
+			if newpath == HashFileName(ofname) && !strings.HasSuffix(oldpath, ".old") {
+				return os.ErrPermission
+			}
+			return os.Rename(oldpath, newpath)
+		}
+
+		buf := new(bytes.Buffer)
+		Expect(Backup(fname, buf)).To(Succeed())
+		Expect(Restore(buf, restoreDir)).To(Equal(ERROR_PERMISSION_DENIED))
+
+		entries, _ := filepath.Glob(filepath.Join(restoreDir, "*"))
+		Expect(entries).To(ConsistOf([]string{
+			HashFileName(ofname),
+			LogFileName(ofname),
+		}))
+
+		reader, err := Open(ofname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Get([]byte("ok"))).To(Equal([]byte("other")))
+		Expect(reader.Get([]byte("xk"))).To(BeNil())
+	})
+
+	It("should fail on missing files", func() {
+		Expect(Backup(filepath.Join(testDir, "missing"), new(bytes.Buffer))).To(HaveOccurred())
+	})
+
+})
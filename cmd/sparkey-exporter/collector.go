@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsm/go-sparkey"
+)
+
+// shardMetrics are the metrics of a single shard
+type shardMetrics struct {
+	Name              string
+	LogSize, HashSize int64
+	Entries, Garbage  uint64
+	Age               time.Duration
+
+	HasResidency              bool
+	LogResident, HashResident float64
+
+	Verified, VerifyOK bool
+	Failed             bool // set if the shard could not be inspected
+}
+
+// collector scans a directory of shards and serves the
+// most recent results.
+type collector struct {
+	dir    string
+	verify bool
+
+	shards []shardMetrics
+	scans  uint64
+	errors uint64
+	mu     sync.RWMutex
+}
+
+func newCollector(dir string, verify bool) *collector {
+	return &collector{dir: dir, verify: verify}
+}
+
+// Scan inspects all shards in the directory. A shard is a hash file with an
+// associated log, it is named after the hash file, without the extension.
+func (c *collector) Scan() {
+	names, err := filepath.Glob(filepath.Join(c.dir, "*.spi"))
+	if err != nil {
+		log.Printf("sparkey-exporter: %s", err)
+		return
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	shards := make([]shardMetrics, 0, len(names))
+	var errors uint64
+	for _, name := range names {
+		m, err := c.scanShard(name, now)
+		if err != nil {
+			log.Printf("sparkey-exporter: %s: %s", name, err)
+			errors++
+		}
+		shards = append(shards, m)
+	}
+
+	c.mu.Lock()
+	c.shards = shards
+	c.scans++
+	c.errors += errors
+	c.mu.Unlock()
+}
+
+func (c *collector) scanShard(hashname string, now time.Time) (shardMetrics, error) {
+	logname := sparkey.LogFileName(hashname)
+	m := shardMetrics{Name: strings.TrimSuffix(filepath.Base(hashname), ".spi"), Failed: true}
+
+	hstat, err := os.Stat(hashname)
+	if err != nil {
+		return m, err
+	}
+	lstat, err := os.Stat(logname)
+	if err != nil {
+		return m, err
+	}
+	m.HashSize, m.LogSize = hstat.Size(), lstat.Size()
+	m.Age = now.Sub(hstat.ModTime())
+
+	header, err := sparkey.ReadHashHeader(hashname)
+	if err != nil {
+		return m, err
+	}
+	m.Entries, m.Garbage = header.NumEntries, header.GarbageSize
+
+	if c.verify {
+		report, err := sparkey.CheckConsistency(logname, hashname)
+		if err != nil {
+			return m, err
+		}
+		m.Verified, m.VerifyOK = true, report.OK()
+		for _, problem := range report.Problems {
+			log.Printf("sparkey-exporter: %s: %s", hashname, problem)
+		}
+	}
+
+	reader, err := sparkey.OpenFiles(logname, hashname)
+	if err != nil {
+		return m, err
+	}
+	defer reader.Close()
+
+	if report, err := sparkey.Residency(reader); err == nil {
+		m.LogResident, m.HashResident = report.Log.Fraction(), report.Hash.Fraction()
+		m.HasResidency = true
+	} else if err != sparkey.ErrResidencyUnsupported {
+		return m, err
+	}
+
+	m.Failed = false
+	return m, nil
+}
+
+// ServeHTTP implements http.Handler
+func (c *collector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	c.writeTo(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+func (c *collector) writeTo(buf *bytes.Buffer) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	header := func(name, typ, help string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	gauge := func(name, help string, fn func(m *shardMetrics) (float64, bool)) {
+		header(name, "gauge", help)
+		for i := range c.shards {
+			m := &c.shards[i]
+			if val, ok := fn(m); ok {
+				fmt.Fprintf(buf, "%s{shard=%s} %s\n", name, promLabel(m.Name), formatFloat(val))
+			}
+		}
+	}
+	fileGauge := func(name, help string, fn func(m *shardMetrics) (float64, float64, bool)) {
+		header(name, "gauge", help)
+		for i := range c.shards {
+			m := &c.shards[i]
+			if lval, hval, ok := fn(m); ok {
+				fmt.Fprintf(buf, "%s{shard=%s,file=\"log\"} %s\n", name, promLabel(m.Name), formatFloat(lval))
+				fmt.Fprintf(buf, "%s{shard=%s,file=\"hash\"} %s\n", name, promLabel(m.Name), formatFloat(hval))
+			}
+		}
+	}
+
+	fileGauge("sparkey_shard_size_bytes", "Size of the shard files.", func(m *shardMetrics) (float64, float64, bool) {
+		return float64(m.LogSize), float64(m.HashSize), !m.Failed
+	})
+	gauge("sparkey_shard_entries", "Number of live entries.", func(m *shardMetrics) (float64, bool) {
+		return float64(m.Entries), !m.Failed
+	})
+	gauge("sparkey_shard_garbage_bytes", "Size of overwritten and deleted entries in the log.", func(m *shardMetrics) (float64, bool) {
+		return float64(m.Garbage), !m.Failed
+	})
+	gauge("sparkey_shard_age_seconds", "Time since the hash file was last written.", func(m *shardMetrics) (float64, bool) {
+		return m.Age.Seconds(), !m.Failed
+	})
+	fileGauge("sparkey_shard_resident_ratio", "Fraction of the shard files resident in the page cache.", func(m *shardMetrics) (float64, float64, bool) {
+		return m.LogResident, m.HashResident, !m.Failed && m.HasResidency
+	})
+	gauge("sparkey_shard_verify_ok", "1 if the last consistency check passed, 0 otherwise.", func(m *shardMetrics) (float64, bool) {
+		return boolValue(m.VerifyOK), !m.Failed && m.Verified
+	})
+	gauge("sparkey_shard_scan_failed", "1 if the shard could not be inspected, 0 otherwise.", func(m *shardMetrics) (float64, bool) {
+		return boolValue(m.Failed), true
+	})
+
+	header("sparkey_exporter_scans_total", "counter", "Number of directory scans.")
+	fmt.Fprintf(buf, "sparkey_exporter_scans_total %d\n", c.scans)
+	header("sparkey_exporter_scan_errors_total", "counter", "Number of shards that could not be inspected.")
+	fmt.Fprintf(buf, "sparkey_exporter_scan_errors_total %d\n", c.errors)
+}
+
+// labelEscaper escapes label values as required by the text exposition
+// format, all other characters, including non-ASCII ones, are kept as is.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabel returns the quoted label value of s.
+func promLabel(s string) string { return `"` + labelEscaper.Replace(s) + `"` }
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+func boolValue(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
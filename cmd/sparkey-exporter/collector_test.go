@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bsm/go-sparkey"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("collector", func() {
+	var dir string
+	var subject *collector
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "sparkey-exporter")
+		Expect(err).NotTo(HaveOccurred())
+
+		writer, err := sparkey.NewLogWriter(filepath.Join(dir, "shard-1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(writer.Put([]byte("k2"), []byte("v2"))).To(Succeed())
+		Expect(writer.Put([]byte("k2"), []byte("v3"))).To(Succeed())
+		Expect(writer.WriteHashFile(sparkey.HASH_SIZE_AUTO)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "broken.spi"), []byte("garbage"), 0644)).To(Succeed())
+
+		subject = newCollector(dir, true)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should scan shards", func() {
+		subject.Scan()
+		Expect(subject.shards).To(HaveLen(2))
+		Expect(subject.errors).To(Equal(uint64(1)))
+
+		broken, shard := subject.shards[0], subject.shards[1]
+		Expect(broken.Name).To(Equal("broken"))
+		Expect(broken.Failed).To(BeTrue())
+
+		Expect(shard.Name).To(Equal("shard-1"))
+		Expect(shard.Failed).To(BeFalse())
+		Expect(shard.Entries).To(Equal(uint64(2)))
+		Expect(shard.Garbage).To(BeNumerically(">", 0))
+		Expect(shard.LogSize).To(BeNumerically(">", 0))
+		Expect(shard.HashSize).To(BeNumerically(">", 0))
+		Expect(shard.Verified).To(BeTrue())
+		Expect(shard.VerifyOK).To(BeTrue())
+	})
+
+	It("should write metrics", func() {
+		subject.Scan()
+
+		var buf bytes.Buffer
+		subject.writeTo(&buf)
+		Expect(buf.String()).To(ContainSubstring("# TYPE sparkey_shard_entries gauge\nsparkey_shard_entries{shard=\"shard-1\"} 2\n"))
+		Expect(buf.String()).To(ContainSubstring("sparkey_shard_size_bytes{shard=\"shard-1\",file=\"log\"} "))
+		Expect(buf.String()).To(ContainSubstring("sparkey_shard_verify_ok{shard=\"shard-1\"} 1\n"))
+		Expect(buf.String()).To(ContainSubstring("sparkey_shard_scan_failed{shard=\"broken\"} 1\n"))
+		Expect(buf.String()).To(ContainSubstring("sparkey_shard_scan_failed{shard=\"shard-1\"} 0\n"))
+		Expect(buf.String()).To(ContainSubstring("sparkey_exporter_scans_total 1\n"))
+		Expect(buf.String()).NotTo(ContainSubstring("sparkey_shard_entries{shard=\"broken\"}"))
+	})
+
+	It("should escape label values", func() {
+		Expect(promLabel("shard-1")).To(Equal(`"shard-1"`))
+		Expect(promLabel("größe-✓")).To(Equal(`"größe-✓"`))
+		Expect(promLabel("a\\b\"c\nd\te")).To(Equal(`"a\\b\"c\nd` + "\t" + `e"`))
+
+		writer, err := sparkey.NewLogWriter(filepath.Join(dir, "größe"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(writer.WriteHashFile(sparkey.HASH_SIZE_AUTO)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		subject.Scan()
+
+		var buf bytes.Buffer
+		subject.writeTo(&buf)
+		Expect(buf.String()).To(ContainSubstring("sparkey_shard_entries{shard=\"größe\"} 1\n"))
+	})
+
+})
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "sparkey-exporter")
+}
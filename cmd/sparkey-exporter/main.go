@@ -0,0 +1,36 @@
+// Command sparkey-exporter watches a directory of shards (hash/log pairs)
+// and exports per-shard metrics in the Prometheus text format.
+//
+//	Usage:
+//
+//	   sparkey-exporter -dir /data/shards -addr :9418 -interval 30s -verify
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+)
+
+func main() {
+	var (
+		dir      = flag.String("dir", ".", "directory of shards to watch")
+		addr     = flag.String("addr", ":9418", "address to listen on")
+		interval = flag.Duration("interval", 30*time.Second, "interval between scans")
+		verify   = flag.Bool("verify", false, "run consistency checks on every scan")
+	)
+	flag.Parse()
+
+	c := newCollector(*dir, *verify)
+	c.Scan()
+	go func() {
+		for range time.Tick(*interval) {
+			c.Scan()
+		}
+	}()
+
+	http.Handle("/metrics", c)
+	log.Printf("sparkey-exporter: watching %s, listening on %s", *dir, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
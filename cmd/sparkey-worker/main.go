@@ -0,0 +1,15 @@
+// Command sparkey-worker is the worker process of sparkey.IsolatedReader.
+// It is started by sparkey.OpenIsolated and must be installed in PATH, or
+// passed to it via sparkey.WithIsolatedWorker. It is not meant to be run
+// directly.
+package main
+
+import (
+	"os"
+
+	"github.com/bsm/go-sparkey"
+)
+
+func main() {
+	os.Exit(sparkey.RunIsolatedWorker())
+}
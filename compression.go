@@ -0,0 +1,100 @@
+package sparkey
+
+import (
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDecoder is shared by all readers, it is safe for concurrent use.
+var zstdDecoder struct {
+	once sync.Once
+	dec  *zstd.Decoder
+	err  error
+}
+
+func getZstdDecoder() (*zstd.Decoder, error) {
+	zstdDecoder.once.Do(func() {
+		zstdDecoder.dec, zstdDecoder.err = zstd.NewReader(nil)
+	})
+	return zstdDecoder.dec, zstdDecoder.err
+}
+
+// blockDecodedLen returns the uncompressed size of a block.
+func blockDecodedLen(compression CompressionType, data []byte) (uint64, error) {
+	switch compression {
+	case COMPRESSION_SNAPPY:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return 0, ERROR_LOG_HEADER_CORRUPT
+		}
+		return uint64(n), nil
+	case COMPRESSION_ZSTD:
+		var h zstd.Header
+		if err := h.Decode(data); err != nil {
+			return 0, ERROR_LOG_HEADER_CORRUPT
+		} else if h.HasFCS {
+			return h.FrameContentSize, nil
+		}
+
+		// encoders may omit the size of small frames
+		block, err := decodeBlock(compression, data)
+		if err != nil {
+			return 0, ERROR_LOG_HEADER_CORRUPT
+		}
+		return uint64(len(block)), nil
+	}
+	return 0, ERROR_INVALID_COMPRESSION_TYPE
+}
+
+// decodeBlock decompresses a block.
+func decodeBlock(compression CompressionType, data []byte) ([]byte, error) {
+	switch compression {
+	case COMPRESSION_SNAPPY:
+		return snappy.Decode(nil, data)
+	case COMPRESSION_ZSTD:
+		dec, err := getZstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(data, nil)
+	}
+	return nil, ERROR_INVALID_COMPRESSION_TYPE
+}
+
+// blockEncoder compresses the blocks of a log writer.
+type blockEncoder struct {
+	compression CompressionType
+	zstd        *zstd.Encoder
+	buf         []byte
+}
+
+// newBlockEncoder creates an encoder, level is only relevant for
+// COMPRESSION_ZSTD, where 0 selects the default level.
+func newBlockEncoder(compression CompressionType, level int) (*blockEncoder, error) {
+	e := &blockEncoder{compression: compression}
+	if compression == COMPRESSION_ZSTD {
+		var opts []zstd.EOption
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+
+		enc, err := zstd.NewWriter(nil, opts...)
+		if err != nil {
+			return nil, err
+		}
+		e.zstd = enc
+	}
+	return e, nil
+}
+
+// encode compresses a block, the result is only valid until the next call.
+func (e *blockEncoder) encode(block []byte) []byte {
+	if e.zstd != nil {
+		e.buf = e.zstd.EncodeAll(block, e.buf[:0])
+	} else {
+		e.buf = snappy.Encode(e.buf[:cap(e.buf)], block)
+	}
+	return e.buf
+}
@@ -0,0 +1,204 @@
+package sparkey
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInconsistent is returned by readers opened WithConsistencyCheck when
+// the consistency check of a hash/log pair fails.
+var ErrInconsistent = errors.New("sparkey: hash and log are inconsistent")
+
+// consistencySampleSize is the (approximate) number of lookups performed
+// by CheckConsistency, in each direction
+const consistencySampleSize = 1024
+
+// ConsistencyReport is returned by CheckConsistency
+type ConsistencyReport struct {
+	// Headers of the log and hash files
+	Log  *LogHeader
+	Hash *HashHeader
+	// Number of put and delete entries, recounted from the log
+	NumPuts, NumDeletes uint64
+	// Number of put entries in the part of the log covered by the hash
+	IndexedPuts uint64
+	// Number of occupied hash slots
+	UsedSlots uint64
+	// Number of sampled lookups and how many of them failed
+	NumSampled, NumFailed uint64
+	// Descriptions of the detected problems
+	Problems []string
+}
+
+// OK returns true if no problems were detected.
+func (r *ConsistencyReport) OK() bool { return len(r.Problems) == 0 }
+
+func (r *ConsistencyReport) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// CheckConsistency recounts the entries of a log and compares them against
+// the counts in the log and hash headers. It also verifies a sample of
+// lookups in both directions, from hash slots to log entries and from log
+// entries to hash slots. Errors are only returned if the files cannot be
+// read, detected inconsistencies are listed in the report.
+func CheckConsistency(logname, hashname string) (*ConsistencyReport, error) {
+	log, err := openLogFile(logname)
+	if err != nil {
+		return nil, err
+	}
+	defer log.close()
+
+	data, unmap, err := mapFile(hashname)
+	if err != nil {
+		return nil, err
+	}
+	defer unmap()
+
+	hash, err := newHashFile(data)
+	if err != nil {
+		return nil, err
+	}
+	hash.log = log
+
+	report := &ConsistencyReport{Log: log.header, Hash: hash.header}
+	if hash.header.FileIdentifier != log.header.FileIdentifier {
+		report.addProblem("file identifier mismatch: hash %d, log %d", hash.header.FileIdentifier, log.header.FileIdentifier)
+		return report, nil
+	} else if hash.header.DataEnd > log.header.DataEnd {
+		report.addProblem("hash covers %d bytes, beyond the log data end at %d", hash.header.DataEnd, log.header.DataEnd)
+		return report, nil
+	}
+
+	if err := checkLogEntries(report, hash); err != nil {
+		return nil, err
+	}
+	if err := checkHashSlots(report, hash); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkLogEntries recounts the log entries and looks up a sample of the
+// indexed puts. Lookups must never resolve to an entry that precedes the
+// sampled one, as the hash always references the most recent put of a key.
+func checkLogEntries(report *ConsistencyReport, hash *hashFile) error {
+	log := hash.log
+	iter, err := newLogCursor(log)
+	if err != nil {
+		return err
+	}
+	probe, err := newLogCursor(log)
+	if err != nil {
+		return err
+	}
+
+	stride := hash.header.NumPuts/consistencySampleSize + 1
+	var key []byte
+	for {
+		if err := iter.next(); err != nil {
+			return err
+		} else if iter.state != ITERATOR_ACTIVE {
+			break
+		}
+
+		if iter.typ != ENTRY_PUT {
+			report.NumDeletes++
+			continue
+		}
+		report.NumPuts++
+
+		if log.filePos(iter.entry) >= hash.header.DataEnd {
+			continue
+		}
+		report.IndexedPuts++
+
+		if report.IndexedPuts%stride != 0 {
+			continue
+		}
+		if key, err = iter.appendKey(key[:0]); err != nil {
+			return err
+		}
+		if err := hash.get(key, probe); err != nil {
+			return err
+		}
+
+		report.NumSampled++
+		if probe.state == ITERATOR_ACTIVE && probe.entry.less(iter.entry) {
+			report.NumFailed++
+			report.addProblem("lookup of %s resolves to stale entry at offset %d", formatKey(key), log.filePos(probe.entry))
+		}
+	}
+
+	if report.NumPuts != log.header.NumPuts {
+		report.addProblem("log contains %d puts, header records %d", report.NumPuts, log.header.NumPuts)
+	}
+	if report.NumDeletes != log.header.NumDeletes {
+		report.addProblem("log contains %d deletes, header records %d", report.NumDeletes, log.header.NumDeletes)
+	}
+	if report.IndexedPuts != hash.header.NumPuts {
+		report.addProblem("hash covers %d puts, header records %d", report.IndexedPuts, hash.header.NumPuts)
+	}
+	return nil
+}
+
+// checkHashSlots counts the occupied hash slots and verifies a sample of
+// them. Sampled slots must reference a put entry with a matching key hash,
+// which in turn must be found by a lookup of its key.
+func checkHashSlots(report *ConsistencyReport, hash *hashFile) error {
+	iter, err := newLogCursor(hash.log)
+	if err != nil {
+		return err
+	}
+	probe, err := newLogCursor(hash.log)
+	if err != nil {
+		return err
+	}
+
+	stride := hash.header.HashCapacity/consistencySampleSize + 1
+	var key []byte
+	for slot := uint64(0); slot < hash.header.HashCapacity; slot++ {
+		hv, addr := hash.slot(slot)
+		if addr == 0 {
+			continue
+		}
+		report.UsedSlots++
+
+		if slot%stride != 0 {
+			continue
+		}
+		report.NumSampled++
+
+		if err := iter.seekAddress(addr, hash.header.EntryBlockBits); err == ERROR_LOG_ITERATOR_INACTIVE {
+			report.NumFailed++
+			report.addProblem("slot %d references invalid address %d", slot, addr)
+			continue
+		} else if err != nil {
+			return err
+		} else if iter.state != ITERATOR_ACTIVE || iter.typ != ENTRY_PUT {
+			report.NumFailed++
+			report.addProblem("slot %d does not reference a put entry", slot)
+			continue
+		}
+
+		if key, err = iter.appendKey(key[:0]); err != nil {
+			return err
+		} else if hashKey(key, hash.header.HashSize, hash.header.HashSeed) != hv {
+			report.NumFailed++
+			report.addProblem("slot %d hash does not match key %s", slot, formatKey(key))
+			continue
+		}
+
+		if err := hash.get(key, probe); err != nil {
+			return err
+		} else if probe.state != ITERATOR_ACTIVE || probe.entry != iter.entry {
+			report.NumFailed++
+			report.addProblem("lookup of %s does not resolve to slot %d", formatKey(key), slot)
+		}
+	}
+
+	if report.UsedSlots != hash.header.NumEntries {
+		report.addProblem("hash contains %d entries, header records %d", report.UsedSlots, hash.header.NumEntries)
+	}
+	return nil
+}
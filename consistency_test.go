@@ -0,0 +1,136 @@
+package sparkey
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckConsistency", func() {
+	var fname string
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeTestHash(testDir, func(w *LogWriter) error {
+			for i := 0; i < 3000; i++ {
+				if err := w.Put([]byte(fmt.Sprintf("k%04d", i%2000)), []byte("value")); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < 2000; i += 5 {
+				if err := w.Delete([]byte(fmt.Sprintf("k%04d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	var rewriteLogHeader = func(fn func(*LogHeader)) {
+		header, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		fn(header)
+
+		file, err := os.OpenFile(LogFileName(fname), os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		_, err = file.WriteAt(encodeLogHeader(header), 0)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("should report consistent files", func() {
+		report, err := CheckConsistency(LogFileName(fname), HashFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Problems).To(BeEmpty())
+		Expect(report.OK()).To(BeTrue())
+		Expect(report.NumPuts).To(Equal(uint64(3000)))
+		Expect(report.NumDeletes).To(Equal(uint64(400)))
+		Expect(report.IndexedPuts).To(Equal(uint64(3000)))
+		Expect(report.UsedSlots).To(Equal(uint64(1600)))
+		Expect(report.NumSampled).To(BeNumerically(">", 1000))
+		Expect(report.NumFailed).To(BeZero())
+	})
+
+	It("should accept logs that have grown since the hash was built", func() {
+		w, err := OpenLogWriter(LogFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Put([]byte("new"), []byte("value"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		report, err := CheckConsistency(LogFileName(fname), HashFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Problems).To(BeEmpty())
+		Expect(report.NumPuts).To(Equal(uint64(3001)))
+		Expect(report.IndexedPuts).To(Equal(uint64(3000)))
+	})
+
+	It("should report count mismatches", func() {
+		rewriteLogHeader(func(h *LogHeader) { h.NumPuts++ })
+
+		report, err := CheckConsistency(LogFileName(fname), HashFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.OK()).To(BeFalse())
+		Expect(report.Problems).To(ConsistOf("log contains 3000 puts, header records 3001"))
+	})
+
+	It("should report hashes of other logs", func() {
+		rewriteLogHeader(func(h *LogHeader) { h.FileIdentifier++ })
+
+		report, err := CheckConsistency(LogFileName(fname), HashFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Problems).To(HaveLen(1))
+		Expect(report.Problems[0]).To(HavePrefix("file identifier mismatch"))
+	})
+
+	It("should report failed lookups", func() {
+		other := filepath.Join(testDir, "other")
+		Expect(writeTestLog(LogFileName(other), func(w *LogWriter) error {
+			for i := 0; i < 3000; i++ {
+				if err := w.Put([]byte(fmt.Sprintf("x%04d", i%2000)), []byte("value")); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < 2000; i += 5 {
+				if err := w.Delete([]byte(fmt.Sprintf("x%04d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})).To(Succeed())
+		Expect(WriteHashFile(other, HASH_SIZE_64BIT)).To(Succeed())
+		Expect(os.Rename(HashFileName(other), HashFileName(fname))).To(Succeed())
+
+		// Pretend that the hash was built from our log
+		header, err := ReadLogHeader(other)
+		Expect(err).NotTo(HaveOccurred())
+		rewriteLogHeader(func(h *LogHeader) { h.FileIdentifier = header.FileIdentifier })
+
+		report, err := CheckConsistency(LogFileName(fname), HashFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.OK()).To(BeFalse())
+		Expect(report.NumFailed).To(BeNumerically(">", 0))
+	})
+
+	It("should fail on missing files", func() {
+		_, err := CheckConsistency(LogFileName(fname), filepath.Join(testDir, "missing.spi"))
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+	})
+
+	It("should check on open", func() {
+		reader, err := Open(fname, WithConsistencyCheck(true))
+		Expect(err).NotTo(HaveOccurred())
+		reader.Close()
+
+		rewriteLogHeader(func(h *LogHeader) { h.NumDeletes-- })
+		_, err = Open(fname, WithConsistencyCheck(true))
+		Expect(err).To(Equal(ErrInconsistent))
+
+		reader, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		reader.Close()
+	})
+})
@@ -0,0 +1,70 @@
+package sparkey
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// ContentHash computes an order-independent digest of all live key/value
+// pairs referenced by the hash. Two readers with the same logical contents
+// will return the same digest, regardless of the order in which the entries
+// were written or how many times they were overwritten or deleted.
+func ContentHash(r *HashReader) (uint64, error) {
+	iter, err := r.Iterator()
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	var sum uint64
+	var lbuf [8]byte
+
+	h := fnv.New64a()
+	for iter.NextLive(); iter.Valid(); iter.NextLive() {
+		h.Reset()
+
+		binary.BigEndian.PutUint64(lbuf[:], iter.KeyLen())
+		h.Write(lbuf[:])
+		if _, err := iter.KeyReader().WriteTo(h); err != nil {
+			return 0, err
+		}
+		if _, err := iter.ValueReader().WriteTo(h); err != nil {
+			return 0, err
+		}
+		sum += mix64(h.Sum64())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+	return sum, nil
+}
+
+// SameContent reports whether two readers hold the same live key/value pairs.
+// It compares the number of live entries first and only falls back to
+// computing (and comparing) content hashes when these match.
+func SameContent(a, b *HashReader) (bool, error) {
+	if a.NumSlots() != b.NumSlots() {
+		return false, nil
+	}
+
+	ha, err := ContentHash(a)
+	if err != nil {
+		return false, err
+	}
+	hb, err := ContentHash(b)
+	if err != nil {
+		return false, err
+	}
+	return ha == hb, nil
+}
+
+// mix64 is a 64-bit finalizer (from splitmix64), which spreads the entry
+// hashes before they are summed up.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,74 @@
+package sparkey
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ContentHash", func() {
+	var subject, other *HashReader
+
+	var openHash = func(name string, cb func(w *LogWriter) error) *HashReader {
+		dir := filepath.Join(testDir, name)
+		Expect(os.Mkdir(dir, 0755)).NotTo(HaveOccurred())
+		fname, err := writeTestHash(dir, cb)
+		Expect(err).NotTo(HaveOccurred())
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		return reader
+	}
+
+	BeforeEach(func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		subject, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+		if other != nil {
+			other.Close()
+		}
+	})
+
+	It("should ignore order and overwritten entries", func() {
+		other = openHash("other", func(w *LogWriter) error {
+			w.Put([]byte("zk"), []byte("replaced"))
+			w.Put([]byte("zk"), []byte(veryLongString))
+			return w.Put([]byte("xk"), []byte("short"))
+		})
+
+		h1, err := ContentHash(subject)
+		Expect(err).NotTo(HaveOccurred())
+		h2, err := ContentHash(other)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h1).To(Equal(h2))
+		Expect(SameContent(subject, other)).To(BeTrue())
+	})
+
+	It("should detect differences", func() {
+		other = openHash("other", func(w *LogWriter) error {
+			w.Put([]byte("zk"), []byte(veryLongString))
+			return w.Put([]byte("x"), []byte("kshort"))
+		})
+
+		h1, err := ContentHash(subject)
+		Expect(err).NotTo(HaveOccurred())
+		h2, err := ContentHash(other)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h1).NotTo(Equal(h2))
+		Expect(SameContent(subject, other)).To(BeFalse())
+	})
+
+	It("should compare entry counts first", func() {
+		other = openHash("other", func(w *LogWriter) error {
+			return w.Put([]byte("xk"), []byte("short"))
+		})
+		Expect(SameContent(subject, other)).To(BeFalse())
+	})
+
+})
@@ -0,0 +1,71 @@
+package sparkey
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Encoding describes the content of a key or value, see DetectEncoding
+type Encoding uint8
+
+const (
+	ENCODING_UTF8   Encoding = 0
+	ENCODING_BINARY Encoding = 1
+)
+
+// String returns the name of the encoding
+func (e Encoding) String() string {
+	if e == ENCODING_UTF8 {
+		return "utf8"
+	}
+	return "binary"
+}
+
+// DetectEncoding returns ENCODING_UTF8 if b is valid UTF-8 and consists of
+// printable characters and whitespace only, ENCODING_BINARY otherwise.
+// Empty slices are considered UTF-8.
+func DetectEncoding(b []byte) Encoding {
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		if r == utf8.RuneError && n < 2 {
+			return ENCODING_BINARY
+		} else if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return ENCODING_BINARY
+		}
+		b = b[n:]
+	}
+	return ENCODING_UTF8
+}
+
+// EscapeKey returns a representation of a key (or value) that is safe to
+// print to terminals and logs. Printable UTF-8 characters are kept, control
+// characters, invalid UTF-8 sequences, quotes and backslashes are escaped
+// using Go's string literal syntax.
+func EscapeKey(b []byte) string {
+	s := strconv.Quote(string(b))
+	return s[1 : len(s)-1]
+}
+
+// HexDump returns a hex dump of b, in the format of `hexdump -C`. Only the
+// first max bytes are dumped, pass a negative max to dump everything.
+func HexDump(b []byte, max int) string {
+	if max > -1 && len(b) > max {
+		return hex.Dump(b[:max]) + "...\n"
+	}
+	return hex.Dump(b)
+}
+
+// maxKeyDump limits the hex dumps of binary keys in messages.
+const maxKeyDump = 64
+
+// formatKey formats a key for consistency reports and error messages.
+// Text keys are quoted and escaped, binary keys are hex dumped.
+func formatKey(key []byte) string {
+	if DetectEncoding(key) == ENCODING_UTF8 {
+		return `"` + EscapeKey(key) + `"`
+	}
+	return "binary key of " + strconv.Itoa(len(key)) + " bytes\n" + strings.TrimSuffix(HexDump(key, maxKeyDump), "\n")
+}
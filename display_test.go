@@ -0,0 +1,42 @@
+package sparkey
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Display helpers", func() {
+
+	It("should detect encodings", func() {
+		Expect(DetectEncoding(nil)).To(Equal(ENCODING_UTF8))
+		Expect(DetectEncoding([]byte("plain key"))).To(Equal(ENCODING_UTF8))
+		Expect(DetectEncoding([]byte("tabs\tand\nnewlines"))).To(Equal(ENCODING_UTF8))
+		Expect(DetectEncoding([]byte("grüße, 世界"))).To(Equal(ENCODING_UTF8))
+		Expect(DetectEncoding([]byte("esc\x1b[31m"))).To(Equal(ENCODING_BINARY))
+		Expect(DetectEncoding([]byte{0, 0, 0, 1})).To(Equal(ENCODING_BINARY))
+		Expect(DetectEncoding([]byte{'a', 0xff, 'b'})).To(Equal(ENCODING_BINARY))
+		Expect(ENCODING_UTF8.String()).To(Equal("utf8"))
+		Expect(ENCODING_BINARY.String()).To(Equal("binary"))
+	})
+
+	It("should escape keys", func() {
+		Expect(EscapeKey(nil)).To(Equal(""))
+		Expect(EscapeKey([]byte("plain key"))).To(Equal("plain key"))
+		Expect(EscapeKey([]byte("grüße"))).To(Equal("grüße"))
+		Expect(EscapeKey([]byte("a\"b\\c"))).To(Equal(`a\"b\\c`))
+		Expect(EscapeKey([]byte("esc\x1b[31m\n"))).To(Equal(`esc\x1b[31m\n`))
+		Expect(EscapeKey([]byte{0, 'a', 0xff})).To(Equal(`\x00a\xff`))
+	})
+
+	It("should dump hex", func() {
+		Expect(HexDump(nil, -1)).To(Equal(""))
+		Expect(HexDump([]byte("key\x00"), -1)).To(Equal("00000000  6b 65 79 00                                       |key.|\n"))
+		Expect(HexDump([]byte("key\x00"), 2)).To(Equal("00000000  6b 65                                             |ke|\n...\n"))
+	})
+
+	It("should format keys", func() {
+		Expect(formatKey([]byte("a\"b"))).To(Equal(`"a\"b"`))
+		Expect(formatKey([]byte("key\x00"))).To(Equal("binary key of 4 bytes\n00000000  6b 65 79 00                                       |key.|"))
+	})
+
+})
@@ -0,0 +1,119 @@
+package sparkey
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// ErrDuplicateKey is returned by writers created WithDuplicatePolicy(DuplicateError)
+// when a key is put more than once.
+var ErrDuplicateKey = errors.New("sparkey: duplicate key")
+
+// DuplicatePolicy determines how writers handle keys that are put more than
+// once, see WithDuplicatePolicy.
+type DuplicatePolicy int
+
+const (
+	// DuplicateLastWins appends all puts, the last one wins. This is the
+	// default and has no overhead.
+	DuplicateLastWins DuplicatePolicy = iota
+	// DuplicateFirstWins ignores puts of keys that have been put before.
+	DuplicateFirstWins
+	// DuplicateError rejects puts of keys that have been put before with
+	// ErrDuplicateKey.
+	DuplicateError
+)
+
+// MergeFunc merges the older and the newer value of a key that is put more
+// than once, see WithMergeFunc.
+type MergeFunc func(key, older, newer []byte) ([]byte, error)
+
+// duplicateFilter applies a DuplicatePolicy or MergeFunc to the puts of a
+// writer. Only keys put through the writer are tracked, a delete forgets a
+// key. Keys are kept verbatim rather than hashed, so that hash collisions
+// can never drop or reject a put, the cost is documented on the options.
+type duplicateFilter struct {
+	policy DuplicatePolicy
+	merge  MergeFunc
+	keys   map[string][]byte // latest values with merge, nil otherwise
+}
+
+func newDuplicateFilter(conf *config) *duplicateFilter {
+	if conf.duplicatePolicy == DuplicateLastWins && conf.mergeFunc == nil {
+		return nil
+	}
+	return &duplicateFilter{policy: conf.duplicatePolicy, merge: conf.mergeFunc, keys: make(map[string][]byte)}
+}
+
+// resolve returns the value to put for key, and false if the put is to be
+// skipped.
+func (f *duplicateFilter) resolve(key, value []byte) ([]byte, bool, error) {
+	older, ok := f.keys[string(key)]
+	switch {
+	case !ok:
+		return value, true, nil
+	case f.merge != nil:
+		merged, err := f.merge(key, older, value)
+		return merged, err == nil, err
+	case f.policy == DuplicateFirstWins:
+		return nil, false, nil
+	case f.policy == DuplicateError:
+		return nil, false, ErrDuplicateKey
+	}
+	return value, true, nil
+}
+
+// put records a put, once it has been written.
+func (f *duplicateFilter) put(key, value []byte) {
+	if f.merge != nil {
+		f.keys[string(key)] = append([]byte{}, value...)
+	} else {
+		f.keys[string(key)] = nil
+	}
+}
+
+// delete records a delete, once it has been written.
+func (f *duplicateFilter) delete(key []byte) {
+	delete(f.keys, string(key))
+}
+
+// putFiltered is Put for writers with a duplicate filter.
+func (w *LogWriter) putFiltered(key, value []byte) error {
+	value, ok, err := w.dups.resolve(key, value)
+	if err != nil || !ok {
+		return err
+	}
+	if err := w.put(key, value); err != nil {
+		return err
+	}
+	w.dups.put(key, value)
+	return nil
+}
+
+// putReaderFiltered is PutReader for writers with a duplicate filter. Values
+// are only read into memory if they may have to be merged.
+func (w *LogWriter) putReaderFiltered(key []byte, r io.Reader, size int64) error {
+	if w.dups.merge == nil {
+		if _, ok := w.dups.keys[string(key)]; ok {
+			_, _, err := w.dups.resolve(key, nil)
+			return err
+		}
+		if err := w.putReader(key, r, size); err != nil {
+			return err
+		}
+		w.dups.put(key, nil)
+		return nil
+	}
+
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	} else if size >= 0 && int64(len(value)) != size {
+		return ERROR_UNEXPECTED_EOF
+	}
+	return w.putFiltered(key, value)
+}
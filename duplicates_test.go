@@ -0,0 +1,88 @@
+package sparkey
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DuplicatePolicy", func() {
+	var fname string
+
+	var build = func(opts ...Option) (*HashReader, error) {
+		w, err := NewLogWriter(fname, opts...)
+		Expect(err).NotTo(HaveOccurred())
+		defer w.Close()
+
+		if err := w.Put([]byte("a"), []byte("1")); err != nil {
+			return nil, err
+		}
+		if err := w.PutBatch([]Entry{
+			{Type: ENTRY_PUT, Key: []byte("b"), Value: []byte("2")},
+			{Type: ENTRY_PUT, Key: []byte("a"), Value: []byte("3")},
+		}); err != nil {
+			return nil, err
+		}
+		if err := w.PutReader([]byte("b"), strings.NewReader("4"), 1); err != nil {
+			return nil, err
+		}
+		if err := w.Delete([]byte("b")); err != nil {
+			return nil, err
+		}
+		if err := w.Put([]byte("b"), []byte("5")); err != nil {
+			return nil, err
+		}
+		Expect(w.WriteHashFile(HASH_SIZE_AUTO)).To(Succeed())
+		return Open(fname)
+	}
+
+	BeforeEach(func() {
+		fname = filepath.Join(testDir, "dups")
+	})
+
+	It("should let the last put win by default", func() {
+		reader, err := build()
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.Log().Header().NumPuts).To(Equal(uint64(5)))
+		Expect(reader.Get([]byte("a"))).To(Equal([]byte("3")))
+		Expect(reader.Get([]byte("b"))).To(Equal([]byte("5")))
+	})
+
+	It("should let the first put win", func() {
+		reader, err := build(WithDuplicatePolicy(DuplicateFirstWins))
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.Log().Header().NumPuts).To(Equal(uint64(3)))
+		Expect(reader.Get([]byte("a"))).To(Equal([]byte("1")))
+		Expect(reader.Get([]byte("b"))).To(Equal([]byte("5")))
+	})
+
+	It("should reject duplicates", func() {
+		_, err := build(WithDuplicatePolicy(DuplicateError))
+		Expect(err).To(Equal(ErrDuplicateKey))
+	})
+
+	It("should merge values", func() {
+		reader, err := build(WithMergeFunc(func(key, older, newer []byte) ([]byte, error) {
+			return bytes.Join([][]byte{key, older, newer}, []byte(",")), nil
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.Get([]byte("a"))).To(Equal([]byte("a,1,3")))
+		Expect(reader.Get([]byte("b"))).To(Equal([]byte("5")))
+
+		iter, err := reader.Log().Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+		Expect(iter.Skip(4)).To(Succeed())
+		Expect(iter.Value()).To(Equal([]byte("b,2,4")))
+	})
+
+})
@@ -1,12 +1,11 @@
 package sparkey
 
-//#include <sparkey/sparkey.h>
-import "C"
-import "strconv"
-
-const (
-	rc_SUCCESS      C.sparkey_returncode = 0
-	rc_ITERINACTIVE C.sparkey_returncode = 205
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 const (
@@ -47,6 +46,10 @@ const (
 	ERROR_HASH_SIZE_INVALID              Error = -307
 )
 
+// ErrSharedLogReader is returned when attempting to refresh a LogReader
+// that is owned by a HashReader.
+var ErrSharedLogReader = errors.New("sparkey: log reader is owned by a hash reader")
+
 type Error int
 
 // Error implements the error interface
@@ -58,11 +61,88 @@ func (e Error) Error() string {
 	return "sparkey: unknown error (" + strconv.Itoa(code) + ")"
 }
 
-func errorOrNil(rc C.sparkey_returncode) error {
-	if rc == rc_SUCCESS {
-		return nil
+// Retryable returns true if the error is transient, i.e. if the
+// operation that caused it may succeed when retried. Running out of file
+// descriptors is not considered transient, the limit rarely clears within
+// the time of a retry.
+func (e Error) Retryable() bool {
+	return e == ERROR_FILE_BUSY
+}
+
+// IsRetryable returns true if err is, or wraps, a transient Error or an
+// interrupted (EINTR) or temporarily unavailable (EAGAIN) system call.
+func IsRetryable(err error) bool {
+	var serr Error
+	if errors.As(err, &serr) {
+		return serr.Retryable()
+	}
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// OpError is returned when an operation on a file failed with a transient
+// error and kept failing after it was retried.
+type OpError struct {
+	Op       string // operation, e.g. "open"
+	Path     string // name of the file
+	Attempts int    // number of attempts made
+	Err      error  // error of the last attempt
+}
+
+// Error implements the error interface
+func (e *OpError) Error() string {
+	return "sparkey: " + e.Op + " " + e.Path + " failed after " + strconv.Itoa(e.Attempts) + " attempts: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *OpError) Unwrap() error { return e.Err }
+
+// Retryable returns true if the error of the last attempt is transient,
+// i.e. if the operation may still succeed at a later point.
+func (e *OpError) Retryable() bool { return IsRetryable(e.Err) }
+
+// Retry settings for opening files
+var (
+	openRetries = 3
+	openBackoff = 10 * time.Millisecond
+)
+
+// retryOpen calls fn once, and retries it with exponential backoff for
+// as long as the returned error is retryable. Transient errors which
+// persist after all retries are returned as an *OpError.
+func retryOpen(op, path string, fn func() error) error {
+	backoff := openBackoff
+	err := fn()
+	attempts := 1
+	for ; attempts <= openRetries && IsRetryable(err); attempts++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	if IsRetryable(err) {
+		return &OpError{Op: op, Path: path, Attempts: attempts, Err: err}
+	}
+	return err
+}
+
+// fileError translates file system errors into their sparkey equivalents.
+func fileError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return ERROR_FILE_NOT_FOUND
+	case errors.Is(err, os.ErrPermission):
+		return ERROR_PERMISSION_DENIED
+	case errors.Is(err, os.ErrExist):
+		return ERROR_FILE_ALREADY_EXISTS
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return ERROR_TOO_MANY_OPEN_FILES
+	case errors.Is(err, syscall.EISDIR):
+		return ERROR_FILE_IS_DIRECTORY
+	case errors.Is(err, syscall.EFBIG):
+		return ERROR_FILE_TOO_LARGE
+	case errors.Is(err, syscall.ENOSPC):
+		return ERROR_OUT_OF_DISK
 	}
-	return Error(rc)
+	return err
 }
 
 var errorMessages = map[int]string{
@@ -0,0 +1,19 @@
+//go:build !purego
+// +build !purego
+
+package sparkey
+
+//#include <sparkey/sparkey.h>
+import "C"
+
+const (
+	rc_SUCCESS      C.sparkey_returncode = 0
+	rc_ITERINACTIVE C.sparkey_returncode = 205
+)
+
+func errorOrNil(rc C.sparkey_returncode) error {
+	if rc == rc_SUCCESS {
+		return nil
+	}
+	return Error(rc)
+}
@@ -1,6 +1,12 @@
 package sparkey
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -12,4 +18,83 @@ var _ = Describe("Error", func() {
 		Expect(Error(23).Error()).To(Equal("sparkey: unknown error (23)"))
 	})
 
+	It("should classify transient errors", func() {
+		Expect(ERROR_TOO_MANY_OPEN_FILES.Retryable()).To(BeFalse())
+		Expect(ERROR_FILE_BUSY.Retryable()).To(BeTrue())
+		Expect(ERROR_MMAP_FAILED.Retryable()).To(BeFalse())
+		Expect(ERROR_FILE_NOT_FOUND.Retryable()).To(BeFalse())
+		Expect(ERROR_HASH_HEADER_CORRUPT.Retryable()).To(BeFalse())
+
+		Expect(IsRetryable(ERROR_FILE_BUSY)).To(BeTrue())
+		Expect(IsRetryable(fmt.Errorf("wrapped: %w", ERROR_FILE_BUSY))).To(BeTrue())
+		Expect(IsRetryable(ERROR_LOG_CLOSED)).To(BeFalse())
+		Expect(IsRetryable(errors.New("other"))).To(BeFalse())
+		Expect(IsRetryable(nil)).To(BeFalse())
+
+		Expect(IsRetryable(syscall.EINTR)).To(BeTrue())
+		Expect(IsRetryable(&os.PathError{Op: "open", Path: "x.spl", Err: syscall.EAGAIN})).To(BeTrue())
+		Expect(IsRetryable(fmt.Errorf("wrapped: %w", syscall.EINTR))).To(BeTrue())
+		Expect(IsRetryable(&os.PathError{Op: "open", Path: "x.spl", Err: syscall.EMFILE})).To(BeFalse())
+	})
+
+	It("should describe failed operations", func() {
+		err := &OpError{Op: "open", Path: "x.spi", Attempts: 4, Err: ERROR_FILE_BUSY}
+		Expect(err.Error()).To(Equal("sparkey: open x.spi failed after 4 attempts: sparkey: file is busy"))
+		Expect(err.Retryable()).To(BeTrue())
+		Expect(errors.Is(err, ERROR_FILE_BUSY)).To(BeTrue())
+		Expect(IsRetryable(err)).To(BeTrue())
+	})
+
+})
+
+var _ = Describe("retryOpen", func() {
+
+	var backoff time.Duration
+
+	BeforeEach(func() {
+		backoff, openBackoff = openBackoff, time.Millisecond
+	})
+
+	AfterEach(func() {
+		openBackoff = backoff
+	})
+
+	It("should stop on permanent errors", func() {
+		calls := 0
+		err := retryOpen("open", "x.spl", func() error {
+			calls++
+			return ERROR_FILE_NOT_FOUND
+		})
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should retry transient errors", func() {
+		calls := 0
+		err := retryOpen("open", "x.spl", func() error {
+			if calls++; calls < 3 {
+				return syscall.EINTR
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal(3))
+	})
+
+	It("should give up eventually", func() {
+		calls := 0
+		err := retryOpen("open", "x.spl", func() error {
+			calls++
+			return ERROR_FILE_BUSY
+		})
+		Expect(err).To(MatchError(ERROR_FILE_BUSY))
+		Expect(calls).To(Equal(openRetries + 1))
+
+		var opErr *OpError
+		Expect(errors.As(err, &opErr)).To(BeTrue())
+		Expect(opErr.Op).To(Equal("open"))
+		Expect(opErr.Path).To(Equal("x.spl"))
+		Expect(opErr.Attempts).To(Equal(openRetries + 1))
+	})
+
 })
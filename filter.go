@@ -0,0 +1,172 @@
+package sparkey
+
+import (
+	"bytes"
+	"context"
+)
+
+// FilterIter wraps an iterator and only stops at entries which pass all of
+// its filters, up to an optional limit. Filters and limits compose:
+//
+//	iter, _ := reader.Iterator()
+//	scan := iter.SkipKeysWithPrefix([]byte("tmp:")).Filter(isUser).Limit(10)
+//	for scan.Next(); scan.Valid(); scan.Next() {
+//		...
+//	}
+//
+// Moving the wrapped iterator directly bypasses the filters.
+type FilterIter struct {
+	*LogIter
+	next    func() error
+	filters []func(EntryType, []byte) bool
+	limit   int // negative for no limit
+	count   int // entries returned so far
+	done    bool
+	key     []byte // buffer for filtered keys
+}
+
+func newFilterIter(iter *LogIter, next func() error) *FilterIter {
+	return &FilterIter{LogIter: iter, next: next, limit: -1}
+}
+
+// Filter returns an iterator which only stops at entries for which pred
+// returns true. The key passed to pred is only valid for the duration of
+// the call.
+func (i *LogIter) Filter(pred func(typ EntryType, key []byte) bool) *FilterIter {
+	return newFilterIter(i, i.Next).Filter(pred)
+}
+
+// Limit returns an iterator which stops after n entries.
+func (i *LogIter) Limit(n int) *FilterIter {
+	return newFilterIter(i, i.Next).Limit(n)
+}
+
+// SkipKeysWithPrefix returns an iterator which skips entries with keys
+// that start with prefix.
+func (i *LogIter) SkipKeysWithPrefix(prefix []byte) *FilterIter {
+	return newFilterIter(i, i.Next).SkipKeysWithPrefix(prefix)
+}
+
+// Filter is like LogIter.Filter, but only stops at live entries.
+func (i *HashIter) Filter(pred func(typ EntryType, key []byte) bool) *FilterIter {
+	return newFilterIter(i.LogIter, i.NextLive).Filter(pred)
+}
+
+// Limit is like LogIter.Limit, but only stops at live entries.
+func (i *HashIter) Limit(n int) *FilterIter {
+	return newFilterIter(i.LogIter, i.NextLive).Limit(n)
+}
+
+// SkipKeysWithPrefix is like LogIter.SkipKeysWithPrefix, but only stops at
+// live entries.
+func (i *HashIter) SkipKeysWithPrefix(prefix []byte) *FilterIter {
+	return newFilterIter(i.LogIter, i.NextLive).SkipKeysWithPrefix(prefix)
+}
+
+// Filter adds a filter, see LogIter.Filter, and returns the iterator.
+func (f *FilterIter) Filter(pred func(typ EntryType, key []byte) bool) *FilterIter {
+	f.filters = append(f.filters, pred)
+	return f
+}
+
+// Limit sets the maximum number of entries, see LogIter.Limit, and returns
+// the iterator. Entries which have already been returned are counted.
+func (f *FilterIter) Limit(n int) *FilterIter {
+	f.limit = n
+	return f
+}
+
+// SkipKeysWithPrefix adds a filter, see LogIter.SkipKeysWithPrefix, and
+// returns the iterator.
+func (f *FilterIter) SkipKeysWithPrefix(prefix []byte) *FilterIter {
+	prefix = append([]byte{}, prefix...)
+	return f.Filter(func(_ EntryType, key []byte) bool {
+		return !bytes.HasPrefix(key, prefix)
+	})
+}
+
+// Next positions the iterator at the next entry which passes the filters.
+func (f *FilterIter) Next() error {
+	if f.done {
+		return nil
+	} else if f.limit >= 0 && f.count >= f.limit {
+		f.done = true
+		return nil
+	}
+
+	for {
+		if err := f.next(); err != nil {
+			return err
+		} else if !f.LogIter.Valid() {
+			return nil
+		}
+
+		ok, err := f.accept()
+		if err != nil {
+			return err
+		} else if ok {
+			f.count++
+			return nil
+		}
+	}
+}
+
+// NextContext is like Next, but returns the context's error without moving
+// the iterator if ctx is done.
+func (f *FilterIter) NextContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Next()
+}
+
+// Skip skips a number of entries which pass the filters.
+func (f *FilterIter) Skip(count int) error {
+	for ; count > 0; count-- {
+		if err := f.Next(); err != nil {
+			return err
+		} else if !f.Valid() {
+			break
+		}
+	}
+	return nil
+}
+
+// State returns the iterator state, ITERATOR_CLOSED once the limit has been
+// reached.
+func (f *FilterIter) State() IteratorState {
+	if f.done {
+		return ITERATOR_CLOSED
+	}
+	return f.LogIter.State()
+}
+
+// Valid returns true if iterator is at a valid position
+func (f *FilterIter) Valid() bool {
+	return f.State() == ITERATOR_ACTIVE
+}
+
+// accept applies the filters to the current entry. The key is read and the
+// entry rewound, so that it can be read again.
+func (f *FilterIter) accept() (bool, error) {
+	if len(f.filters) == 0 {
+		return true, nil
+	}
+
+	key, err := f.KeyInto(f.key[:0])
+	if err != nil {
+		return false, err
+	}
+	f.key = key
+	if err := f.Reset(); err != nil {
+		return false, err
+	}
+
+	typ := f.EntryType()
+	for _, pred := range f.filters {
+		if !pred(typ, key) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
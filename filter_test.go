@@ -0,0 +1,77 @@
+package sparkey
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FilterIter", func() {
+	var reader *HashReader
+	var iter *HashIter
+
+	BeforeEach(func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		reader, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		iter, err = reader.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		iter.Close()
+		reader.Close()
+	})
+
+	scan := func(f *FilterIter) []string {
+		var keys []string
+		for f.Next(); f.Valid(); f.Next() {
+			key, err := f.Key()
+			Expect(err).NotTo(HaveOccurred())
+			keys = append(keys, string(key))
+		}
+		Expect(f.Err()).NotTo(HaveOccurred())
+		return keys
+	}
+
+	It("should filter entries", func() {
+		Expect(scan(iter.LogIter.Filter(func(typ EntryType, _ []byte) bool {
+			return typ == ENTRY_DELETE
+		}))).To(Equal([]string{"yk"}))
+	})
+
+	It("should filter live entries", func() {
+		Expect(scan(iter.Filter(func(_ EntryType, key []byte) bool {
+			return string(key) != "xk"
+		}))).To(Equal([]string{"zk"}))
+	})
+
+	It("should limit entries", func() {
+		f := iter.LogIter.Limit(2)
+		Expect(scan(f)).To(Equal([]string{"xk", "yk"}))
+		Expect(f.State()).To(Equal(ITERATOR_CLOSED))
+		Expect(f.LogIter.State()).To(Equal(ITERATOR_ACTIVE))
+	})
+
+	It("should compose", func() {
+		f := iter.LogIter.SkipKeysWithPrefix([]byte("x")).Filter(func(typ EntryType, _ []byte) bool {
+			return typ == ENTRY_PUT
+		}).Limit(1)
+		Expect(scan(f)).To(Equal([]string{"yk"}))
+
+		f = iter.SkipKeysWithPrefix([]byte("z")).Limit(5)
+		Expect(scan(f)).To(BeEmpty())
+	})
+
+	It("should keep entries readable", func() {
+		f := iter.SkipKeysWithPrefix([]byte("x"))
+		Expect(f.Next()).To(Succeed())
+		entry, err := f.Entry()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entry.Key).To(Equal([]byte("zk")))
+		Expect(entry.Value).To(Equal([]byte(veryLongString)))
+		Expect(f.Skip(1)).To(Succeed())
+		Expect(f.Valid()).To(BeFalse())
+	})
+
+})
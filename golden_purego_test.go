@@ -0,0 +1,8 @@
+//go:build purego
+// +build purego
+
+package sparkey
+
+// goldenWriter is the name of the implementation which writes the golden
+// files of this build
+const goldenWriter = "purego"
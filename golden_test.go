@@ -0,0 +1,143 @@
+package sparkey
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bsm/go-sparkey/testgen"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// goldenUpdate rewrites the golden files of the current build, see the
+// golden target of the Makefile.
+var goldenUpdate = flag.Bool("golden.update", false, "write the golden files of the current build")
+
+// goldenOptions are the settings of the golden files
+var goldenOptions = map[string]*Options{
+	"none":   nil,
+	"snappy": {Compression: COMPRESSION_SNAPPY, CompressionBlockSize: 1024},
+	"zstd":   {Compression: COMPRESSION_ZSTD, CompressionBlockSize: 1024},
+}
+
+// goldenOps returns the generator of the golden dataset
+func goldenOps() *testgen.Generator {
+	return testgen.New(testgen.Config{
+		Seed:            42,
+		Count:           400,
+		KeySpace:        300,
+		KeyDist:         testgen.Uniform,
+		MinValueSize:    1,
+		MaxValueSize:    200,
+		Compressibility: 0.5,
+		DeleteRatio:     0.1,
+	})
+}
+
+// goldenName returns the base name of the golden files written by writer,
+// either "libsparkey" or "purego", with the given compression.
+func goldenName(writer, compression string) string {
+	return filepath.Join("testdata", "golden", writer+"-"+compression)
+}
+
+func writeGolden(fname string, opts *Options) error {
+	w, err := CreateLogWriter(fname, opts)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	gen := goldenOps()
+	for op, ok := gen.Next(); ok; op, ok = gen.Next() {
+		if op.Delete {
+			err = w.Delete(op.Key)
+		} else {
+			err = w.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return WriteHashFile(fname, HASH_SIZE_AUTO)
+}
+
+func verifyGolden(fname string) {
+	reader, err := Open(fname)
+	Expect(err).NotTo(HaveOccurred())
+	defer reader.Close()
+
+	// the log contains all operations, in order
+	iter, err := reader.Log().Iterator()
+	Expect(err).NotTo(HaveOccurred())
+	defer iter.Close()
+
+	live := make(map[string][]byte)
+	gen := goldenOps()
+	for op, ok := gen.Next(); ok; op, ok = gen.Next() {
+		Expect(iter.Next()).To(Succeed())
+		Expect(iter.Valid()).To(BeTrue())
+		Expect(iter.Key()).To(Equal(op.Key))
+		if op.Delete {
+			Expect(iter.EntryType()).To(Equal(ENTRY_DELETE))
+			delete(live, string(op.Key))
+		} else {
+			Expect(iter.EntryType()).To(Equal(ENTRY_PUT))
+			Expect(iter.Value()).To(Equal(op.Value))
+			live[string(op.Key)] = op.Value
+		}
+	}
+	Expect(iter.Next()).To(Succeed())
+	Expect(iter.Valid()).To(BeFalse())
+
+	// the hash resolves the live entries
+	Expect(reader.Len()).To(Equal(len(live)))
+	for key, value := range live {
+		Expect(reader.Get([]byte(key))).To(Equal(value), key)
+	}
+	for i := 0; i < 300; i++ {
+		key := goldenKey(i)
+		if _, ok := live[string(key)]; !ok {
+			Expect(reader.Get(key)).To(BeNil(), string(key))
+		}
+	}
+}
+
+// goldenKey returns the i-th key of the key space of the golden dataset
+func goldenKey(i int) []byte {
+	return []byte(fmt.Sprintf("%012d", i))
+}
+
+var _ = Describe("Golden files", func() {
+
+	for _, compression := range []string{"none", "snappy", "zstd"} {
+		compression := compression
+
+		It("should write "+compression+" files", func() {
+			fname := goldenName(goldenWriter, compression)
+			if !*goldenUpdate {
+				fname = filepath.Join(testDir, "golden")
+			} else {
+				Expect(os.MkdirAll(filepath.Dir(fname), 0755)).To(Succeed())
+			}
+			Expect(writeGolden(fname, goldenOptions[compression])).To(Succeed())
+			verifyGolden(fname)
+		})
+
+		for _, writer := range []string{"libsparkey", "purego"} {
+			writer := writer
+
+			It("should read "+compression+" files written by "+writer, func() {
+				fname := goldenName(writer, compression)
+				Expect(LogFileName(fname)).To(BeAnExistingFile(), "golden files of %s are missing, run make golden", writer)
+				Expect(HashFileName(fname)).To(BeAnExistingFile(), "golden files of %s are missing, run make golden", writer)
+				verifyGolden(fname)
+			})
+		}
+	}
+
+})
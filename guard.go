@@ -0,0 +1,87 @@
+package sparkey
+
+import (
+	"errors"
+	"runtime/debug"
+)
+
+// ErrFault is returned by readers opened WithFaultRecovery when a mapped
+// file can no longer be accessed, typically because it was truncated or
+// its backing storage (e.g. an NFS mount) has become unavailable.
+var ErrFault = errors.New("sparkey: fault accessing mapped file")
+
+// guard runs fn and converts memory faults into ErrFault. Faults inside
+// libsparkey cannot be intercepted, so fn must only use native Go code.
+func guard(fn func() error) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(interface{ Addr() uintptr }); !ok {
+				panic(e)
+			}
+			err = ErrFault
+		}
+	}()
+	return fn()
+}
+
+// guardedGet looks up a key via the native view of the files.
+func (r *HashReader) guardedGet(key []byte) (val []byte, err error) {
+	err = guard(func() error {
+		c, err := r.guarded.lookup(key)
+		if err != nil || c.state != ITERATOR_ACTIVE {
+			return err
+		}
+		val, err = readValue(c)
+		return err
+	})
+	return
+}
+
+// guardedEntry looks up the entry of a key via the native view of the files.
+func (r *HashReader) guardedEntry(key []byte) (entry *Entry, err error) {
+	err = guard(func() error {
+		c, err := r.guarded.lookup(key)
+		if err != nil || c.state != ITERATOR_ACTIVE {
+			return err
+		}
+
+		e := &Entry{Type: ENTRY_PUT, Key: append([]byte{}, key...), KeyLen: c.keyLen, ValueLen: c.valueLen}
+		if c.log.header.Compression == COMPRESSION_NONE {
+			e.Offset = c.log.filePos(c.entry)
+		}
+		if e.Value, err = readValue(c); err != nil {
+			return err
+		}
+		entry = e
+		return nil
+	})
+	return
+}
+
+// guardedExists checks the existence of a key via the native view of the files.
+func (r *HashReader) guardedExists(key []byte) (ok bool, err error) {
+	err = guard(func() error {
+		c, err := r.guarded.lookup(key)
+		if err != nil {
+			return err
+		}
+		ok = c.state == ITERATOR_ACTIVE
+		return nil
+	})
+	return
+}
+
+// guardedGetMulti looks up multiple keys via the native view of the files.
+func (r *HashReader) guardedGetMulti(keys [][]byte, fn func(i int, val []byte) error) error {
+	for i, key := range keys {
+		val, err := r.guardedGet(key)
+		if err != nil {
+			return err
+		}
+		if err := fn(i, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
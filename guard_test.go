@@ -0,0 +1,62 @@
+package sparkey
+
+import (
+	"os"
+	"runtime"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fault recovery", func() {
+	var fname string
+	var subject *HashReader
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		subject, err = Open(fname, WithFaultRecovery(true))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should retrieve values", func() {
+		Expect(subject.guarded).NotTo(BeNil())
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(subject.Get([]byte("yk"))).To(BeNil())
+		Expect(subject.Get([]byte("missing"))).To(BeNil())
+		Expect(subject.Exists([]byte("zk"))).To(BeTrue())
+		Expect(subject.Exists([]byte("yk"))).To(BeFalse())
+		Expect(subject.GetMulti([][]byte{[]byte("zk"), []byte("yk")})).To(Equal([][]byte{[]byte(veryLongString), nil}))
+		Expect(subject.pool).To(BeEmpty())
+	})
+
+	It("should retrieve entries", func() {
+		Expect(subject.Entry([]byte("xk"))).To(Equal(&Entry{Type: ENTRY_PUT, Key: []byte("xk"), Value: []byte("short"), Offset: logHeaderSize, KeyLen: 2, ValueLen: 5}))
+		_, err := subject.Entry([]byte("yk"))
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("should convert faults on truncated files into errors", func() {
+		if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+			Skip("files are not memory-mapped")
+		}
+
+		Expect(os.Truncate(fname+".spl", 0)).To(Succeed())
+		_, err := subject.Get([]byte("zk"))
+		Expect(err).To(Equal(ErrFault))
+		_, err = subject.Exists([]byte("xk"))
+		Expect(err).To(Equal(ErrFault))
+	})
+
+	It("should not intercept other panics", func() {
+		Expect(func() {
+			guard(func() error { panic("boom") })
+		}).To(Panic())
+	})
+
+})
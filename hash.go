@@ -1,10 +1,15 @@
 package sparkey
 
-//#cgo LDFLAGS: -lsparkey
-//#include <stdlib.h>
-//#include <sparkey/sparkey.h>
-import "C"
-import "unsafe"
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// ErrNotFound is returned by HashReader.Entry when a key cannot be found.
+var ErrNotFound = errors.New("sparkey: key not found")
 
 // WriteHashFile creates a hash table for a specific log file.
 // It's safe and efficient to run this multiple times.
@@ -15,46 +20,144 @@ import "unsafe"
 // the filesystem and the new one is created. Thus, it's safe to rewrite the hash table while
 // other processes are reading from it.
 func WriteHashFile(fname string, size HashSize) error {
-	return WriteCustomHashFile(HashFileName(fname), LogFileName(fname), size)
+	return BuildHashFile(fname, WithHashSize(size))
+}
+
+// BuildHashFile creates a hash table for a specific log file, see WriteHashFile.
+// Supported options are WithHashSize, WithHashSeed and WithProgress.
+func BuildHashFile(fname string, opts ...Option) error {
+	return BuildHashFileContext(context.Background(), fname, opts...)
+}
+
+// HashProgress reports the progress of a hash build
+type HashProgress struct {
+	// Number of log entries processed
+	Entries uint64
+	// Number of log data bytes processed (excluding the header), out of a
+	// total, compressed logs advance block by block
+	Bytes, TotalBytes uint64
+}
+
+// BuildHashFileContext is like BuildHashFile, but aborts the build and
+// returns the context's error when ctx is cancelled. Progress callbacks
+// registered WithProgress are invoked from the calling goroutine.
+//
+// libsparkey can neither report progress, be interrupted nor accept a seed,
+// so hashes are built by the native Go implementation when ctx is
+// cancellable, a progress callback is registered or WithHashSeed is used.
+// The resulting files are identical in format.
+func BuildHashFileContext(ctx context.Context, fname string, opts ...Option) error {
+	conf := newConfig(opts)
+	if err := conf.check(hashOptions); err != nil {
+		return err
+	}
+	return buildHash(ctx, fname, conf)
+}
+
+// buildHash builds a hash file with a checked configuration.
+func buildHash(ctx context.Context, fname string, conf *config) error {
+	if ctx.Done() == nil && conf.progress == nil && !conf.fixedSeed {
+		return WriteCustomHashFile(HashFileName(fname), LogFileName(fname), conf.hashSize)
+	}
+
+	return writeNativeHashFile(HashFileName(fname), LogFileName(fname), conf, func(p HashProgress) error {
+		if conf.progress != nil {
+			conf.progress(p)
+		}
+		return ctx.Err()
+	})
 }
 
 // WriteCustomHashFile writes hash files at custom locations.
 // This is in case you want to keep your files separate for any reason.
 func WriteCustomHashFile(hashname, logname string, size HashSize) error {
-	hname := C.CString(hashname)
-	defer C.free(unsafe.Pointer(hname))
-	lname := C.CString(logname)
-	defer C.free(unsafe.Pointer(lname))
-
-	rc := C.sparkey_hash_write(hname, lname, C.int(size))
-	return errorOrNil(rc)
+	return writeHashFile(hashname, logname, size)
 }
 
+// HashReader is a reader for hash/log pairs. Readers are safe for concurrent
+// use, Get can be called from many goroutines simultaneously without external
+// locking. Iterators created by a reader are not threadsafe.
+//
+// Files are memory-mapped, accessing a mapped file that was truncated
+// after opening usually crashes the process with SIGBUS. Readers opened
+// WithFaultRecovery perform Get, GetMulti, GetMultiFunc and Exists on a
+// separate native Go view of the files, which converts such faults into
+// ErrFault. Iterators are never guarded.
 type HashReader struct {
 	name, logname string
-	hash          *C.sparkey_hashreader
+	hash          *hashReaderHandle
+	header        *HashHeader
+	logHeader     *LogHeader
+	guarded       *hashFile  // native view, with fault recovery only
+	misses        *missCache // missing keys, with a negative cache only
+
+	pool   []*HashIter // idle iterators, used by Get
+	poolMu sync.Mutex
+	closed bool // guarded by poolMu
 }
 
 // Open opens a hash/log pair for reading.
 // The reader is threadsafe, except during opening or closing.
-func Open(fname string) (*HashReader, error) {
-	return OpenCustomHashReader(HashFileName(fname), LogFileName(fname))
+// Supported options are WithConsistencyCheck, WithFaultRecovery,
+// WithNegativeCache and WithOpenTrace.
+func Open(fname string, opts ...Option) (*HashReader, error) {
+	return OpenFiles(LogFileName(fname), HashFileName(fname), opts...)
+}
+
+// OpenFiles opens a hash/log pair for reading, using explicit paths
+// for the log and the index (hash) file. Unlike Open, no file
+// extensions are assumed. Supported options are WithConsistencyCheck,
+// WithFaultRecovery, WithNegativeCache and WithOpenTrace.
+func OpenFiles(logPath, indexPath string, opts ...Option) (*HashReader, error) {
+	conf := newConfig(opts)
+	if err := conf.check(readerOptions); err != nil {
+		return nil, err
+	}
+	return openFiles(context.Background(), logPath, indexPath, conf)
 }
 
 // OpenCustomHashReader opens a hash for reading, using custom file-names.
 // This is in case you want to keep your files separate for any reason.
 func OpenCustomHashReader(hashname string, logname string) (*HashReader, error) {
 	reader := HashReader{name: hashname, logname: logname}
-	hname := C.CString(hashname)
-	defer C.free(unsafe.Pointer(hname))
-	lname := C.CString(logname)
-	defer C.free(unsafe.Pointer(lname))
+	err := retryOpen("open", hashname, func() (err error) {
+		reader.hash, reader.header, reader.logHeader, err = openHashReaderWithHeaders(hashname, logname)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reader, nil
+}
 
-	rc := C.sparkey_hash_open(&reader.hash, hname, lname)
-	if rc == rc_SUCCESS {
-		return &reader, nil
+// OpenFile opens a hash/log pair from already open files, e.g. descriptors
+// received from another process. The reader does not take ownership of the
+// files, they may be closed once OpenFile returns.
+func OpenFile(log, index *os.File) (*HashReader, error) {
+	reader := HashReader{name: index.Name(), logname: log.Name()}
+	err := retryOpen("open", reader.name, func() (err error) {
+		reader.hash, reader.header, reader.logHeader, err = openHashReaderFile(index, log)
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, Error(rc)
+	return &reader, nil
+}
+
+// openHashReaderWithHeaders opens a hash reader together with the file headers
+func openHashReaderWithHeaders(hashname, logname string) (*hashReaderHandle, *HashHeader, *LogHeader, error) {
+	hash, err := openHashReader(hashname, logname)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	header, logHeader, err := hashReaderHeaders(hash, hashname, logname)
+	if err != nil {
+		closeHashReader(hash)
+		return nil, nil, nil, err
+	}
+	return hash, header, logHeader, nil
 }
 
 // Name returns the hash file name
@@ -63,16 +166,15 @@ func (r *HashReader) Name() string { return r.name }
 // LogName returns the associated log-file name
 func (r *HashReader) LogName() string { return r.logname }
 
-// NumSlots returns the number of slote entries
-func (r *HashReader) NumSlots() uint64 { return uint64(C.sparkey_hash_numentries(r.hash)) }
+// Header returns the hash file header
+func (r *HashReader) Header() HashHeader { return *r.header }
 
-// NumCollisions returns the number of collisions
-func (r *HashReader) NumCollisions() uint64 { return uint64(C.sparkey_hash_numcollisions(r.hash)) }
+// NumEntries returns the number of live (non-deleted) keys, as recorded
+// in the hash header. It does not need to scan the log.
+func (r *HashReader) NumEntries() uint64 { return r.header.NumEntries }
 
-// Log gets the LogReader that is referenced by the HashReader
-func (r *HashReader) Log() *LogReader {
-	return &LogReader{name: r.logname, log: C.sparkey_hash_getreader(r.hash)}
-}
+// Len returns the number of live keys, see NumEntries.
+func (r *HashReader) Len() int { return int(r.header.NumEntries) }
 
 // Iterator creates a hash iterator for data retrieval.
 // Please note that iterators are not threadsafe and must not be shared
@@ -85,17 +187,134 @@ func (r *HashReader) Iterator() (*HashIter, error) {
 	return &HashIter{LogIter: iter, reader: r}, nil
 }
 
-// Get is a (theadsafe) convenience accessor for keys, which allocates a new
-// iterator instance. For single-threaded batch access, allocate
-// a new iterator yourself and use iter.Get().
+// Get is a (theadsafe) convenience accessor for keys. It uses iterators from
+// an internal pool, so callers don't need to manage their own.
 // This method will return nil when a key doesn't exist.
 func (r *HashReader) Get(key []byte) ([]byte, error) {
-	iter, err := r.Iterator()
+	if r.misses == nil {
+		return r.get(key)
+	} else if r.misses.contains(key) {
+		return nil, nil
+	}
+
+	val, err := r.get(key)
+	if err == nil && val == nil {
+		r.misses.add(key)
+	}
+	return val, err
+}
+
+func (r *HashReader) get(key []byte) ([]byte, error) {
+	if r.guarded != nil {
+		return r.guardedGet(key)
+	}
+
+	iter, err := r.acquireIterator()
 	if err != nil {
 		return nil, err
 	}
-	defer iter.Close()
-	return iter.Get(key)
+
+	val, err := iter.Get(key)
+	r.releaseIterator(iter, err)
+	return val, err
+}
+
+// Entry is a (threadsafe) convenience accessor, like Get, which returns the
+// live entry of a key, with a copy of the key, or ErrNotFound if the key
+// doesn't exist. The offset is set where it is known, see LogIter.Offset.
+func (r *HashReader) Entry(key []byte) (*Entry, error) {
+	if r.misses != nil && r.misses.contains(key) {
+		return nil, ErrNotFound
+	}
+
+	entry, err := r.entry(key)
+	if err == nil && entry == nil {
+		if r.misses != nil {
+			r.misses.add(key)
+		}
+		return nil, ErrNotFound
+	}
+	return entry, err
+}
+
+func (r *HashReader) entry(key []byte) (*Entry, error) {
+	if r.guarded != nil {
+		return r.guardedEntry(key)
+	}
+
+	iter, err := r.acquireIterator()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := iter.entry(key)
+	r.releaseIterator(iter, err)
+	return entry, err
+}
+
+// GetMulti is a (threadsafe) convenience accessor for multiple keys. All keys
+// are looked up in a single pass using one iterator, which amortizes the
+// per-call overhead of individual lookups. The result contains one value per
+// key, nil for keys that don't exist.
+func (r *HashReader) GetMulti(keys [][]byte) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	err := r.GetMultiFunc(keys, func(i int, val []byte) error {
+		vals[i] = val
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// GetMultiFunc is the callback variant of GetMulti. It calls fn with the
+// index and value of each key, in order. The value is nil for keys that
+// don't exist. Iteration stops at the first error returned by fn.
+func (r *HashReader) GetMultiFunc(keys [][]byte, fn func(i int, val []byte) error) error {
+	if r.guarded != nil {
+		return r.guardedGetMulti(keys, fn)
+	}
+
+	iter, err := r.acquireIterator()
+	if err != nil {
+		return err
+	}
+
+	err = iter.getMulti(keys, fn)
+	r.releaseIterator(iter, err)
+	return err
+}
+
+// Exists is a (threadsafe) convenience method to check for the existence
+// of a key. Unlike Get, it never copies the value out of the log.
+func (r *HashReader) Exists(key []byte) (bool, error) {
+	if r.misses == nil {
+		return r.exists(key)
+	} else if r.misses.contains(key) {
+		return false, nil
+	}
+
+	ok, err := r.exists(key)
+	if err == nil && !ok {
+		r.misses.add(key)
+	}
+	return ok, err
+}
+
+func (r *HashReader) exists(key []byte) (bool, error) {
+	if r.guarded != nil {
+		return r.guardedExists(key)
+	}
+
+	iter, err := r.acquireIterator()
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := iter.Exists(key)
+	r.releaseIterator(iter, err)
+	return ok, err
 }
 
 // Close closes a reader.
@@ -103,8 +322,52 @@ func (r *HashReader) Get(key []byte) ([]byte, error) {
 // Further operations on such logiterators will fail.
 // This is a failsafe operation.
 func (r *HashReader) Close() {
+	r.poolMu.Lock()
+	for _, iter := range r.pool {
+		iter.Close()
+	}
+	r.pool = nil
+	r.closed = true
+	r.poolMu.Unlock()
+
 	if r.hash != nil {
-		C.sparkey_hash_close(&r.hash)
+		closeHashReader(r.hash)
+	}
+	if r.guarded != nil {
+		r.guarded.close()
+	}
+	r.hash, r.guarded = nil, nil
+}
+
+// acquireIterator returns an idle iterator from the pool or creates a new one
+func (r *HashReader) acquireIterator() (*HashIter, error) {
+	r.poolMu.Lock()
+	if n := len(r.pool); n > 0 {
+		iter := r.pool[n-1]
+		r.pool = r.pool[:n-1]
+		r.poolMu.Unlock()
+		return iter, nil
+	}
+	r.poolMu.Unlock()
+
+	return r.Iterator()
+}
+
+// releaseIterator returns an iterator to the pool. Iterators that have
+// encountered an error, that are released after the reader was closed or
+// while the pool already holds GOMAXPROCS iterators are closed instead.
+func (r *HashReader) releaseIterator(iter *HashIter, err error) {
+	if err != nil || iter.Err() != nil {
+		iter.Close()
+		return
+	}
+
+	r.poolMu.Lock()
+	if r.closed || len(r.pool) >= runtime.GOMAXPROCS(0) {
+		r.poolMu.Unlock()
+		iter.Close()
+		return
 	}
-	r.hash = nil
+	r.pool = append(r.pool, iter)
+	r.poolMu.Unlock()
 }
@@ -0,0 +1,70 @@
+//go:build !purego
+// +build !purego
+
+package sparkey
+
+//#cgo LDFLAGS: -lsparkey
+//#include <stdlib.h>
+//#include <sparkey/sparkey.h>
+import "C"
+import (
+	"os"
+	"unsafe"
+)
+
+type hashReaderHandle = C.sparkey_hashreader
+
+func writeHashFile(hashname, logname string, size HashSize) error {
+	hname := C.CString(hashname)
+	defer C.free(unsafe.Pointer(hname))
+	lname := C.CString(logname)
+	defer C.free(unsafe.Pointer(lname))
+
+	rc := C.sparkey_hash_write(hname, lname, C.int(size))
+	return errorOrNil(rc)
+}
+
+func openHashReader(hashname, logname string) (*hashReaderHandle, error) {
+	hname := C.CString(hashname)
+	defer C.free(unsafe.Pointer(hname))
+	lname := C.CString(logname)
+	defer C.free(unsafe.Pointer(lname))
+
+	var hash *C.sparkey_hashreader
+	rc := C.sparkey_hash_open(&hash, hname, lname)
+	return hash, errorOrNil(rc)
+}
+
+// openHashReaderFile opens the hash and log via the descriptor paths of the
+// files, libsparkey can only open files by name.
+func openHashReaderFile(index, log *os.File) (*hashReaderHandle, *HashHeader, *LogHeader, error) {
+	return openHashReaderWithHeaders(fdPath(index), fdPath(log))
+}
+
+func hashReaderHeaders(_ *hashReaderHandle, hashname, logname string) (*HashHeader, *LogHeader, error) {
+	header, err := readHashHeader(hashname)
+	if err != nil {
+		return nil, nil, err
+	}
+	logHeader, err := readLogHeader(logname)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, logHeader, nil
+}
+
+func closeHashReader(hash *hashReaderHandle) {
+	C.sparkey_hash_close(&hash)
+}
+
+// NumSlots returns the number of slote entries
+func (r *HashReader) NumSlots() uint64 { return uint64(C.sparkey_hash_numentries(r.hash)) }
+
+// NumCollisions returns the number of collisions
+func (r *HashReader) NumCollisions() uint64 { return uint64(C.sparkey_hash_numcollisions(r.hash)) }
+
+// Log gets the LogReader that is referenced by the HashReader
+func (r *HashReader) Log() *LogReader {
+	log := C.sparkey_hash_getreader(r.hash)
+	return &LogReader{name: r.logname, snap: newLogSnapshot(log, r.logHeader), header: r.logHeader, shared: true}
+}
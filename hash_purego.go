@@ -0,0 +1,47 @@
+//go:build purego
+// +build purego
+
+package sparkey
+
+import "os"
+
+type hashReaderHandle = hashFile
+
+func writeHashFile(hashname, logname string, size HashSize) error {
+	return writeNativeHashFile(hashname, logname, newConfig([]Option{WithHashSize(size)}), nil)
+}
+
+func openHashReader(hashname, logname string) (*hashReaderHandle, error) {
+	return openHashFile(hashname, logname)
+}
+
+func openHashReaderFile(index, log *os.File) (*hashReaderHandle, *HashHeader, *LogHeader, error) {
+	hash, err := openHashFileFrom(index, log)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return hash, hash.header, hash.log.header, nil
+}
+
+func hashReaderHeaders(hash *hashReaderHandle, _, _ string) (*HashHeader, *LogHeader, error) {
+	return hash.header, hash.log.header, nil
+}
+
+func closeHashReader(hash *hashReaderHandle) {
+	hash.close()
+}
+
+// NumSlots returns the number of slote entries
+func (r *HashReader) NumSlots() uint64 { return r.hash.header.NumEntries }
+
+// NumCollisions returns the number of collisions
+func (r *HashReader) NumCollisions() uint64 { return r.hash.header.HashCollisions }
+
+// Log gets the LogReader that is referenced by the HashReader
+func (r *HashReader) Log() *LogReader {
+	var log *logFile
+	if r.hash != nil {
+		log = r.hash.log
+	}
+	return &LogReader{name: r.logname, snap: newLogSnapshot(log, r.logHeader), header: r.logHeader, shared: true}
+}
@@ -1,7 +1,15 @@
 package sparkey
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -29,6 +37,75 @@ var _ = Describe("WriteHashFile", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("should report progress", func() {
+		fname, err := writeTestHash(testDir, func(w *LogWriter) error {
+			for i := 0; i < 70000; i++ {
+				if err := w.Put([]byte(fmt.Sprintf("k%05d", i%50000)), []byte("v")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(fname + ".spi")).To(Succeed())
+
+		var reports []HashProgress
+		Expect(BuildHashFileContext(context.Background(), fname, WithProgress(func(p HashProgress) {
+			reports = append(reports, p)
+		}))).To(Succeed())
+		Expect(reports).To(HaveLen(3))
+		Expect(reports[0]).To(Equal(HashProgress{TotalBytes: reports[2].TotalBytes}))
+		Expect(reports[1].Entries).To(Equal(uint64(65536)))
+		Expect(reports[1].Bytes).To(BeNumerically(">", 0))
+		Expect(reports[2]).To(Equal(HashProgress{Entries: 70000, Bytes: reports[2].TotalBytes, TotalBytes: reports[2].TotalBytes}))
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.NumEntries()).To(Equal(uint64(50000)))
+		Expect(reader.Get([]byte("k00001"))).To(Equal([]byte("v")))
+	})
+
+	It("should support custom hash sizes and seeds", func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(BuildHashFile(fname, WithHashSize(HASH_SIZE_32BIT), WithHashSeed(42))).To(Succeed())
+		header, err := ReadHashHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.HashSize).To(Equal(HASH_SIZE_32BIT))
+		Expect(header.HashSeed).To(Equal(uint32(42)))
+		first, err := ioutil.ReadFile(fname + ".spi")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(BuildHashFile(fname, WithHashSize(HASH_SIZE_64BIT), WithHashSeed(42))).To(Succeed())
+		header, err = ReadHashHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.HashSize).To(Equal(HASH_SIZE_64BIT))
+		Expect(header.HashSeed).To(Equal(uint32(42)))
+
+		Expect(BuildHashFile(fname, WithHashSize(HASH_SIZE_32BIT), WithHashSeed(42))).To(Succeed())
+		Expect(ioutil.ReadFile(fname + ".spi")).To(Equal(first))
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+	})
+
+	It("should support cancellation", func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(fname + ".spi")).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(BuildHashFileContext(ctx, fname)).To(Equal(context.Canceled))
+
+		_, err = os.Stat(fname + ".spi")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
 })
 
 var _ = Describe("HashReader", func() {
@@ -51,6 +128,8 @@ var _ = Describe("HashReader", func() {
 		Expect(subject.LogName()).To(ContainSubstring("test.spl"))
 		Expect(subject.NumSlots()).To(Equal(uint64(2)))
 		Expect(subject.NumCollisions()).To(Equal(uint64(0)))
+		Expect(subject.NumEntries()).To(Equal(uint64(2)))
+		Expect(subject.Len()).To(Equal(2))
 		subject.Close()
 	})
 
@@ -92,4 +171,275 @@ var _ = Describe("HashReader", func() {
 		Expect(val).To(BeNil())
 	})
 
+	It("should retrieve multiple values", func() {
+		vals, err := subject.GetMulti([][]byte{[]byte("zk"), []byte("missing"), []byte("xk"), []byte("yk"), nil})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vals).To(Equal([][]byte{[]byte(veryLongString), nil, []byte("short"), nil, nil}))
+
+		// Exceed the initial buffer size
+		keys := make([][]byte, 20)
+		for i := range keys {
+			keys[i] = []byte("zk")
+		}
+		vals, err = subject.GetMulti(keys)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vals).To(HaveLen(20))
+		for _, val := range vals {
+			Expect(string(val)).To(Equal(veryLongString))
+		}
+		Expect(subject.pool).To(HaveLen(1))
+	})
+
+	It("should retrieve multiple values with callbacks", func() {
+		var seen []string
+		err := subject.GetMultiFunc([][]byte{[]byte("xk"), []byte("yk"), []byte("zk")}, func(i int, val []byte) error {
+			seen = append(seen, fmt.Sprintf("%d:%d", i, len(val)))
+			if i == 1 {
+				return errors.New("stop")
+			}
+			return nil
+		})
+		Expect(err).To(MatchError("stop"))
+		Expect(seen).To(Equal([]string{"0:5", "1:0"}))
+	})
+
+	It("should retrieve entries", func() {
+		key := []byte("xk")
+		entry, err := subject.Entry(key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entry.Type).To(Equal(ENTRY_PUT))
+		Expect(entry.Key).To(Equal([]byte("xk")))
+		Expect(entry.Value).To(Equal([]byte("short")))
+		Expect(entry.KeyLen).To(Equal(uint64(2)))
+		Expect(entry.ValueLen).To(Equal(uint64(5)))
+		key[0] = 'a'
+		Expect(entry.Key).To(Equal([]byte("xk")))
+
+		_, err = subject.Entry([]byte("missing"))
+		Expect(err).To(Equal(ErrNotFound))
+		_, err = subject.Entry([]byte("yk"))
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("should check existence", func() {
+		Expect(subject.Exists([]byte("missing"))).To(BeFalse())
+		Expect(subject.Exists([]byte("xk"))).To(BeTrue())
+		Expect(subject.Exists([]byte("yk"))).To(BeFalse())
+		Expect(subject.Exists([]byte("zk"))).To(BeTrue())
+		Expect(subject.pool).To(HaveLen(1))
+	})
+
+	It("should pool iterators", func() {
+		Expect(subject.pool).To(BeEmpty())
+
+		_, err := subject.Get([]byte("xk"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.pool).To(HaveLen(1))
+		iter := subject.pool[0]
+
+		val, err := subject.Get([]byte("zk"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(val)).To(Equal(veryLongString))
+		Expect(subject.pool).To(Equal([]*HashIter{iter}))
+
+		subject.Close()
+		Expect(subject.pool).To(BeEmpty())
+		Expect(iter.iter).To(BeNil())
+	})
+
+	It("should not pool iterators after close", func() {
+		iter, err := subject.acquireIterator()
+		Expect(err).NotTo(HaveOccurred())
+
+		subject.Close()
+		subject.releaseIterator(iter, nil)
+		Expect(subject.pool).To(BeEmpty())
+		Expect(iter.iter).To(BeNil())
+	})
+
+	It("should limit the number of pooled iterators", func() {
+		limit := runtime.GOMAXPROCS(0)
+		iters := make([]*HashIter, limit+2)
+		for n := range iters {
+			iter, err := subject.Iterator()
+			Expect(err).NotTo(HaveOccurred())
+			iters[n] = iter
+		}
+		for _, iter := range iters {
+			subject.releaseIterator(iter, nil)
+		}
+		Expect(subject.pool).To(HaveLen(limit))
+		Expect(iters[limit].iter).To(BeNil())
+		Expect(iters[limit+1].iter).To(BeNil())
+	})
+
+	It("should support concurrent lookups", func() {
+		var wg sync.WaitGroup
+		errs := make(chan error, 16)
+		for n := 0; n < 16; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					if val, err := subject.Get([]byte("zk")); err != nil {
+						errs <- err
+						return
+					} else if string(val) != veryLongString {
+						errs <- fmt.Errorf("unexpected value %q", val)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(len(subject.pool)).To(BeNumerically("<=", runtime.GOMAXPROCS(0)))
+	})
+
+})
+
+var _ = Describe("OpenFiles", func() {
+
+	It("should open hash/log pairs with custom names", func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+
+		logPath := filepath.Join(testDir, "shared.log")
+		indexPath := filepath.Join(testDir, "index-20140101.idx")
+		Expect(os.Rename(fname+".spl", logPath)).To(Succeed())
+		Expect(os.Rename(fname+".spi", indexPath)).To(Succeed())
+
+		reader, err := OpenFiles(logPath, indexPath, WithConsistencyCheck(true))
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.Name()).To(Equal(indexPath))
+		Expect(reader.LogName()).To(Equal(logPath))
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+
+		_, err = OpenFiles(logPath, fname+".spi")
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+	})
+
+	It("should open hash/log pairs from open files", func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+
+		logFile, err := os.Open(fname + ".spl")
+		Expect(err).NotTo(HaveOccurred())
+		defer logFile.Close()
+		indexFile, err := os.Open(fname + ".spi")
+		Expect(err).NotTo(HaveOccurred())
+		defer indexFile.Close()
+
+		reader, err := OpenFile(logFile, indexFile)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.Name()).To(Equal(fname + ".spi"))
+		Expect(reader.LogName()).To(Equal(fname + ".spl"))
+		Expect(reader.NumEntries()).To(Equal(uint64(2)))
+
+		// files are no longer needed once the reader is open
+		Expect(logFile.Close()).To(Succeed())
+		Expect(indexFile.Close()).To(Succeed())
+		Expect(os.Remove(fname + ".spl")).To(Succeed())
+		Expect(reader.Get([]byte("zk"))).To(Equal([]byte(veryLongString)))
+
+		_, err = OpenFile(indexFile, indexFile)
+		Expect(err).To(HaveOccurred())
+	})
+
+})
+
+var _ = Describe("Empty keys and values", func() {
+
+	var writeHash = func(opts *Options) string {
+		fname, err := writeTestHash(testDir, func(w *LogWriter) error {
+			Expect(w.Put(nil, []byte("empty key"))).To(Succeed())
+			Expect(w.Put([]byte("ev"), nil)).To(Succeed())
+			Expect(w.PutBatch([]Entry{{Type: ENTRY_PUT, Key: []byte("eb"), Value: []byte{}}})).To(Succeed())
+			Expect(w.PutReader([]byte("er"), strings.NewReader(""), 0)).To(Succeed())
+			Expect(w.PutReader([]byte("eu"), strings.NewReader(""), -1)).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		if opts != nil {
+			Expect(Reencode(fname, fname+"-c", WithCompression(opts.Compression))).To(Succeed())
+			fname += "-c"
+		}
+		return fname
+	}
+
+	for _, opts := range []*Options{nil, {Compression: COMPRESSION_SNAPPY}} {
+		opts := opts
+
+		It(fmt.Sprintf("should store and retrieve them (compression: %d)", opts.GetCompression()), func() {
+			reader, err := Open(writeHash(opts))
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			Expect(reader.NumSlots()).To(Equal(uint64(5)))
+			Expect(reader.Log().Header().MaxKeyLen).To(Equal(uint64(2)))
+			Expect(reader.Log().Header().MaxValueLen).To(Equal(uint64(9)))
+
+			Expect(reader.Get(nil)).To(Equal([]byte("empty key")))
+			Expect(reader.Get([]byte{})).To(Equal([]byte("empty key")))
+			for _, key := range []string{"ev", "eb", "er", "eu"} {
+				Expect(reader.Get([]byte(key))).To(Equal([]byte{}), key)
+				Expect(reader.Exists([]byte(key))).To(BeTrue(), key)
+			}
+			Expect(reader.Get([]byte("missing"))).To(BeNil())
+			Expect(reader.Exists(nil)).To(BeTrue())
+			entry, err := reader.Entry([]byte("ev"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entry.Value).To(Equal([]byte{}))
+			Expect(entry.ValueLen).To(BeZero())
+
+			vals, err := reader.GetMulti([][]byte{nil, []byte("ev"), []byte("missing"), {}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vals).To(Equal([][]byte{[]byte("empty key"), {}, nil, []byte("empty key")}))
+
+			iter, err := reader.Iterator()
+			Expect(err).NotTo(HaveOccurred())
+			defer iter.Close()
+
+			var keys, values [][]byte
+			for iter.NextLive(); iter.Valid(); iter.NextLive() {
+				key, err := iter.Key()
+				Expect(err).NotTo(HaveOccurred())
+				val, err := iter.Value()
+				Expect(err).NotTo(HaveOccurred())
+				keys, values = append(keys, key), append(values, val)
+			}
+			Expect(iter.Err()).NotTo(HaveOccurred())
+			Expect(keys).To(Equal([][]byte{{}, []byte("ev"), []byte("eb"), []byte("er"), []byte("eu")}))
+			Expect(values).To(Equal([][]byte{[]byte("empty key"), {}, {}, {}, {}}))
+		})
+	}
+
+	It("should delete empty keys", func() {
+		fname := writeHash(nil)
+
+		writer, err := AppendLogWriter(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Delete([]byte{})).To(Succeed())
+		Expect(writer.WriteHashFile(HASH_SIZE_AUTO)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.NumSlots()).To(Equal(uint64(4)))
+		Expect(reader.Get(nil)).To(BeNil())
+		Expect(reader.Exists(nil)).To(BeFalse())
+		Expect(reader.Get([]byte("ev"))).To(Equal([]byte{}))
+	})
+
 })
@@ -0,0 +1,549 @@
+package sparkey
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/* Hash file */
+
+// hashFile is a read-only, native Go view of a hash file and its log.
+type hashFile struct {
+	header *HashHeader
+	data   []byte // file contents
+	unmap  func() error
+	table  []byte // hash slots
+	log    *logFile
+	closed bool
+}
+
+// openHashFile maps a hash file and its log into memory.
+func openHashFile(hashname, logname string) (*hashFile, error) {
+	hashf, err := os.Open(hashname)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	defer hashf.Close()
+
+	logf, err := os.Open(logname)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	defer logf.Close()
+
+	return openHashFileFrom(hashf, logf)
+}
+
+// openHashFileFrom maps an open hash file and its open log into memory.
+func openHashFileFrom(hashf, logf *os.File) (*hashFile, error) {
+	data, unmap, err := mapOpenFile(hashf)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := newHashFile(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+
+	log, err := openLogFileFrom(logf)
+	if err != nil {
+		unmap()
+		return nil, err
+	} else if log.header.FileIdentifier != hash.header.FileIdentifier {
+		log.close()
+		unmap()
+		return nil, ERROR_FILE_IDENTIFIER_MISMATCH
+	}
+
+	hash.log, hash.unmap = log, unmap
+	return hash, nil
+}
+
+// newHashFile parses the header and slot table of a hash.
+func newHashFile(data []byte) (*hashFile, error) {
+	header, err := decodeHashHeader(data)
+	if err != nil {
+		return nil, err
+	} else if header.MinorVersion > hashMinorVersion {
+		return nil, ERROR_UNSUPPORTED_HASH_MINOR_VERSION
+	} else if header.HashCapacity == 0 {
+		return nil, ERROR_HASH_HEADER_CORRUPT
+	}
+
+	slotSize := uint64(header.HashSize) + uint64(header.AddressSize)
+	if uint64(len(data)-hashHeaderSize)/slotSize < header.HashCapacity {
+		return nil, ERROR_HASH_TOO_SMALL
+	}
+	return &hashFile{
+		header: header,
+		data:   data,
+		table:  data[hashHeaderSize : hashHeaderSize+header.HashCapacity*slotSize],
+	}, nil
+}
+
+// close marks the hash and its log as closed and releases the mappings.
+func (h *hashFile) close() {
+	if h.closed {
+		return
+	}
+	h.closed = true
+	if h.log != nil {
+		h.log.close()
+	}
+	if h.unmap != nil {
+		h.unmap()
+	}
+	h.data, h.table = nil, nil
+}
+
+// slot returns the hash value and log address stored at slot i.
+func (h *hashFile) slot(i uint64) (uint64, uint64) {
+	hashSize, addrSize := uint64(h.header.HashSize), uint64(h.header.AddressSize)
+	b := h.table[i*(hashSize+addrSize):]
+	return getUint(b, hashSize), getUint(b[hashSize:], addrSize)
+}
+
+// get positions the cursor on key. The key is consumed so that the
+// next read returns the value. The cursor state is ITERATOR_INVALID if
+// the key cannot be found.
+func (h *hashFile) get(key []byte, c *logCursor) error {
+	if h.closed {
+		return ERROR_HASH_CLOSED
+	} else if err := c.check(); err != nil {
+		return err
+	} else if c.log != h.log {
+		return ERROR_LOG_ITERATOR_MISMATCH
+	}
+
+	hv := hashKey(key, h.header.HashSize, h.header.HashSeed)
+	capacity := h.header.HashCapacity
+	for slot, dist := hv%capacity, uint64(0); dist <= capacity; dist++ {
+		shash, addr := h.slot(slot)
+		if addr == 0 {
+			break
+		}
+
+		if shash == hv {
+			if err := c.seekAddress(addr, h.header.EntryBlockBits); err != nil {
+				return err
+			}
+			if c.state == ITERATOR_ACTIVE {
+				cmp, err := c.compareKey(key)
+				if err != nil {
+					return err
+				} else if cmp == 0 {
+					c.skipKey()
+					return nil
+				}
+			}
+		}
+
+		if displacement(capacity, slot, shash) < dist {
+			break
+		}
+		slot = (slot + 1) % capacity
+	}
+
+	c.clear()
+	c.state = ITERATOR_INVALID
+	return nil
+}
+
+// lookup creates a new cursor and positions it on key, see get.
+func (h *hashFile) lookup(key []byte) (*logCursor, error) {
+	c, err := newLogCursor(h.log)
+	if err != nil {
+		return nil, ERROR_HASH_CLOSED
+	}
+	if err := h.get(key, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// nextLive moves the cursor to the next entry that is referenced by the hash.
+func (h *hashFile) nextLive(c *logCursor) error {
+	if h.closed {
+		return ERROR_HASH_CLOSED
+	}
+
+	var key []byte
+	for {
+		if err := c.next(); err != nil {
+			return err
+		} else if c.state != ITERATOR_ACTIVE {
+			return nil
+		} else if c.typ != ENTRY_PUT {
+			continue
+		}
+
+		var err error
+		if key, err = c.appendKey(key[:0]); err != nil {
+			return err
+		}
+
+		probe := *c
+		if err := h.get(key, &probe); err != nil {
+			return err
+		} else if probe.state == ITERATOR_ACTIVE && probe.entry == c.entry {
+			return nil
+		}
+	}
+}
+
+/* Hash file writer */
+
+// hashSlot is a slot of a hash table under construction
+type hashSlot struct {
+	hash, addr uint64
+	entry      logPos // position of the entry
+	size       uint64 // size of the entry, 0 if not loaded yet
+	used       bool
+}
+
+// buildHashFile indexes all live entries of a log into a hash table, using
+// robin hood hashing with linear probing. It returns the header and the
+// encoded slots of the hash file. If progress is not nil, it is called
+// periodically and the build is aborted if it returns an error.
+//
+// If base is not nil, it must be an extendable hash of the log, see
+// extendable. Its slots are reused and only the entries appended since it
+// was built are indexed, size and seed are ignored.
+func buildHashFile(log *logFile, base *hashFile, size HashSize, seed uint32, progress func(HashProgress) error) (*HashHeader, []byte, error) {
+	lh := log.header
+	if base != nil {
+		size, seed = base.header.HashSize, base.header.HashSeed
+	}
+	switch size {
+	case HASH_SIZE_AUTO:
+		size = HASH_SIZE_32BIT
+		if lh.NumPuts >= 1<<23 {
+			size = HASH_SIZE_64BIT
+		}
+	case HASH_SIZE_32BIT, HASH_SIZE_64BIT:
+	default:
+		return nil, nil, ERROR_HASH_SIZE_INVALID
+	}
+
+	header := &HashHeader{
+		MajorVersion:   hashMajorVersion,
+		MinorVersion:   hashMinorVersion,
+		FileIdentifier: lh.FileIdentifier,
+		HashSeed:       seed,
+		DataEnd:        lh.DataEnd,
+		MaxKeyLen:      lh.MaxKeyLen,
+		MaxValueLen:    lh.MaxValueLen,
+		NumPuts:        lh.NumPuts,
+		HashSize:       size,
+		HashCapacity:   lh.NumPuts + lh.NumPuts/4 + 1,
+		AddressSize:    8,
+		EntryBlockBits: entryBlockBits(lh),
+	}
+	if lh.DataEnd<<header.EntryBlockBits < 1<<32 {
+		header.AddressSize = 4
+	}
+
+	iter, err := newLogCursor(log)
+	if err != nil {
+		return nil, nil, err
+	}
+	probe, err := newLogCursor(log)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slots := make([]hashSlot, header.HashCapacity)
+	if base != nil {
+		header.NumEntries, header.GarbageSize = base.header.NumEntries, base.header.GarbageSize
+		for i := uint64(0); i < base.header.HashCapacity; i++ {
+			if hv, addr := base.slot(i); addr != 0 {
+				insertHashSlot(slots, hashSlot{hash: hv, addr: addr, used: true})
+			}
+		}
+		if err := iter.seek(base.header.DataEnd); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var key []byte
+	for n := uint64(0); ; n++ {
+		if progress != nil && n%hashProgressInterval == 0 {
+			if err := progress(HashProgress{Entries: n, Bytes: log.filePos(iter.pos) - logHeaderSize, TotalBytes: lh.DataEnd - logHeaderSize}); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if err := iter.next(); err != nil {
+			return nil, nil, err
+		} else if iter.state != ITERATOR_ACTIVE {
+			if progress != nil {
+				if err := progress(HashProgress{Entries: n, Bytes: lh.DataEnd - logHeaderSize, TotalBytes: lh.DataEnd - logHeaderSize}); err != nil {
+					return nil, nil, err
+				}
+			}
+			break
+		}
+
+		if key, err = iter.appendKey(key[:0]); err != nil {
+			return nil, nil, err
+		}
+		hv := hashKey(key, size, seed)
+		esize := iter.entrySize()
+
+		slot, found, err := findHashSlot(slots, hv, key, probe, header.EntryBlockBits)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case found && iter.typ == ENTRY_PUT:
+			header.GarbageSize += slots[slot].size
+			slots[slot].addr = iter.address(header.EntryBlockBits)
+			slots[slot].entry, slots[slot].size = iter.entry, esize
+		case found:
+			header.GarbageSize += slots[slot].size + esize
+			header.NumEntries--
+			removeHashSlot(slots, slot)
+		case iter.typ == ENTRY_PUT:
+			header.NumEntries++
+			insertHashSlot(slots, hashSlot{
+				hash:  hv,
+				addr:  iter.address(header.EntryBlockBits),
+				entry: iter.entry,
+				size:  esize,
+				used:  true,
+			})
+		default:
+			header.GarbageSize += esize
+		}
+	}
+
+	capacity := header.HashCapacity
+	hashSize, addrSize := uint64(size), uint64(header.AddressSize)
+	table := make([]byte, capacity*(hashSize+addrSize))
+	for i, slot := range slots {
+		if !slot.used {
+			continue
+		}
+
+		dist := displacement(capacity, uint64(i), slot.hash)
+		header.TotalDisplacement += dist
+		if dist > header.MaxDisplacement {
+			header.MaxDisplacement = dist
+		}
+		if i > 0 && slots[i-1].used && slots[i-1].hash == slot.hash {
+			header.HashCollisions++
+		}
+
+		b := table[uint64(i)*(hashSize+addrSize):]
+		putUint(b, hashSize, slot.hash)
+		putUint(b[hashSize:], addrSize, slot.addr)
+	}
+	return header, table, nil
+}
+
+// findHashSlot looks up the slot of key, probe is used to read the keys of
+// existing entries.
+func findHashSlot(slots []hashSlot, hv uint64, key []byte, probe *logCursor, entryBlockBits uint32) (uint64, bool, error) {
+	capacity := uint64(len(slots))
+	for slot, dist := hv%capacity, uint64(0); slots[slot].used; dist++ {
+		if s := &slots[slot]; s.hash == hv {
+			if err := loadHashSlot(s, probe, entryBlockBits); err != nil {
+				return 0, false, err
+			}
+			if cmp, err := probe.compareKey(key); err != nil {
+				return 0, false, err
+			} else if cmp == 0 {
+				return slot, true, nil
+			}
+		}
+
+		if displacement(capacity, slot, slots[slot].hash) < dist {
+			break
+		}
+		slot = (slot + 1) % capacity
+	}
+	return 0, false, nil
+}
+
+// loadHashSlot positions probe on the entry of a slot. Slots that were taken
+// over from a previous hash are loaded from their address on first access.
+func loadHashSlot(s *hashSlot, probe *logCursor, entryBlockBits uint32) error {
+	if s.size != 0 {
+		probe.pos = s.entry
+		return probe.readEntry()
+	}
+
+	if err := probe.seekAddress(s.addr, entryBlockBits); err != nil {
+		return err
+	} else if probe.state != ITERATOR_ACTIVE {
+		return ERROR_HASH_HEADER_CORRUPT
+	}
+	s.entry, s.size = probe.entry, probe.entrySize()
+	return nil
+}
+
+// insertHashSlot inserts an entry, displacing entries that are closer to
+// their ideal slot.
+func insertHashSlot(slots []hashSlot, entry hashSlot) {
+	capacity := uint64(len(slots))
+	slot, dist := entry.hash%capacity, uint64(0)
+	for slots[slot].used {
+		if d := displacement(capacity, slot, slots[slot].hash); d < dist {
+			slots[slot], entry = entry, slots[slot]
+			dist = d
+		}
+		slot = (slot + 1) % capacity
+		dist++
+	}
+	slots[slot] = entry
+}
+
+// removeHashSlot removes an entry, shifting subsequent entries backwards.
+func removeHashSlot(slots []hashSlot, slot uint64) {
+	capacity := uint64(len(slots))
+	for {
+		next := (slot + 1) % capacity
+		if !slots[next].used || displacement(capacity, next, slots[next].hash) == 0 {
+			break
+		}
+		slots[slot] = slots[next]
+		slot = next
+	}
+	slots[slot] = hashSlot{}
+}
+
+// writeNativeHashFile builds a hash for a log with the native Go
+// implementation, see buildHashFile. Like libsparkey, it extends an
+// existing hash of the log instead of rebuilding it, if possible.
+func writeNativeHashFile(hashname, logname string, conf *config, progress func(HashProgress) error) error {
+	log, err := openLogFile(logname)
+	if err != nil {
+		return err
+	}
+	defer log.close()
+
+	base := openBaseHashFile(hashname, log, conf)
+	if base != nil {
+		defer base.close()
+	}
+
+	header, table, err := buildHashFile(log, base, conf.hashSize, conf.seed(), progress)
+	if err != nil {
+		return err
+	}
+	return writeHashFileAtomic(hashname, header, table)
+}
+
+// openBaseHashFile maps an existing hash file, if it can be extended with
+// the entries of log, see extendable. It returns nil otherwise.
+func openBaseHashFile(name string, log *logFile, conf *config) *hashFile {
+	data, unmap, err := mapFile(name)
+	if err != nil {
+		return nil
+	}
+
+	hash, err := newHashFile(data)
+	if err != nil || !hash.extendable(log, conf) {
+		unmap()
+		return nil
+	}
+	hash.unmap = unmap
+	return hash
+}
+
+// extendable returns true if the hash was built from a prefix of log, i.e.
+// before further entries were appended, with settings compatible to conf.
+func (h *hashFile) extendable(log *logFile, conf *config) bool {
+	hh, lh := h.header, log.header
+	if hh.FileIdentifier != lh.FileIdentifier || hh.DataEnd > lh.DataEnd || hh.NumPuts > lh.NumPuts {
+		return false
+	} else if hh.EntryBlockBits != entryBlockBits(lh) {
+		return false
+	} else if conf.hashSize != HASH_SIZE_AUTO && conf.hashSize != hh.HashSize {
+		return false
+	} else if conf.fixedSeed && conf.hashSeed != hh.HashSeed {
+		return false
+	}
+
+	_, ok := log.posAt(hh.DataEnd)
+	return ok
+}
+
+// writeHashFileAtomic writes a hash file to a temporary file and moves it
+// into place, so that readers never observe a partial file. The file and
+// its directory are synced, so the new file survives a crash.
+func writeHashFileAtomic(name string, header *HashHeader, table []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return fileError(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(encodeHashHeader(header))
+	if err == nil {
+		_, err = tmp.Write(table)
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		return fileError(err)
+	}
+	return syncFile(filepath.Dir(name))
+}
+
+/* Helpers */
+
+// hashKey hashes a key with the hash function of the given size.
+func hashKey(key []byte, size HashSize, seed uint32) uint64 {
+	if size == HASH_SIZE_32BIT {
+		return uint64(murmur32(key, seed))
+	}
+	return murmur64(key, seed)
+}
+
+// entryBlockBits returns the number of address bits which store the index
+// of an entry within its block.
+func entryBlockBits(lh *LogHeader) uint32 {
+	var bits uint32
+	if lh.Compression != COMPRESSION_NONE {
+		for uint64(1)<<bits < uint64(lh.MaxEntriesPerBlock)+1 {
+			bits++
+		}
+	}
+	return bits
+}
+
+// displacement returns the distance of a slot from the ideal slot of a hash.
+func displacement(capacity, slot, hash uint64) uint64 {
+	return (slot + capacity - hash%capacity) % capacity
+}
+
+func getUint(b []byte, size uint64) uint64 {
+	if size == 4 {
+		return uint64(binary.LittleEndian.Uint32(b))
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func putUint(b []byte, size, v uint64) {
+	if size == 4 {
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	} else {
+		binary.LittleEndian.PutUint64(b, v)
+	}
+}
@@ -0,0 +1,135 @@
+package sparkey
+
+import (
+	"fmt"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hashFile", func() {
+
+	var lookup = func(hash *hashFile, key string) string {
+		iter, err := newLogCursor(hash.log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hash.get([]byte(key), iter)).To(Succeed())
+		if iter.state != ITERATOR_ACTIVE {
+			return "<missing>"
+		}
+
+		val := make([]byte, iter.valueLen)
+		Expect(fillChunks(val, iter.valueChunk)).To(Equal(len(val)))
+		return string(val)
+	}
+
+	var writeLog = func(opts *Options) string {
+		fname := filepath.Join(testDir, "test")
+		w, err := CreateLogWriter(fname, opts)
+		Expect(err).NotTo(HaveOccurred())
+		defer w.Close()
+
+		for i := 0; i < 1000; i++ {
+			Expect(w.Put([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprintf("v%d", i)))).To(Succeed())
+		}
+		for i := 0; i < 1000; i += 3 {
+			Expect(w.Delete([]byte(fmt.Sprintf("k%03d", i)))).To(Succeed())
+		}
+		Expect(w.Flush()).To(Succeed())
+		return fname
+	}
+
+	for _, opts := range []*Options{nil, {Compression: COMPRESSION_SNAPPY, CompressionBlockSize: 64}} {
+		opts := opts
+
+		It(fmt.Sprintf("should read hashes (%+v)", opts), func() {
+			fname := writeLog(opts)
+			Expect(WriteHashFile(fname, HASH_SIZE_32BIT)).To(Succeed())
+
+			hash, err := openHashFile(HashFileName(fname), LogFileName(fname))
+			Expect(err).NotTo(HaveOccurred())
+			defer hash.close()
+
+			Expect(hash.header.NumEntries).To(Equal(uint64(666)))
+			Expect(lookup(hash, "k001")).To(Equal("v1"))
+			Expect(lookup(hash, "k999")).To(Equal("<missing>"))
+			Expect(lookup(hash, "k998")).To(Equal("v998"))
+			Expect(lookup(hash, "xxxx")).To(Equal("<missing>"))
+		})
+
+		It(fmt.Sprintf("should build hashes (%+v)", opts), func() {
+			fname := writeLog(opts)
+			log, err := openLogFile(LogFileName(fname))
+			Expect(err).NotTo(HaveOccurred())
+			header, table, err := buildHashFile(log, nil, HASH_SIZE_64BIT, 33, nil)
+			log.close()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(header.NumEntries).To(Equal(uint64(666)))
+			Expect(header.HashSeed).To(Equal(uint32(33)))
+			Expect(writeHashFileAtomic(HashFileName(fname), header, table)).To(Succeed())
+
+			reader, err := Open(fname)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			Expect(reader.NumSlots()).To(Equal(uint64(666)))
+			Expect(reader.Get([]byte("k002"))).To(Equal([]byte("v2")))
+			Expect(reader.Get([]byte("k003"))).To(BeNil())
+		})
+		It(fmt.Sprintf("should extend hashes (%+v)", opts), func() {
+			fname := writeLog(opts)
+			Expect(BuildHashFile(fname, WithHashSeed(33))).To(Succeed())
+
+			w, err := AppendLogWriter(fname)
+			Expect(err).NotTo(HaveOccurred())
+			for i := 0; i < 1000; i += 2 {
+				Expect(w.Put([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprintf("w%d", i)))).To(Succeed())
+			}
+			for i := 1; i < 1000; i += 5 {
+				Expect(w.Delete([]byte(fmt.Sprintf("k%03d", i)))).To(Succeed())
+			}
+			Expect(w.Put([]byte("new"), []byte("value"))).To(Succeed())
+			Expect(w.Close()).To(Succeed())
+
+			log, err := openLogFile(LogFileName(fname))
+			Expect(err).NotTo(HaveOccurred())
+			defer log.close()
+
+			base := openBaseHashFile(HashFileName(fname), log, newConfig(nil))
+			Expect(base).NotTo(BeNil())
+			defer base.close()
+			Expect(openBaseHashFile(HashFileName(fname), log, newConfig([]Option{WithHashSeed(34)}))).To(BeNil())
+
+			extended, table, err := buildHashFile(log, base, HASH_SIZE_64BIT, 0, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(extended.HashSize).To(Equal(base.header.HashSize))
+			Expect(extended.HashSeed).To(Equal(uint32(33)))
+
+			rebuilt, _, err := buildHashFile(log, nil, base.header.HashSize, 33, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(extended).To(Equal(rebuilt))
+
+			Expect(writeHashFileAtomic(HashFileName(fname), extended, table)).To(Succeed())
+			hash, err := openHashFile(HashFileName(fname), LogFileName(fname))
+			Expect(err).NotTo(HaveOccurred())
+			defer hash.close()
+
+			Expect(lookup(hash, "k000")).To(Equal("w0"))
+			Expect(lookup(hash, "k001")).To(Equal("<missing>"))
+			Expect(lookup(hash, "k004")).To(Equal("w4"))
+			Expect(lookup(hash, "k005")).To(Equal("v5"))
+			Expect(lookup(hash, "new")).To(Equal("value"))
+		})
+	}
+
+	It("should reject invalid hash sizes", func() {
+		fname := writeLog(nil)
+		log, err := openLogFile(LogFileName(fname))
+		Expect(err).NotTo(HaveOccurred())
+		defer log.close()
+
+		_, _, err = buildHashFile(log, nil, HashSize(3), 0, nil)
+		Expect(err).To(Equal(ERROR_HASH_SIZE_INVALID))
+	})
+
+})
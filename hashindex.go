@@ -0,0 +1,95 @@
+package sparkey
+
+// HashIndex is a read-only view of a hash file on its own, without the
+// associated log. It exposes the raw slots of the hash table, which is
+// useful for index-level statistics and for analysing hashes whose logs
+// are unavailable. The index is threadsafe, except during closing.
+type HashIndex struct {
+	name string
+	hash *hashFile
+}
+
+// HashSlot is an occupied slot of a hash table
+type HashSlot struct {
+	// Hash value of the key
+	Hash uint64
+	// File position of the entry in the log, for compressed logs the
+	// position of the block that contains the entry
+	Offset uint64
+	// Index of the entry within its block, compressed logs only
+	BlockIndex uint64
+	// Distance from the ideal slot of the hash value
+	Displacement uint64
+}
+
+// OpenHashOnly opens a hash file, without its log.
+func OpenHashOnly(fname string) (*HashIndex, error) {
+	name := HashFileName(fname)
+	data, unmap, err := mapFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := newHashFile(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	hash.unmap = unmap
+	return &HashIndex{name: name, hash: hash}, nil
+}
+
+// Name returns the hash file name
+func (x *HashIndex) Name() string { return x.name }
+
+// Header returns the hash file header
+func (x *HashIndex) Header() *HashHeader {
+	header := *x.hash.header
+	return &header
+}
+
+// Capacity returns the number of slots in the hash table, including empty ones
+func (x *HashIndex) Capacity() uint64 { return x.hash.header.HashCapacity }
+
+// Slot returns the slot at index i. It returns false if the slot is empty
+// or out of range.
+func (x *HashIndex) Slot(i uint64) (HashSlot, bool) {
+	if x.hash.closed || i >= x.hash.header.HashCapacity {
+		return HashSlot{}, false
+	}
+
+	hv, addr := x.hash.slot(i)
+	if addr == 0 {
+		return HashSlot{}, false
+	}
+
+	bits := x.hash.header.EntryBlockBits
+	return HashSlot{
+		Hash:         hv,
+		Offset:       addr >> bits,
+		BlockIndex:   addr & (1<<bits - 1),
+		Displacement: displacement(x.hash.header.HashCapacity, i, hv),
+	}, true
+}
+
+// ForEach calls fn for all occupied slots, in table order. Iteration stops at
+// the first error returned by fn.
+func (x *HashIndex) ForEach(fn func(i uint64, slot HashSlot) error) error {
+	if x.hash.closed {
+		return ERROR_HASH_CLOSED
+	}
+
+	for i := uint64(0); i < x.hash.header.HashCapacity; i++ {
+		if slot, ok := x.Slot(i); ok {
+			if err := fn(i, slot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the index.
+func (x *HashIndex) Close() {
+	x.hash.close()
+}
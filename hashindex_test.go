@@ -0,0 +1,77 @@
+package sparkey
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HashIndex", func() {
+	var subject *HashIndex
+
+	BeforeEach(func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(LogFileName(fname))).To(Succeed())
+
+		subject, err = OpenHashOnly(fname)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should open without logs", func() {
+		Expect(subject.Name()).To(Equal(filepath.Join(testDir, "test.spi")))
+		Expect(subject.Header().NumEntries).To(Equal(uint64(2)))
+		Expect(subject.Capacity()).To(Equal(uint64(4)))
+
+		_, err := OpenHashOnly(filepath.Join(testDir, "missing"))
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+	})
+
+	It("should expose slots", func() {
+		header := subject.Header()
+		hashes := map[uint64]uint64{
+			murmur64([]byte("xk"), header.HashSeed): logHeaderSize,
+			murmur64([]byte("zk"), header.HashSeed): logHeaderSize + 22,
+		}
+
+		var total uint64
+		seen := make(map[uint64]uint64)
+		Expect(subject.ForEach(func(i uint64, slot HashSlot) error {
+			same, ok := subject.Slot(i)
+			Expect(ok).To(BeTrue())
+			Expect(same).To(Equal(slot))
+			Expect(slot.BlockIndex).To(BeZero())
+			seen[slot.Hash] = slot.Offset
+			total += slot.Displacement
+			return nil
+		})).To(Succeed())
+		Expect(seen).To(Equal(hashes))
+		Expect(total).To(Equal(header.TotalDisplacement))
+
+		_, ok := subject.Slot(subject.Capacity())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should stop iteration on errors", func() {
+		calls := 0
+		Expect(subject.ForEach(func(uint64, HashSlot) error {
+			calls++
+			return errors.New("stop")
+		})).To(MatchError("stop"))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should fail when closed", func() {
+		subject.Close()
+		_, ok := subject.Slot(0)
+		Expect(ok).To(BeFalse())
+		Expect(subject.ForEach(func(uint64, HashSlot) error { return nil })).To(Equal(ERROR_HASH_CLOSED))
+	})
+})
@@ -0,0 +1,222 @@
+package sparkey
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+const (
+	logMagicNumber  = 0x49b39c95
+	hashMagicNumber = 0x9a11318f
+)
+
+// LogHeader contains the raw header fields of a log (.spl) file
+type LogHeader struct {
+	MajorVersion, MinorVersion uint32
+	// Random identifier, shared with all hash files built from this log
+	FileIdentifier uint32
+	// Number of put and delete entries
+	NumPuts, NumDeletes uint64
+	// Offset of the end of the data section
+	DataEnd uint64
+	// Size of the largest key and value
+	MaxKeyLen, MaxValueLen uint64
+	// Total size of all put and delete entries, in bytes
+	PutSize, DeleteSize uint64
+	// Compression type and block size
+	Compression          CompressionType
+	CompressionBlockSize uint32
+	// Maximum number of entries in a compressed block
+	MaxEntriesPerBlock uint32
+}
+
+// HashHeader contains the raw header fields of a hash (.spi) file
+type HashHeader struct {
+	MajorVersion, MinorVersion uint32
+	// Identifier of the log file the hash was built from
+	FileIdentifier uint32
+	// Seed of the hash function
+	HashSeed uint32
+	// Offset of the end of the log data section, at the time the hash was built
+	DataEnd uint64
+	// Size of the largest key and value
+	MaxKeyLen, MaxValueLen uint64
+	// Number of put entries in the log
+	NumPuts uint64
+	// Total size of overwritten and deleted entries in the log, in bytes
+	GarbageSize uint64
+	// Number of live entries
+	NumEntries uint64
+	// Size of log addresses and hash values, in bytes
+	AddressSize uint32
+	HashSize    HashSize
+	// Number of slots in the hash table
+	HashCapacity uint64
+	// Maximum and total displacement of entries from their ideal slot
+	MaxDisplacement, TotalDisplacement uint64
+	// Number of bits of an address used for the entry index inside a compressed block
+	EntryBlockBits uint32
+	// Number of hash collisions
+	HashCollisions uint64
+}
+
+// ReadLogHeader reads and decodes the header of a log file.
+func ReadLogHeader(fname string) (*LogHeader, error) {
+	return readLogHeader(LogFileName(fname))
+}
+
+// ReadHashHeader reads and decodes the header of a hash file.
+func ReadHashHeader(fname string) (*HashHeader, error) {
+	return readHashHeader(HashFileName(fname))
+}
+
+func readLogHeader(name string) (*LogHeader, error) {
+	buf, err := readHeader(name, logHeaderSize, ERROR_LOG_TOO_SMALL)
+	if err != nil {
+		return nil, err
+	}
+	return decodeLogHeader(buf)
+}
+
+func readHashHeader(name string) (*HashHeader, error) {
+	buf, err := readHeader(name, hashHeaderSize, ERROR_HASH_TOO_SMALL)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHashHeader(buf)
+}
+
+func readHeader(fname string, size int, tooSmall Error) ([]byte, error) {
+	file, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil, ERROR_FILE_NOT_FOUND
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(file, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, tooSmall
+	} else if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func decodeLogHeader(b []byte) (*LogHeader, error) {
+	if len(b) < logHeaderSize {
+		return nil, ERROR_LOG_TOO_SMALL
+	}
+
+	le := binary.LittleEndian
+	if le.Uint32(b[0:]) != logMagicNumber {
+		return nil, ERROR_WRONG_LOG_MAGIC_NUMBER
+	}
+
+	h := &LogHeader{
+		MajorVersion:         le.Uint32(b[4:]),
+		MinorVersion:         le.Uint32(b[8:]),
+		FileIdentifier:       le.Uint32(b[12:]),
+		NumPuts:              le.Uint64(b[16:]),
+		NumDeletes:           le.Uint64(b[24:]),
+		DataEnd:              le.Uint64(b[32:]),
+		MaxKeyLen:            le.Uint64(b[40:]),
+		MaxValueLen:          le.Uint64(b[48:]),
+		DeleteSize:           le.Uint64(b[56:]),
+		Compression:          CompressionType(le.Uint32(b[64:])),
+		CompressionBlockSize: le.Uint32(b[68:]),
+		PutSize:              le.Uint64(b[72:]),
+		MaxEntriesPerBlock:   le.Uint32(b[80:]),
+	}
+	if h.MajorVersion != 1 {
+		return nil, ERROR_WRONG_LOG_MAJOR_VERSION
+	} else if h.DataEnd < logHeaderSize {
+		return nil, ERROR_LOG_HEADER_CORRUPT
+	}
+	return h, nil
+}
+
+func decodeHashHeader(b []byte) (*HashHeader, error) {
+	if len(b) < hashHeaderSize {
+		return nil, ERROR_HASH_TOO_SMALL
+	}
+
+	le := binary.LittleEndian
+	if le.Uint32(b[0:]) != hashMagicNumber {
+		return nil, ERROR_WRONG_HASH_MAGIC_NUMBER
+	}
+
+	h := &HashHeader{
+		MajorVersion:      le.Uint32(b[4:]),
+		MinorVersion:      le.Uint32(b[8:]),
+		FileIdentifier:    le.Uint32(b[12:]),
+		HashSeed:          le.Uint32(b[16:]),
+		DataEnd:           le.Uint64(b[20:]),
+		MaxKeyLen:         le.Uint64(b[28:]),
+		MaxValueLen:       le.Uint64(b[36:]),
+		NumPuts:           le.Uint64(b[44:]),
+		GarbageSize:       le.Uint64(b[52:]),
+		NumEntries:        le.Uint64(b[60:]),
+		AddressSize:       le.Uint32(b[68:]),
+		HashSize:          HashSize(le.Uint32(b[72:])),
+		HashCapacity:      le.Uint64(b[76:]),
+		MaxDisplacement:   le.Uint64(b[84:]),
+		EntryBlockBits:    le.Uint32(b[92:]),
+		HashCollisions:    le.Uint64(b[96:]),
+		TotalDisplacement: le.Uint64(b[104:]),
+	}
+	if h.MajorVersion != 1 {
+		return nil, ERROR_WRONG_HASH_MAJOR_VERSION
+	} else if h.HashSize != HASH_SIZE_32BIT && h.HashSize != HASH_SIZE_64BIT {
+		return nil, ERROR_HASH_HEADER_CORRUPT
+	} else if h.AddressSize != 4 && h.AddressSize != 8 {
+		return nil, ERROR_HASH_HEADER_CORRUPT
+	}
+	return h, nil
+}
+
+func encodeLogHeader(h *LogHeader) []byte {
+	b := make([]byte, logHeaderSize)
+	le := binary.LittleEndian
+	le.PutUint32(b[0:], logMagicNumber)
+	le.PutUint32(b[4:], h.MajorVersion)
+	le.PutUint32(b[8:], h.MinorVersion)
+	le.PutUint32(b[12:], h.FileIdentifier)
+	le.PutUint64(b[16:], h.NumPuts)
+	le.PutUint64(b[24:], h.NumDeletes)
+	le.PutUint64(b[32:], h.DataEnd)
+	le.PutUint64(b[40:], h.MaxKeyLen)
+	le.PutUint64(b[48:], h.MaxValueLen)
+	le.PutUint64(b[56:], h.DeleteSize)
+	le.PutUint32(b[64:], uint32(h.Compression))
+	le.PutUint32(b[68:], h.CompressionBlockSize)
+	le.PutUint64(b[72:], h.PutSize)
+	le.PutUint32(b[80:], h.MaxEntriesPerBlock)
+	return b
+}
+
+func encodeHashHeader(h *HashHeader) []byte {
+	b := make([]byte, hashHeaderSize)
+	le := binary.LittleEndian
+	le.PutUint32(b[0:], hashMagicNumber)
+	le.PutUint32(b[4:], h.MajorVersion)
+	le.PutUint32(b[8:], h.MinorVersion)
+	le.PutUint32(b[12:], h.FileIdentifier)
+	le.PutUint32(b[16:], h.HashSeed)
+	le.PutUint64(b[20:], h.DataEnd)
+	le.PutUint64(b[28:], h.MaxKeyLen)
+	le.PutUint64(b[36:], h.MaxValueLen)
+	le.PutUint64(b[44:], h.NumPuts)
+	le.PutUint64(b[52:], h.GarbageSize)
+	le.PutUint64(b[60:], h.NumEntries)
+	le.PutUint32(b[68:], h.AddressSize)
+	le.PutUint32(b[72:], uint32(h.HashSize))
+	le.PutUint64(b[76:], h.HashCapacity)
+	le.PutUint64(b[84:], h.MaxDisplacement)
+	le.PutUint32(b[92:], h.EntryBlockBits)
+	le.PutUint64(b[96:], h.HashCollisions)
+	le.PutUint64(b[104:], h.TotalDisplacement)
+	return b
+}
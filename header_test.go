@@ -0,0 +1,89 @@
+package sparkey
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Headers", func() {
+	var fname string
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should read log headers", func() {
+		header, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		stat, err := os.Stat(fname + ".spl")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(header.MajorVersion).To(Equal(uint32(1)))
+		Expect(header.FileIdentifier).NotTo(BeZero())
+		Expect(header.NumPuts).To(Equal(uint64(3)))
+		Expect(header.NumDeletes).To(Equal(uint64(1)))
+		Expect(header.DataEnd).To(Equal(uint64(stat.Size())))
+		Expect(header.MaxKeyLen).To(Equal(uint64(2)))
+		Expect(header.MaxValueLen).To(Equal(uint64(len(veryLongString))))
+		Expect(header.PutSize).To(BeNumerically(">", len(veryLongString)))
+		Expect(header.DeleteSize).To(Equal(uint64(4)))
+		Expect(header.Compression).To(Equal(COMPRESSION_NONE))
+		Expect(header.CompressionBlockSize).To(BeZero())
+	})
+
+	It("should read hash headers", func() {
+		log, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		header, err := ReadHashHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(header.MajorVersion).To(Equal(uint32(1)))
+		Expect(header.FileIdentifier).To(Equal(log.FileIdentifier))
+		Expect(header.DataEnd).To(Equal(log.DataEnd))
+		Expect(header.MaxKeyLen).To(Equal(uint64(2)))
+		Expect(header.MaxValueLen).To(Equal(uint64(len(veryLongString))))
+		Expect(header.NumPuts).To(Equal(uint64(3)))
+		Expect(header.NumEntries).To(Equal(uint64(2)))
+		Expect(header.GarbageSize).To(BeNumerically(">", 0))
+		Expect(header.HashSize).To(Equal(HASH_SIZE_64BIT))
+		Expect(header.HashCapacity).To(BeNumerically(">=", 2))
+	})
+
+	It("should expose headers on readers", func() {
+		log, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		hash, err := ReadHashHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Header()).To(Equal(*hash))
+		Expect(reader.Log().Header()).To(Equal(*log))
+
+		logReader, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer logReader.Close()
+		Expect(logReader.Header()).To(Equal(*log))
+	})
+
+	It("should fail on bad files", func() {
+		_, err := ReadLogHeader(fname + ".missing")
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+
+		Expect(ioutil.WriteFile(fname+".spl", []byte("short"), 0644)).NotTo(HaveOccurred())
+		_, err = ReadLogHeader(fname)
+		Expect(err).To(Equal(ERROR_LOG_TOO_SMALL))
+
+		Expect(ioutil.WriteFile(fname+".spi", make([]byte, 200), 0644)).NotTo(HaveOccurred())
+		_, err = ReadHashHeader(fname)
+		Expect(err).To(Equal(ERROR_WRONG_HASH_MAGIC_NUMBER))
+	})
+
+})
@@ -0,0 +1,354 @@
+package sparkey
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// ErrIsolatedWorker is returned by IsolatedReader when its worker process
+// has failed, e.g. because it crashed on a malformed file.
+var ErrIsolatedWorker = errors.New("sparkey: isolated worker failed")
+
+// Environment variables which pass the files to an isolated worker
+const (
+	isolatedLogEnv   = "SPARKEY_ISOLATED_LOG"
+	isolatedIndexEnv = "SPARKEY_ISOLATED_INDEX"
+)
+
+// defaultIsolatedWorker is the name of the worker binary, which is looked
+// up in PATH, see cmd/sparkey-worker.
+const defaultIsolatedWorker = "sparkey-worker"
+
+// Frame tags of the worker protocol. Every frame consists of a tag, the
+// (uvarint) payload length and the payload.
+const (
+	isolatedGet      = 'g' // request: key
+	isolatedExists   = 'e' // request: key
+	isolatedHeaders  = 'h' // response: hash and log headers
+	isolatedValue    = 'v' // response: value
+	isolatedFound    = 'y' // response: empty
+	isolatedNotFound = 'n' // response: empty
+	isolatedError    = 'x' // response: (varint) error code and message
+)
+
+// IsolatedReader reads a hash/log pair in a separate worker process, a
+// binary which calls RunIsolatedWorker, e.g. cmd/sparkey-worker. Files are
+// only ever parsed by the worker, a malformed or malicious file can crash
+// or compromise the worker, but not the calling process. IsolatedReader is
+// meant for tools which must handle untrusted files and is considerably
+// slower than HashReader. Readers are safe for concurrent use, requests are
+// serialised.
+type IsolatedReader struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+
+	header    *HashHeader
+	logHeader *LogHeader
+
+	mu  sync.Mutex
+	err error // sticky worker error
+}
+
+// OpenIsolated opens a hash/log pair in a new worker process. The worker
+// binary is sparkey-worker, looked up in PATH, unless set WithIsolatedWorker,
+// which is the only supported option.
+func OpenIsolated(fname string, opts ...Option) (*IsolatedReader, error) {
+	conf := newConfig(opts)
+	if err := conf.check(optIsolatedWorker); err != nil {
+		return nil, err
+	}
+	worker := conf.isolatedWorker
+	if worker == "" {
+		worker = defaultIsolatedWorker
+	}
+
+	exe, err := exec.LookPath(worker)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), isolatedLogEnv+"="+LogFileName(fname), isolatedIndexEnv+"="+HashFileName(fname))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	r := &IsolatedReader{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+	tag, payload, err := r.receive()
+	if err == nil && tag == isolatedHeaders {
+		err = r.decodeHeaders(payload)
+	} else if err == nil {
+		err = r.fail(ErrIsolatedWorker)
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Header returns the hash file header
+func (r *IsolatedReader) Header() HashHeader { return *r.header }
+
+// LogHeader returns the log file header
+func (r *IsolatedReader) LogHeader() LogHeader { return *r.logHeader }
+
+// Get retrieves the value of a key. It returns nil when a key doesn't exist.
+func (r *IsolatedReader) Get(key []byte) ([]byte, error) {
+	tag, payload, err := r.roundTrip(isolatedGet, key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case isolatedValue:
+		if payload == nil {
+			payload = []byte{}
+		}
+		return payload, nil
+	case isolatedNotFound:
+		return nil, nil
+	}
+	return nil, r.fail(ErrIsolatedWorker)
+}
+
+// Exists returns true if a live entry exists for the given key.
+func (r *IsolatedReader) Exists(key []byte) (bool, error) {
+	tag, _, err := r.roundTrip(isolatedExists, key)
+	if err != nil {
+		return false, err
+	}
+
+	switch tag {
+	case isolatedFound:
+		return true, nil
+	case isolatedNotFound:
+		return false, nil
+	}
+	return false, r.fail(ErrIsolatedWorker)
+}
+
+// Close stops the worker process.
+func (r *IsolatedReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cmd == nil {
+		return nil
+	}
+	r.stdin.Close()
+	err := r.cmd.Wait()
+	r.cmd = nil
+	if r.err == nil {
+		r.err = ERROR_HASH_CLOSED
+	}
+	if err != nil {
+		return ErrIsolatedWorker
+	}
+	return nil
+}
+
+// roundTrip sends a request and waits for the response. Errors reported by
+// the worker are returned as such, protocol errors stop the worker.
+func (r *IsolatedReader) roundTrip(tag byte, payload []byte) (byte, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, nil, r.err
+	}
+
+	buf := bufio.NewWriter(r.stdin)
+	if err := writeIsolatedFrame(buf, tag, payload); err != nil {
+		return 0, nil, r.fail(ErrIsolatedWorker)
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, nil, r.fail(ErrIsolatedWorker)
+	}
+	return r.receive()
+}
+
+// receive reads a response frame, converting error frames into errors.
+func (r *IsolatedReader) receive() (byte, []byte, error) {
+	tag, payload, err := readIsolatedFrame(r.stdout)
+	if err != nil {
+		return 0, nil, r.fail(ErrIsolatedWorker)
+	} else if tag != isolatedError {
+		return tag, payload, nil
+	}
+
+	code, n := binary.Varint(payload)
+	if n <= 0 {
+		return 0, nil, r.fail(ErrIsolatedWorker)
+	} else if code != 0 {
+		return 0, nil, Error(code)
+	}
+	return 0, nil, errors.New(string(payload[n:]))
+}
+
+// decodeHeaders decodes the headers sent by the worker after opening.
+func (r *IsolatedReader) decodeHeaders(payload []byte) (err error) {
+	if len(payload) != hashHeaderSize+logHeaderSize {
+		return r.fail(ErrIsolatedWorker)
+	}
+	if r.header, err = decodeHashHeader(payload[:hashHeaderSize]); err != nil {
+		return r.fail(ErrIsolatedWorker)
+	}
+	if r.logHeader, err = decodeLogHeader(payload[hashHeaderSize:]); err != nil {
+		return r.fail(ErrIsolatedWorker)
+	}
+	return nil
+}
+
+// fail records a sticky error and kills the worker, which may be in an
+// undefined state.
+func (r *IsolatedReader) fail(err error) error {
+	if r.err == nil {
+		r.err = err
+		r.cmd.Process.Kill()
+	}
+	return err
+}
+
+/* Worker */
+
+// RunIsolatedWorker turns the process into the worker of an IsolatedReader.
+// It serves requests for the hash/log pair passed by OpenIsolated on stdin
+// and stdout until stdin is closed, and returns the exit code, which is 2
+// if the process was not started by OpenIsolated. It is meant to be called
+// from the main function of a dedicated binary:
+//
+//	func main() {
+//		os.Exit(sparkey.RunIsolatedWorker())
+//	}
+func RunIsolatedWorker() int {
+	logname, hashname := os.Getenv(isolatedLogEnv), os.Getenv(isolatedIndexEnv)
+	if logname == "" || hashname == "" {
+		fmt.Fprintln(os.Stderr, "sparkey: not started as an isolated worker")
+		return 2
+	}
+	return runIsolatedWorker(logname, hashname, os.Stdin, os.Stdout)
+}
+
+// runIsolatedWorker serves requests for a hash/log pair until the input is
+// closed, it returns the exit code of the worker.
+func runIsolatedWorker(logname, hashname string, in io.Reader, out io.Writer) int {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	reader, err := OpenCustomHashReader(hashname, logname)
+	if err != nil {
+		writeIsolatedError(w, err)
+		return 1
+	}
+	defer reader.Close()
+
+	headers := encodeHashHeader(reader.header)
+	headers = append(headers, encodeLogHeader(reader.logHeader)...)
+	if err := writeIsolatedFrame(w, isolatedHeaders, headers); err != nil {
+		return 1
+	}
+
+	rd := bufio.NewReader(in)
+	for {
+		if err := w.Flush(); err != nil {
+			return 1
+		}
+
+		tag, key, err := readIsolatedFrame(rd)
+		if err == io.EOF {
+			return 0
+		} else if err != nil {
+			return 1
+		}
+
+		if err := serveIsolatedRequest(w, reader, tag, key); err != nil {
+			return 1
+		}
+	}
+}
+
+// serveIsolatedRequest handles a single request, it only returns errors
+// that prevent the worker from responding.
+func serveIsolatedRequest(w io.Writer, reader *HashReader, tag byte, key []byte) error {
+	switch tag {
+	case isolatedGet:
+		val, err := reader.Get(key)
+		if err != nil {
+			return writeIsolatedError(w, err)
+		} else if val == nil {
+			return writeIsolatedFrame(w, isolatedNotFound, nil)
+		}
+		return writeIsolatedFrame(w, isolatedValue, val)
+	case isolatedExists:
+		ok, err := reader.Exists(key)
+		if err != nil {
+			return writeIsolatedError(w, err)
+		} else if ok {
+			return writeIsolatedFrame(w, isolatedFound, nil)
+		}
+		return writeIsolatedFrame(w, isolatedNotFound, nil)
+	}
+	return ErrIsolatedWorker
+}
+
+/* Protocol */
+
+func writeIsolatedFrame(w io.Writer, tag byte, payload []byte) error {
+	var head [1 + binary.MaxVarintLen64]byte
+	head[0] = tag
+	n := 1 + binary.PutUvarint(head[1:], uint64(len(payload)))
+	if _, err := w.Write(head[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func writeIsolatedError(w io.Writer, err error) error {
+	var code Error
+	errors.As(err, &code)
+	payload := make([]byte, binary.MaxVarintLen64)
+	payload = payload[:binary.PutVarint(payload, int64(code))]
+	if code == 0 {
+		payload = append(payload, err.Error()...)
+	}
+	return writeIsolatedFrame(w, isolatedError, payload)
+}
+
+// readIsolatedFrame reads a frame. Payloads are read incrementally, so a
+// bogus length cannot cause large allocations.
+func readIsolatedFrame(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+
+	var buf bytes.Buffer
+	if n, err := io.Copy(&buf, io.LimitReader(r, int64(size))); err != nil {
+		return 0, nil, err
+	} else if uint64(n) != size {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return tag, buf.Bytes(), nil
+}
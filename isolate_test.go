@@ -0,0 +1,95 @@
+package sparkey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// the test binary doubles as the worker of isolated readers
+func init() {
+	if os.Getenv(isolatedLogEnv) != "" {
+		os.Exit(RunIsolatedWorker())
+	}
+}
+
+var _ = Describe("IsolatedReader", func() {
+	var fname string
+	var subject *IsolatedReader
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeTestHash(testDir, func(w *LogWriter) error {
+			if err := w.Put([]byte("xk"), []byte("short")); err != nil {
+				return err
+			}
+			if err := w.Put([]byte("ek"), nil); err != nil {
+				return err
+			}
+			return w.Put([]byte("zk"), []byte(veryLongString))
+		})
+		Expect(err).NotTo(HaveOccurred())
+		subject, err = OpenIsolated(fname, WithIsolatedWorker(os.Args[0]))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should read headers", func() {
+		hash, err := ReadHashHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		log, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(subject.Header()).To(Equal(*hash))
+		Expect(subject.LogHeader()).To(Equal(*log))
+	})
+
+	It("should retrieve values", func() {
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(subject.Get([]byte("zk"))).To(Equal([]byte(veryLongString)))
+		Expect(subject.Get([]byte("ek"))).To(Equal([]byte{}))
+		Expect(subject.Get([]byte("missing"))).To(BeNil())
+		Expect(subject.Exists([]byte("xk"))).To(BeTrue())
+		Expect(subject.Exists([]byte("missing"))).To(BeFalse())
+	})
+
+	It("should fail after close", func() {
+		Expect(subject.Close()).To(Succeed())
+		Expect(subject.Close()).To(Succeed())
+		_, err := subject.Get([]byte("xk"))
+		Expect(err).To(Equal(ERROR_HASH_CLOSED))
+	})
+
+	It("should report worker failures", func() {
+		Expect(subject.cmd.Process.Kill()).To(Succeed())
+		_, err := subject.Get([]byte("xk"))
+		Expect(err).To(Equal(ErrIsolatedWorker))
+		_, err = subject.Exists([]byte("xk"))
+		Expect(err).To(Equal(ErrIsolatedWorker))
+	})
+
+	It("should report open errors", func() {
+		_, err := OpenIsolated(filepath.Join(testDir, "missing"), WithIsolatedWorker(os.Args[0]))
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+
+		Expect(ioutil.WriteFile(fname+".spi", make([]byte, 200), 0644)).To(Succeed())
+		_, err = OpenIsolated(fname, WithIsolatedWorker(os.Args[0]))
+		Expect(err).To(Equal(ERROR_WRONG_HASH_MAGIC_NUMBER))
+	})
+
+	It("should require a worker binary", func() {
+		_, err := OpenIsolated(fname, WithIsolatedWorker(filepath.Join(testDir, "missing-worker")))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should only run workers started by OpenIsolated", func() {
+		Expect(RunIsolatedWorker()).To(Equal(2))
+	})
+
+})
@@ -1,14 +1,24 @@
 package sparkey
 
-//#include <stdlib.h>
-//#include <sparkey/sparkey.h>
-import "C"
 import (
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
-	"unsafe"
+	"os"
 )
 
+// ErrOffsetUnsupported is returned when seeking to an offset in a compressed
+// log or reading its values at offsets, entries of compressed logs cannot be
+// addressed by offset.
+var ErrOffsetUnsupported = errors.New("sparkey: offsets are not supported by compressed logs")
+
+// ErrCloneUnsupported is returned by Clone when the position of an iterator
+// is not known, see Clone.
+var ErrCloneUnsupported = errors.New("sparkey: iterator position is unknown")
+
+var errNegativeOffset = errors.New("sparkey: negative offset")
+
 type Reader interface {
 	io.Reader
 	io.WriterTo
@@ -34,9 +44,14 @@ type Reader interface {
 //     }
 //
 type LogIter struct {
-	iter *C.sparkey_logiter
-	log  *C.sparkey_logreader
+	iter *logIterHandle
+	log  *logReaderHandle
+	snap *logSnapshot // released on Close, if created by a LogReader
 	err  error
+
+	offset, next uint64 // positions of the current and next entry, tracked by cgo builds only
+	track        bool   // see TrackOffsets
+	autoReset    bool
 }
 
 // Err returns an error if one has occurred during iteration.
@@ -44,43 +59,64 @@ func (i *LogIter) Err() error {
 	return i.err
 }
 
-// Closes a log iterator.
-// This is a failsafe operation.
-func (i *LogIter) Close() {
-	if i.iter != nil {
-		C.sparkey_logiter_close(&i.iter)
+// entrySize returns the encoded size of the current entry in an
+// uncompressed log.
+func (i *LogIter) entrySize() uint64 {
+	klen, vlen := i.KeyLen(), i.ValueLen()
+	if i.EntryType() == ENTRY_DELETE {
+		return 1 + vlqLen(klen) + klen
 	}
-	i.iter = nil
+	return vlqLen(klen+1) + vlqLen(vlen) + klen + vlen
 }
 
-// Skip skips a number of entries.
-// This is equivalent to calling Next count number of times.
-func (i *LogIter) Skip(count int) error {
-	rc := C.sparkey_logiter_skip(i.iter, i.log, C.int(count))
-	if rc != rc_SUCCESS && rc != rc_ITERINACTIVE-205 {
-		i.err = Error(rc)
+// NextContext is like Next, but returns the context's error without moving
+// the iterator if ctx is done. Use it for long scans which must respect
+// cancellation and deadlines.
+func (i *LogIter) NextContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return errorOrNil(rc)
+	return i.Next()
 }
 
-// Next prepares the iterator to start reading from the next entry.
-// The value of State() will be:
-//   ITERATOR_CLOSED if the last entry has been passed.
-//   ITERATOR_INVALID if anything goes wrong.
-//   ITERATOR_ACTIVE if it successfully reached the next entry.
-func (i *LogIter) Next() error {
-	rc := C.sparkey_logiter_next(i.iter, i.log)
-	if rc != rc_SUCCESS && rc != rc_ITERINACTIVE {
-		i.err = Error(rc)
+// SeekOffset positions the iterator before the entry at offset, as returned
+// by Offset, so that the next call to Next moves to that entry. This allows
+// to resume processing from a saved position. Offsets are only supported by
+// uncompressed logs.
+func (i *LogIter) SeekOffset(offset uint64) error {
+	if i.compression() != COMPRESSION_NONE {
+		return ErrOffsetUnsupported
+	} else if offset < logHeaderSize {
+		return ERROR_LOG_ITERATOR_INACTIVE
 	}
-	return errorOrNil(rc)
+	if err := i.seek(offset); err != nil {
+		return err
+	}
+	i.TrackOffsets(true)
+	return nil
+}
+
+// TrackOffsets makes the iterator keep track of the Offset of its entries
+// while it moves on sequentially. libsparkey doesn't expose the position of
+// iterators, so cgo builds compute it from the size of each entry, which
+// costs four additional calls per Next and makes Skip step through the
+// entries one by one. Tracking starts from a known position, i.e. a new
+// iterator or SeekOffset, which enables it. Pure Go builds always know the
+// position of their iterators, there it has no effect.
+func (i *LogIter) TrackOffsets(enable bool) {
+	i.trackOffsets(enable)
 }
 
-// Reset resets the iterator to the start of the current entry. This is only valid if
-// state is ITERATOR_ACTIVE.
-func (i *LogIter) Reset() error {
-	rc := C.sparkey_logiter_reset(i.iter, i.log)
-	return errorOrNil(rc)
+// Clone creates an independent iterator over the same log, positioned at
+// the start of the current entry, e.g. to look ahead without moving this
+// iterator. Parts of the entry that have already been read can be read
+// again from the clone.
+//
+// libsparkey doesn't expose the position of iterators, so in cgo builds only
+// new iterators and iterators with a known Offset, see TrackOffsets, can be
+// cloned, others return ErrCloneUnsupported.
+func (i *LogIter) Clone() (*LogIter, error) {
+	return i.clone()
 }
 
 // Valid returns true if iterator is at a valid position
@@ -88,30 +124,51 @@ func (i *LogIter) Valid() bool {
 	return i.State() == ITERATOR_ACTIVE
 }
 
-// State gets the state for an iterator.
-func (i *LogIter) State() IteratorState {
-	return IteratorState(C.sparkey_logiter_state(i.iter))
+// AutoReset makes Key and Value repeatable within the same entry, by
+// rewinding it before and after each call, see PeekKey. In cgo builds, this
+// costs two additional calls per read.
+func (i *LogIter) AutoReset(enable bool) {
+	i.autoReset = enable
 }
 
-// EntryType returns the type of the current entry.
-func (i *LogIter) EntryType() EntryType {
-	return EntryType(C.sparkey_logiter_type(i.iter))
+// Key returns the full key at the current position.
+// This method will return a result only once per iteration, unless
+// AutoReset is enabled.
+// Empty keys are returned as non-nil, empty slices.
+func (i *LogIter) Key() ([]byte, error) {
+	if i.autoReset {
+		return i.PeekKey()
+	}
+	return i.readKey()
 }
 
-// KeyLen returns the key length of the current entry.
-func (i *LogIter) KeyLen() uint64 {
-	return uint64(C.sparkey_logiter_keylen(i.iter))
+func (i *LogIter) readKey() ([]byte, error) {
+	return ioutil.ReadAll(i.KeyReader())
 }
 
-// ValueLen returns the value length of the current entry.
-func (i *LogIter) ValueLen() uint64 {
-	return uint64(C.sparkey_logiter_valuelen(i.iter))
+// PeekKey returns the full key at the current position, like Key, but
+// rewinds the entry before and afterwards, so that it can be read again,
+// e.g. by Key or Value.
+func (i *LogIter) PeekKey() ([]byte, error) {
+	return i.peek(i.readKey)
 }
 
-// Key returns the full key at the current position.
-// This method will return a result only once per iteration.
-func (i *LogIter) Key() ([]byte, error) {
-	return ioutil.ReadAll(i.KeyReader())
+// KeyInto appends the key at the current position to dst and returns the
+// extended slice, which allows to reuse buffers across iterations. Like Key,
+// this method will return a result only once per iteration.
+func (i *LogIter) KeyInto(dst []byte) ([]byte, error) {
+	return i.appendFilled(dst, i.KeyLen(), i.fillKey)
+}
+
+// KeyChunkUnsafe consumes and returns up to max bytes of the key at the
+// current position, an empty chunk once the key is exhausted. Unlike
+// KeyReader, it does not copy: the chunk aliases memory owned by the
+// reader, e.g. the mapped file or a decompression buffer. It must not be
+// modified and is only valid until the next operation on the iterator, or
+// until the reader is closed. Using it afterwards may return garbage or
+// crash the process.
+func (i *LogIter) KeyChunkUnsafe(max uint64) ([]byte, error) {
+	return i.keyChunkUnsafe(max)
 }
 
 // KeyReader returns an io.Reader for the key. The reader also implements
@@ -121,27 +178,143 @@ func (i *LogIter) KeyReader() Reader {
 }
 
 // Value returns the full values at the current position.
-// This method will return a result only once per iteration.
+// This method will return a result only once per iteration, unless
+// AutoReset is enabled.
+// Empty values are returned as non-nil, empty slices.
 func (i *LogIter) Value() ([]byte, error) {
+	if i.autoReset {
+		return i.PeekValue()
+	}
+	return i.readValue()
+}
+
+func (i *LogIter) readValue() ([]byte, error) {
 	return ioutil.ReadAll(i.ValueReader())
 }
 
+// PeekValue returns the full value at the current position, like Value, but
+// rewinds the entry before and afterwards, see PeekKey.
+func (i *LogIter) PeekValue() ([]byte, error) {
+	return i.peek(i.readValue)
+}
+
+// ValueInto appends the value at the current position to dst and returns
+// the extended slice, see KeyInto.
+func (i *LogIter) ValueInto(dst []byte) ([]byte, error) {
+	return i.appendFilled(dst, i.ValueLen(), i.fillValue)
+}
+
+// ValueChunkUnsafe consumes and returns up to max bytes of the value at the
+// current position without copying them, see KeyChunkUnsafe.
+func (i *LogIter) ValueChunkUnsafe(max uint64) ([]byte, error) {
+	return i.valueChunkUnsafe(max)
+}
+
 // ValueReader returns an io.Reader for the value. The reader also implements
 // io.WriterTo. The reader is no longer valid once the iterator has proceeded.
 func (i *LogIter) ValueReader() Reader {
 	return &valueReader{i}
 }
 
-// Compare compares the keys of two iterators pointing to the same log.
-// It assumes that the iterators are both clean, i.e. nothing has been consumed from the current entry.
-// It will return zero if the keys are equal, negative if key1 is smaller than key2 and positive if key1 is larger than key2.
-func (i *LogIter) Compare(other *LogIter) (int, error) {
-	var res C.int
-	rc := C.sparkey_logiter_keycmp(i.iter, other.iter, i.log, &res)
-	if rc != rc_SUCCESS {
-		return 0, Error(rc)
+// peek reads from the start of the current entry using read and rewinds it.
+func (i *LogIter) peek(read func() ([]byte, error)) ([]byte, error) {
+	if err := i.Reset(); err != nil {
+		return nil, err
+	}
+	b, err := read()
+	if err != nil {
+		return nil, err
 	}
-	return int(res), nil
+	return b, i.Reset()
+}
+
+// ValueReaderAt returns an io.ReaderAt for the value at the current position
+// of an uncompressed log, together with the value length, for random access
+// within large values. Reads are served from the mapped log file without
+// copying the whole value, the reader remains valid until the log is
+// closed. Compressed logs return ErrOffsetUnsupported. Like Value, this
+// method will return a result only once per iteration.
+func (i *LogIter) ValueReaderAt() (io.ReaderAt, int64, error) {
+	if i.State() != ITERATOR_ACTIVE {
+		return nil, 0, ERROR_LOG_ITERATOR_INACTIVE
+	} else if i.compression() != COMPRESSION_NONE {
+		return nil, 0, ErrOffsetUnsupported
+	}
+
+	size := i.ValueLen()
+	var chunks chunkReaderAt
+	for remaining := size; remaining > 0; {
+		chunk, err := i.valueChunkUnsafe(remaining)
+		if err != nil {
+			return nil, 0, err
+		} else if len(chunk) == 0 {
+			return nil, 0, ERROR_UNEXPECTED_EOF
+		}
+		chunks = append(chunks, chunk)
+		remaining -= uint64(len(chunk))
+	}
+	return chunks, int64(size), nil
+}
+
+// Entry returns a copy of the entry at the current position, which remains
+// valid after the iterator has proceeded. The value of delete operations is
+// nil, the offset is set where it is known, see Offset. Like Key and Value,
+// this method will return a result only once per iteration.
+func (i *LogIter) Entry() (Entry, error) {
+	key, err := i.Key()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{Type: i.EntryType(), Key: key, Offset: i.Offset(), KeyLen: i.KeyLen(), ValueLen: i.ValueLen()}
+	if entry.Type == ENTRY_PUT {
+		if entry.Value, err = i.Value(); err != nil {
+			return Entry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// ValueToFile copies the value at the current position into a new temporary
+// file in dir, or in the default directory for temporary files if dir is
+// empty. It returns the file, positioned at the start. The caller is
+// responsible for closing and removing it. Like Value, this method will
+// return a result only once per iteration.
+func (i *LogIter) ValueToFile(dir string) (*os.File, error) {
+	if i.State() != ITERATOR_ACTIVE {
+		return nil, ERROR_LOG_ITERATOR_INACTIVE
+	}
+
+	tmp, err := ioutil.TempFile(dir, "sparkey-value-")
+	if err != nil {
+		return nil, fileError(err)
+	}
+
+	_, err = io.Copy(tmp, i.ValueReader())
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// ValueOrFile returns the value at the current position like Value, unless
+// it is longer than limit bytes. Longer values are copied to a temporary
+// file in dir instead, see ValueToFile, so that pathologically large values
+// don't have to be held in memory. A negative limit disables spilling.
+// Exactly one of the results is non-nil on success.
+func (i *LogIter) ValueOrFile(limit int64, dir string) ([]byte, *os.File, error) {
+	if i.ValueLen() <= uint64(limit) || limit < 0 {
+		val, err := i.Value()
+		return val, nil, err
+	}
+
+	file, err := i.ValueToFile(dir)
+	return nil, file, err
 }
 
 /* Hash iterator */
@@ -153,41 +326,150 @@ type HashIter struct {
 	reader *HashReader
 }
 
-// Seek positions the cursor on the given key.
-// Sets the iterator state to ITERATOR_INVALID when key cannot be found.
-func (i *HashIter) Seek(key []byte) error {
-	var k *C.uint8_t
+// Get retrieves a value for a given key
+// Returns nil when a value cannot be found. An existing, empty value is
+// returned as a non-nil, empty slice, so the two cases can be told apart.
+func (i *HashIter) Get(key []byte) ([]byte, error) {
+	if err := i.Seek(key); err != nil {
+		return nil, err
+	} else if i.State() != ITERATOR_ACTIVE {
+		return nil, nil
+	} else if i.ValueLen() == 0 {
+		return []byte{}, nil
+	}
+	return i.Value()
+}
+
+// entry returns the entry of key, or nil when it cannot be found.
+func (i *HashIter) entry(key []byte) (*Entry, error) {
+	if err := i.Seek(key); err != nil {
+		return nil, err
+	} else if i.State() != ITERATOR_ACTIVE {
+		return nil, nil
+	} else if err := i.Reset(); err != nil {
+		return nil, err
+	}
 
-	lk := len(key)
-	if lk > 0 {
-		k = (*C.uint8_t)(&key[0])
+	entry, err := i.Entry()
+	if err != nil {
+		return nil, err
+	} else if entry.Value == nil {
+		entry.Value = []byte{}
 	}
-	rc := C.sparkey_hash_get(i.reader.hash, k, C.uint64_t(lk), i.iter)
-	return errorOrNil(rc)
+	return &entry, nil
 }
 
-// Get retrieves a value for a given key
-// Returns nil when a value cannot be found.
-func (i *HashIter) Get(key []byte) ([]byte, error) {
+// GetInto is like Get, but appends the value to dst and returns the
+// extended slice, which allows to reuse buffers across lookups. It returns
+// nil when the value cannot be found.
+func (i *HashIter) GetInto(key, dst []byte) ([]byte, error) {
+	if err := i.Seek(key); err != nil {
+		return nil, err
+	} else if i.State() != ITERATOR_ACTIVE {
+		return nil, nil
+	}
+	return i.ValueInto(dst)
+}
+
+// GetReader seeks to key and returns a reader for its value, together with
+// the value length, which allows to stream large values without copying
+// them into memory. The reader is nil when the key cannot be found and is
+// no longer valid once the iterator has moved.
+func (i *HashIter) GetReader(key []byte) (io.Reader, int64, error) {
+	if err := i.Seek(key); err != nil {
+		return nil, 0, err
+	} else if i.State() != ITERATOR_ACTIVE {
+		return nil, 0, nil
+	}
+	return i.ValueReader(), int64(i.ValueLen()), nil
+}
+
+// Exists returns true if a live entry exists for the given key.
+// Only the hash index and the stored key are consulted, the value is never read.
+func (i *HashIter) Exists(key []byte) (bool, error) {
 	if err := i.Seek(key); err != nil {
+		return false, err
+	}
+	return i.State() == ITERATOR_ACTIVE, nil
+}
+
+// Clone creates an independent iterator over the same reader, see
+// LogIter.Clone.
+func (i *HashIter) Clone() (*HashIter, error) {
+	iter, err := i.LogIter.Clone()
+	if err != nil {
 		return nil, err
-	} else if i.State() == ITERATOR_ACTIVE {
-		return i.Value()
 	}
-	return nil, nil
+	return &HashIter{LogIter: iter, reader: i.reader}, nil
 }
 
-// NextLive positions the cursor at the next non-deleted "live" key
-func (i *HashIter) NextLive() error {
-	rc := C.sparkey_logiter_hashnext(i.iter, i.reader.hash)
-	if rc != rc_SUCCESS && rc != rc_ITERINACTIVE {
-		i.err = Error(rc)
+// NextLiveContext is like NextLive, but returns the context's error without
+// moving the iterator if ctx is done.
+func (i *HashIter) NextLiveContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return errorOrNil(rc)
+	return i.NextLive()
 }
 
 /* Key/value reader */
 
+// chunkReaderAt is an io.ReaderAt over consecutive chunks of a value.
+type chunkReaderAt [][]byte
+
+func (c chunkReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+
+	n := 0
+	for _, chunk := range c {
+		if off >= int64(len(chunk)) {
+			off -= int64(len(chunk))
+			continue
+		}
+
+		n += copy(b[n:], chunk[off:])
+		off = 0
+		if n == len(b) {
+			return n, nil
+		}
+	}
+	return n, io.EOF
+}
+
+// appendFilled extends dst by up to n bytes of the current entry using fill.
+// The result is never nil.
+func (i *LogIter) appendFilled(dst []byte, n uint64, fill func([]byte) (int, error)) ([]byte, error) {
+	if i.State() != ITERATOR_ACTIVE {
+		return dst, ERROR_LOG_ITERATOR_INACTIVE
+	} else if dst == nil {
+		dst = []byte{}
+	}
+	if n == 0 {
+		return dst, nil
+	}
+
+	start := len(dst)
+	if uint64(cap(dst)-start) < n {
+		grown := make([]byte, start, uint64(start)+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	m, err := fill(dst[start : uint64(start)+n])
+	return dst[:start+m], err
+}
+
+// vlqLen returns the number of bytes required to encode n as a
+// variable-length quantity
+func vlqLen(n uint64) uint64 {
+	size := uint64(1)
+	for ; n >= 0x80; n >>= 7 {
+		size++
+	}
+	return size
+}
+
 type keyReader struct {
 	*LogIter
 }
@@ -197,20 +479,11 @@ func (k *keyReader) Read(b []byte) (int, error) {
 		return 0, nil
 	}
 
-	var max, size C.uint64_t
-	var ptr *C.uint8_t
-	var err error
-
-	max = C.uint64_t(len(b))
-	ptr = (*C.uint8_t)(&b[0])
-	rc := C.sparkey_logiter_fill_key(k.iter, k.log, max, ptr, &size)
-	if rc != rc_SUCCESS {
-		err = Error(rc)
-	} else if size == 0 {
+	n, err := k.fillKey(b)
+	if err == nil && n == 0 {
 		err = io.EOF
 	}
-
-	return int(size), err
+	return n, err
 }
 
 func (k *keyReader) WriteTo(w io.Writer) (int64, error) {
@@ -218,20 +491,16 @@ func (k *keyReader) WriteTo(w io.Writer) (int64, error) {
 		return 0, ERROR_LOG_ITERATOR_INACTIVE
 	}
 
-	var size C.uint64_t
-	var ptr *C.uint8_t
-
 	remaining := k.KeyLen()
 	var written int64
 	for remaining > 0 {
-		rc := C.sparkey_logiter_keychunk(k.iter, k.log, C.uint64_t(remaining), &ptr, &size)
-		if rc != rc_SUCCESS {
-			return written, Error(rc)
-		} else if size == 0 {
+		buf, err := k.keyChunk(remaining)
+		if err != nil {
+			return written, err
+		} else if len(buf) == 0 {
 			return written, nil
 		}
 
-		buf := C.GoBytes(unsafe.Pointer(ptr), C.int(size))
 		n, err := w.Write(buf)
 		written += int64(n)
 		remaining -= uint64(n)
@@ -252,21 +521,11 @@ func (v *valueReader) Read(b []byte) (int, error) {
 		return 0, nil
 	}
 
-	var max, size C.uint64_t
-	var ptr *C.uint8_t
-	var err error
-
-	max = C.uint64_t(len(b))
-	ptr = (*C.uint8_t)(&b[0])
-
-	rc := C.sparkey_logiter_fill_value(v.iter, v.log, max, ptr, &size)
-	if rc != rc_SUCCESS {
-		err = Error(rc)
-	} else if size == 0 {
+	n, err := v.fillValue(b)
+	if err == nil && n == 0 {
 		err = io.EOF
 	}
-
-	return int(size), err
+	return n, err
 }
 
 func (v *valueReader) WriteTo(w io.Writer) (int64, error) {
@@ -274,20 +533,16 @@ func (v *valueReader) WriteTo(w io.Writer) (int64, error) {
 		return 0, ERROR_LOG_ITERATOR_INACTIVE
 	}
 
-	var size C.uint64_t
-	var ptr *C.uint8_t
-
 	remaining := v.ValueLen()
 	var written int64
 	for remaining > 0 {
-		rc := C.sparkey_logiter_valuechunk(v.iter, v.log, C.uint64_t(remaining), &ptr, &size)
-		if rc != rc_SUCCESS {
-			return written, Error(rc)
-		} else if size == 0 {
+		buf, err := v.valueChunk(remaining)
+		if err != nil {
+			return written, err
+		} else if len(buf) == 0 {
 			return written, nil
 		}
 
-		buf := C.GoBytes(unsafe.Pointer(ptr), C.int(size))
 		n, err := w.Write(buf)
 		written += int64(n)
 		remaining -= uint64(n)
@@ -298,3 +553,11 @@ func (v *valueReader) WriteTo(w io.Writer) (int64, error) {
 
 	return written, nil
 }
+
+// releaseSnapshot releases the snapshot of the iterator, once.
+func (i *LogIter) releaseSnapshot() {
+	if i.snap != nil {
+		i.snap.release()
+		i.snap = nil
+	}
+}
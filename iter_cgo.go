@@ -0,0 +1,370 @@
+//go:build !purego
+// +build !purego
+
+package sparkey
+
+/*
+#include <stdlib.h>
+#include <sparkey/sparkey.h>
+
+// Looks up n (concatenated) keys and copies their values into buf, until it
+// is full. Sets vlens[i] to the length of the value, or -1 if the key does not
+// exist. Returns the number of keys processed i, if that's less than n,
+// vlens[i] contains the length of the value that didn't fit.
+static int sparkey_go_hash_getmulti(sparkey_hashreader *reader, sparkey_logiter *iter,
+		uint8_t *keys, uint64_t *klens, int n,
+		uint8_t *buf, uint64_t size, int64_t *vlens, sparkey_returncode *rc) {
+	sparkey_logreader *log = sparkey_hash_getreader(reader);
+	uint64_t used = 0, vlen, got;
+	int i;
+
+	for (i = 0; i < n; keys += klens[i], i++) {
+		*rc = sparkey_hash_get(reader, keys, klens[i], iter);
+		if (*rc != SPARKEY_SUCCESS) {
+			return i;
+		}
+		if (sparkey_logiter_state(iter) != SPARKEY_ITER_ACTIVE) {
+			vlens[i] = -1;
+			continue;
+		}
+
+		vlen = sparkey_logiter_valuelen(iter);
+		if (vlen > size - used) {
+			vlens[i] = vlen;
+			return i;
+		}
+		*rc = sparkey_logiter_fill_value(iter, log, vlen, buf + used, &got);
+		if (*rc != SPARKEY_SUCCESS) {
+			return i;
+		}
+		vlens[i] = got;
+		used += got;
+	}
+	return n;
+}
+*/
+import "C"
+import "unsafe"
+
+type logIterHandle = C.sparkey_logiter
+
+// Closes a log iterator.
+// This is a failsafe operation.
+func (i *LogIter) Close() {
+	if i.iter != nil {
+		C.sparkey_logiter_close(&i.iter)
+	}
+	i.iter = nil
+	i.releaseSnapshot()
+}
+
+// Skip skips a number of entries.
+// This is equivalent to calling Next count number of times.
+func (i *LogIter) Skip(count int) error {
+	if i.track && i.next != 0 {
+		// step through the entries to keep track of the offset
+		for ; count > 0; count-- {
+			if err := i.Next(); err != nil {
+				return err
+			} else if i.State() != ITERATOR_ACTIVE {
+				break
+			}
+		}
+		return nil
+	}
+
+	rc := C.sparkey_logiter_skip(i.iter, i.log, C.int(count))
+	if rc != rc_SUCCESS && rc != rc_ITERINACTIVE-205 {
+		i.err = Error(rc)
+	}
+	i.offset, i.next = 0, 0
+	return errorOrNil(rc)
+}
+
+// Next prepares the iterator to start reading from the next entry.
+// The value of State() will be:
+//   ITERATOR_CLOSED if the last entry has been passed.
+//   ITERATOR_INVALID if anything goes wrong.
+//   ITERATOR_ACTIVE if it successfully reached the next entry.
+func (i *LogIter) Next() error {
+	rc := C.sparkey_logiter_next(i.iter, i.log)
+	if rc != rc_SUCCESS && rc != rc_ITERINACTIVE {
+		i.err = Error(rc)
+	}
+	if i.track {
+		i.trackOffset(i.next)
+	} else {
+		i.offset, i.next = 0, 0
+	}
+	return errorOrNil(rc)
+}
+
+func (i *LogIter) clone() (*LogIter, error) {
+	if i.iter == nil {
+		return nil, ERROR_LOG_ITERATOR_CLOSED
+	}
+
+	// the position is only known for new iterators and tracked offsets
+	var pos uint64
+	switch state := i.State(); {
+	case state == ITERATOR_NEW:
+		pos = i.next
+	case state == ITERATOR_ACTIVE && i.offset != 0:
+		pos = i.offset
+	default:
+		return nil, ErrCloneUnsupported
+	}
+
+	clone := &LogIter{log: i.log, snap: i.snap.retain(), track: i.track}
+	rc := C.sparkey_logiter_create(&clone.iter, i.log)
+	if rc != rc_SUCCESS {
+		clone.releaseSnapshot()
+		return nil, Error(rc)
+	}
+	if pos != 0 {
+		if err := clone.seek(pos); err != nil {
+			clone.Close()
+			return nil, err
+		}
+	}
+	clone.trackOffsets(clone.track)
+	if i.State() == ITERATOR_ACTIVE {
+		if err := clone.Next(); err != nil {
+			clone.Close()
+			return nil, err
+		}
+	}
+	return clone, nil
+}
+
+// Offset returns the position of the current entry in the log file, see
+// SeekOffset. It returns 0 if the iterator is not positioned on an entry or
+// the log is compressed. libsparkey doesn't expose the position of
+// iterators, so it is only known while offsets are tracked, see
+// TrackOffsets, and not after a HashIter has been positioned by Seek or
+// NextLive.
+func (i *LogIter) Offset() uint64 {
+	if i.iter == nil {
+		return 0
+	}
+	return i.offset
+}
+
+// trackOffset records the offset of the entry the iterator has moved to,
+// 0 if unknown.
+func (i *LogIter) trackOffset(offset uint64) {
+	i.offset, i.next = 0, 0
+	if offset != 0 && i.State() == ITERATOR_ACTIVE {
+		i.offset, i.next = offset, offset+i.entrySize()
+	}
+}
+
+// trackOffsets enables or disables offset tracking. New iterators start
+// at the end of the log header.
+func (i *LogIter) trackOffsets(enable bool) {
+	i.track = enable
+	if enable && i.next == 0 && i.State() == ITERATOR_NEW && i.compression() == COMPRESSION_NONE {
+		i.next = logHeaderSize
+	}
+}
+
+func (i *LogIter) compression() CompressionType {
+	return CompressionType(C.sparkey_logreader_get_compression_type(i.log))
+}
+
+// seek positions the iterator at the start of the entry at the given
+// offset. The next call to Next will move the iterator to that entry.
+func (i *LogIter) seek(offset uint64) error {
+	rc := C.sparkey_logiter_seek(i.iter, i.log, C.uint64_t(offset))
+	i.offset, i.next = 0, 0
+	if rc == rc_SUCCESS && i.compression() == COMPRESSION_NONE {
+		i.next = offset
+	}
+	return errorOrNil(rc)
+}
+
+// Reset resets the iterator to the start of the current entry. This is only valid if
+// state is ITERATOR_ACTIVE.
+func (i *LogIter) Reset() error {
+	rc := C.sparkey_logiter_reset(i.iter, i.log)
+	return errorOrNil(rc)
+}
+
+// State gets the state for an iterator.
+func (i *LogIter) State() IteratorState {
+	return IteratorState(C.sparkey_logiter_state(i.iter))
+}
+
+// EntryType returns the type of the current entry.
+func (i *LogIter) EntryType() EntryType {
+	return EntryType(C.sparkey_logiter_type(i.iter))
+}
+
+// KeyLen returns the key length of the current entry.
+func (i *LogIter) KeyLen() uint64 {
+	return uint64(C.sparkey_logiter_keylen(i.iter))
+}
+
+// ValueLen returns the value length of the current entry.
+func (i *LogIter) ValueLen() uint64 {
+	return uint64(C.sparkey_logiter_valuelen(i.iter))
+}
+
+// Compare compares the keys of two iterators pointing to the same log.
+// It assumes that the iterators are both clean, i.e. nothing has been consumed from the current entry.
+// It will return zero if the keys are equal, negative if key1 is smaller than key2 and positive if key1 is larger than key2.
+func (i *LogIter) Compare(other *LogIter) (int, error) {
+	var res C.int
+	rc := C.sparkey_logiter_keycmp(i.iter, other.iter, i.log, &res)
+	if rc != rc_SUCCESS {
+		return 0, Error(rc)
+	}
+	return int(res), nil
+}
+
+func (i *LogIter) fillKey(b []byte) (int, error) {
+	var size C.uint64_t
+	rc := C.sparkey_logiter_fill_key(i.iter, i.log, C.uint64_t(len(b)), (*C.uint8_t)(&b[0]), &size)
+	return int(size), errorOrNil(rc)
+}
+
+func (i *LogIter) fillValue(b []byte) (int, error) {
+	var size C.uint64_t
+	rc := C.sparkey_logiter_fill_value(i.iter, i.log, C.uint64_t(len(b)), (*C.uint8_t)(&b[0]), &size)
+	return int(size), errorOrNil(rc)
+}
+
+func (i *LogIter) keyChunk(max uint64) ([]byte, error) {
+	var size C.uint64_t
+	var ptr *C.uint8_t
+	rc := C.sparkey_logiter_keychunk(i.iter, i.log, C.uint64_t(max), &ptr, &size)
+	if rc != rc_SUCCESS {
+		return nil, Error(rc)
+	}
+	return C.GoBytes(unsafe.Pointer(ptr), C.int(size)), nil
+}
+
+func (i *LogIter) valueChunk(max uint64) ([]byte, error) {
+	var size C.uint64_t
+	var ptr *C.uint8_t
+	rc := C.sparkey_logiter_valuechunk(i.iter, i.log, C.uint64_t(max), &ptr, &size)
+	if rc != rc_SUCCESS {
+		return nil, Error(rc)
+	}
+	return C.GoBytes(unsafe.Pointer(ptr), C.int(size)), nil
+}
+
+// maxUnsafeChunk limits the size of chunks which alias C memory.
+const maxUnsafeChunk = 1 << 30
+
+func (i *LogIter) keyChunkUnsafe(max uint64) ([]byte, error) {
+	if max > maxUnsafeChunk {
+		max = maxUnsafeChunk
+	}
+
+	var size C.uint64_t
+	var ptr *C.uint8_t
+	rc := C.sparkey_logiter_keychunk(i.iter, i.log, C.uint64_t(max), &ptr, &size)
+	if rc != rc_SUCCESS {
+		return nil, Error(rc)
+	}
+	return aliasBytes(ptr, size), nil
+}
+
+func (i *LogIter) valueChunkUnsafe(max uint64) ([]byte, error) {
+	if max > maxUnsafeChunk {
+		max = maxUnsafeChunk
+	}
+
+	var size C.uint64_t
+	var ptr *C.uint8_t
+	rc := C.sparkey_logiter_valuechunk(i.iter, i.log, C.uint64_t(max), &ptr, &size)
+	if rc != rc_SUCCESS {
+		return nil, Error(rc)
+	}
+	return aliasBytes(ptr, size), nil
+}
+
+// aliasBytes returns a slice which aliases size bytes of C memory at ptr.
+func aliasBytes(ptr *C.uint8_t, size C.uint64_t) []byte {
+	if size == 0 {
+		return []byte{}
+	}
+	return (*[maxUnsafeChunk]byte)(unsafe.Pointer(ptr))[:size:size]
+}
+
+/* Hash iterator */
+
+// Seek positions the cursor on the given key.
+// Sets the iterator state to ITERATOR_INVALID when key cannot be found.
+func (i *HashIter) Seek(key []byte) error {
+	var k *C.uint8_t
+
+	lk := len(key)
+	if lk > 0 {
+		k = (*C.uint8_t)(&key[0])
+	}
+	rc := C.sparkey_hash_get(i.reader.hash, k, C.uint64_t(lk), i.iter)
+	i.offset, i.next = 0, 0
+	return errorOrNil(rc)
+}
+
+// NextLive positions the cursor at the next non-deleted "live" key
+func (i *HashIter) NextLive() error {
+	rc := C.sparkey_logiter_hashnext(i.iter, i.reader.hash)
+	if rc != rc_SUCCESS && rc != rc_ITERINACTIVE {
+		i.err = Error(rc)
+	}
+	i.offset, i.next = 0, 0
+	return errorOrNil(rc)
+}
+
+// getMulti looks up keys in batches, the values of each batch are
+// copied into a single buffer with one call into libsparkey.
+func (i *HashIter) getMulti(keys [][]byte, fn func(int, []byte) error) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var flat []byte
+	klens := make([]C.uint64_t, len(keys))
+	for n, key := range keys {
+		flat = append(flat, key...)
+		klens[n] = C.uint64_t(len(key))
+	}
+	vlens := make([]C.int64_t, len(keys))
+
+	size := getMultiBufferSize
+	for start, koff := 0, 0; start < len(keys); {
+		var kptr *C.uint8_t
+		if koff < len(flat) {
+			kptr = (*C.uint8_t)(&flat[koff])
+		}
+
+		var rc C.sparkey_returncode = rc_SUCCESS
+		buf := make([]byte, size)
+		n := int(C.sparkey_go_hash_getmulti(i.reader.hash, i.iter, kptr, &klens[start], C.int(len(keys)-start),
+			(*C.uint8_t)(&buf[0]), C.uint64_t(len(buf)), &vlens[start], &rc))
+		if rc != rc_SUCCESS {
+			return Error(rc)
+		}
+
+		voff := 0
+		for end := start + n; start < end; start++ {
+			var val []byte
+			if vlen := int(vlens[start]); vlen > -1 {
+				val = buf[voff : voff+vlen : voff+vlen]
+				voff += vlen
+			}
+			if err := fn(start, val); err != nil {
+				return err
+			}
+			koff += int(klens[start])
+		}
+
+		if start < len(keys) && vlens[start] > C.int64_t(size) {
+			size = int(vlens[start])
+		}
+	}
+	return nil
+}
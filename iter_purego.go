@@ -0,0 +1,194 @@
+//go:build purego
+// +build purego
+
+package sparkey
+
+type logIterHandle = logCursor
+
+// Closes a log iterator.
+// This is a failsafe operation.
+func (i *LogIter) Close() {
+	i.iter = nil
+	i.releaseSnapshot()
+}
+
+// Skip skips a number of entries.
+// This is equivalent to calling Next count number of times.
+func (i *LogIter) Skip(count int) error {
+	err := i.iter.skip(count)
+	if err != nil {
+		i.err = err
+	}
+	return err
+}
+
+// Next prepares the iterator to start reading from the next entry.
+// The value of State() will be:
+//   ITERATOR_CLOSED if the last entry has been passed.
+//   ITERATOR_INVALID if anything goes wrong.
+//   ITERATOR_ACTIVE if it successfully reached the next entry.
+func (i *LogIter) Next() error {
+	err := i.iter.next()
+	if err != nil {
+		i.err = err
+	}
+	return err
+}
+
+// seek positions the iterator at the start of the entry at the given
+// offset. The next call to Next will move the iterator to that entry.
+func (i *LogIter) seek(offset uint64) error {
+	return i.iter.seek(offset)
+}
+
+func (i *LogIter) clone() (*LogIter, error) {
+	if i.iter == nil {
+		return nil, ERROR_LOG_ITERATOR_CLOSED
+	}
+
+	c := *i.iter
+	if c.state == ITERATOR_ACTIVE {
+		if err := c.reset(); err != nil {
+			return nil, err
+		}
+	}
+	return &LogIter{iter: &c, log: i.log, snap: i.snap.retain()}, nil
+}
+
+// Offset returns the position of the current entry in the log file, see
+// SeekOffset. It returns 0 if the iterator is not positioned on an entry or
+// the log is compressed.
+func (i *LogIter) Offset() uint64 {
+	if i.State() != ITERATOR_ACTIVE || i.compression() != COMPRESSION_NONE {
+		return 0
+	}
+	return i.iter.log.filePos(i.iter.entry)
+}
+
+// trackOffsets only records the setting, positions are always known.
+func (i *LogIter) trackOffsets(enable bool) { i.track = enable }
+
+func (i *LogIter) compression() CompressionType {
+	if i.iter == nil {
+		return COMPRESSION_NONE
+	}
+	return i.iter.log.header.Compression
+}
+
+// Reset resets the iterator to the start of the current entry. This is only valid if
+// state is ITERATOR_ACTIVE.
+func (i *LogIter) Reset() error {
+	return i.iter.reset()
+}
+
+// State gets the state for an iterator.
+func (i *LogIter) State() IteratorState {
+	if i.iter == nil {
+		return ITERATOR_CLOSED
+	}
+	return i.iter.state
+}
+
+// EntryType returns the type of the current entry.
+func (i *LogIter) EntryType() EntryType {
+	if i.iter == nil {
+		return ENTRY_DELETE
+	}
+	return i.iter.typ
+}
+
+// KeyLen returns the key length of the current entry.
+func (i *LogIter) KeyLen() uint64 {
+	if i.iter == nil {
+		return 0
+	}
+	return i.iter.keyLen
+}
+
+// ValueLen returns the value length of the current entry.
+func (i *LogIter) ValueLen() uint64 {
+	if i.iter == nil {
+		return 0
+	}
+	return i.iter.valueLen
+}
+
+// Compare compares the keys of two iterators pointing to the same log.
+// It assumes that the iterators are both clean, i.e. nothing has been consumed from the current entry.
+// It will return zero if the keys are equal, negative if key1 is smaller than key2 and positive if key1 is larger than key2.
+func (i *LogIter) Compare(other *LogIter) (int, error) {
+	if err := other.iter.checkActive(); err != nil {
+		return 0, err
+	} else if other.iter.log != i.iter.log {
+		return 0, ERROR_LOG_ITERATOR_MISMATCH
+	}
+
+	key, err := other.iter.appendKey(nil)
+	if err != nil {
+		return 0, err
+	}
+	return i.iter.compareKey(key)
+}
+
+func (i *LogIter) fillKey(b []byte) (int, error) {
+	return fillChunks(b, i.iter.keyChunk)
+}
+
+func (i *LogIter) fillValue(b []byte) (int, error) {
+	return fillChunks(b, i.iter.valueChunk)
+}
+
+func (i *LogIter) keyChunk(max uint64) ([]byte, error) {
+	return i.iter.keyChunk(max)
+}
+
+func (i *LogIter) valueChunk(max uint64) ([]byte, error) {
+	return i.iter.valueChunk(max)
+}
+
+// keyChunkUnsafe is keyChunk, chunks of native cursors are never copied.
+func (i *LogIter) keyChunkUnsafe(max uint64) ([]byte, error) {
+	return i.iter.keyChunk(max)
+}
+
+// valueChunkUnsafe is valueChunk, chunks of native cursors are never copied.
+func (i *LogIter) valueChunkUnsafe(max uint64) ([]byte, error) {
+	return i.iter.valueChunk(max)
+}
+
+/* Hash iterator */
+
+// Seek positions the cursor on the given key.
+// Sets the iterator state to ITERATOR_INVALID when key cannot be found.
+func (i *HashIter) Seek(key []byte) error {
+	if i.reader.hash == nil {
+		return ERROR_HASH_CLOSED
+	}
+	return i.reader.hash.get(key, i.iter)
+}
+
+// NextLive positions the cursor at the next non-deleted "live" key
+func (i *HashIter) NextLive() error {
+	if i.reader.hash == nil {
+		return ERROR_HASH_CLOSED
+	}
+	err := i.reader.hash.nextLive(i.iter)
+	if err != nil {
+		i.err = err
+	}
+	return err
+}
+
+// getMulti looks up keys sequentially.
+func (i *HashIter) getMulti(keys [][]byte, fn func(int, []byte) error) error {
+	for n, key := range keys {
+		val, err := i.Get(key)
+		if err != nil {
+			return err
+		}
+		if err := fn(n, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package sparkey
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -65,6 +69,164 @@ var _ = Describe("LogIter", func() {
 		Expect(string(key)).To(Equal("yk"))
 	})
 
+	It("should peek at keys and values", func() {
+		_, err := subject.PeekKey()
+		Expect(err).To(HaveOccurred())
+
+		Expect(subject.Next()).To(Succeed())
+		Expect(subject.PeekKey()).To(Equal([]byte("xk")))
+		Expect(subject.PeekValue()).To(Equal([]byte("short")))
+		Expect(subject.KeyChunkUnsafe(1)).To(Equal([]byte("x")))
+		Expect(subject.PeekKey()).To(Equal([]byte("xk")))
+		Expect(kv()).To(Equal("xk:short"))
+	})
+
+	It("should reset automatically", func() {
+		subject.AutoReset(true)
+		Expect(subject.Next()).To(Succeed())
+		Expect(subject.Key()).To(Equal([]byte("xk")))
+		Expect(subject.Key()).To(Equal([]byte("xk")))
+		Expect(subject.Value()).To(Equal([]byte("short")))
+		Expect(subject.Value()).To(Equal([]byte("short")))
+		Expect(subject.Entry()).To(Equal(Entry{Type: ENTRY_PUT, Key: []byte("xk"), Value: []byte("short"), Offset: logHeaderSize, KeyLen: 2, ValueLen: 5}))
+
+		subject.AutoReset(false)
+		Expect(subject.Key()).To(Equal([]byte("xk")))
+		Expect(subject.Key()).To(Equal([]byte{}))
+	})
+
+	It("should append keys and values to buffers", func() {
+		_, err := subject.KeyInto(nil)
+		Expect(err).To(Equal(ERROR_LOG_ITERATOR_INACTIVE))
+
+		Expect(subject.Next()).To(Succeed())
+		buf, err := subject.KeyInto(make([]byte, 0, 64))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(buf)).To(Equal("xk"))
+		Expect(cap(buf)).To(Equal(64))
+
+		buf, err = subject.ValueInto(append(buf, ':'))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(buf)).To(Equal("xk:short"))
+		Expect(cap(buf)).To(Equal(64))
+
+		Expect(subject.Skip(2)).To(Succeed())
+		buf, err = subject.ValueInto(buf[:0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(buf)).To(Equal(veryLongString))
+
+		Expect(subject.Next()).To(Succeed())
+		buf, err = subject.ValueInto(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf).To(Equal([]byte{}))
+	})
+
+	It("should read values at offsets", func() {
+		_, _, err := subject.ValueReaderAt()
+		Expect(err).To(Equal(ERROR_LOG_ITERATOR_INACTIVE))
+
+		Expect(subject.Skip(3)).To(Succeed())
+		r, n, err := subject.ValueReaderAt()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(len(veryLongString))))
+
+		b := make([]byte, 6)
+		Expect(r.ReadAt(b, 2)).To(Equal(6))
+		Expect(string(b)).To(Equal("ahblah"))
+
+		m, err := r.ReadAt(b, n-4)
+		Expect(m).To(Equal(4))
+		Expect(err).To(Equal(io.EOF))
+		Expect(string(b[:m])).To(Equal("blah"))
+
+		_, err = r.ReadAt(b, -1)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should not read values of compressed logs at offsets", func() {
+		fname := filepath.Join(testDir, "compressed")
+		w, err := NewLogWriter(fname, WithCompression(COMPRESSION_SNAPPY))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Put([]byte("k"), []byte("v"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		log, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer log.Close()
+		iter, err := log.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+
+		Expect(iter.Next()).To(Succeed())
+		_, _, err = iter.ValueReaderAt()
+		Expect(err).To(Equal(ErrOffsetUnsupported))
+	})
+
+	It("should clone", func() {
+		subject.TrackOffsets(true)
+		clone, err := subject.Clone()
+		Expect(err).NotTo(HaveOccurred())
+		defer clone.Close()
+		Expect(clone.State()).To(Equal(ITERATOR_NEW))
+
+		Expect(subject.Skip(2)).To(Succeed())
+		Expect(subject.KeyChunkUnsafe(1)).To(Equal([]byte("y")))
+
+		ahead, err := subject.Clone()
+		Expect(err).NotTo(HaveOccurred())
+		defer ahead.Close()
+		Expect(clone.Next()).To(Succeed())
+		Expect(ahead.Compare(clone)).To(BeNumerically(">", 0))
+		Expect(kv()).To(Equal("k:longvalue"))
+		Expect(clone.Key()).To(Equal([]byte("xk")))
+
+		Expect(ahead.Key()).To(Equal([]byte("yk")))
+		Expect(ahead.Next()).To(Succeed())
+		Expect(ahead.Key()).To(Equal([]byte("zk")))
+		Expect(subject.Next()).To(Succeed())
+		Expect(subject.Key()).To(Equal([]byte("zk")))
+
+		subject.Close()
+		_, err = subject.Clone()
+		Expect(err).To(Equal(ERROR_LOG_ITERATOR_CLOSED))
+	})
+
+	It("should only clone active iterators with known offsets", func() {
+		Expect(subject.Skip(2)).To(Succeed())
+		clone, err := subject.Clone()
+		if err == ErrCloneUnsupported {
+			Expect(subject.Offset()).To(BeZero())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		defer clone.Close()
+		Expect(clone.Key()).To(Equal([]byte("yk")))
+	})
+
+	It("should retrieve chunks without copying", func() {
+		Expect(subject.Skip(3)).To(Succeed())
+
+		key, err := subject.KeyChunkUnsafe(1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(key)).To(Equal("z"))
+
+		var val []byte
+		for {
+			chunk, err := subject.ValueChunkUnsafe(100)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(chunk)).To(BeNumerically("<=", 100))
+			if len(chunk) == 0 {
+				break
+			}
+			val = append(val, chunk...)
+		}
+		Expect(string(val)).To(Equal(veryLongString))
+
+		key, err = subject.KeyChunkUnsafe(10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(BeEmpty())
+	})
+
 	It("should retrieve keys using the io.Reader interface", func() {
 		b := make([]byte, 5)
 		r := subject.KeyReader()
@@ -228,6 +390,57 @@ var _ = Describe("LogIter", func() {
 		Expect(buf.String()).To(Equal(""))
 	})
 
+	It("should return entries", func() {
+		_, err := subject.Entry()
+		Expect(err).To(Equal(ERROR_LOG_ITERATOR_INACTIVE))
+
+		var entries []Entry
+		for subject.Next(); subject.Valid(); subject.Next() {
+			entry, err := subject.Entry()
+			Expect(err).NotTo(HaveOccurred())
+			entries = append(entries, entry)
+		}
+		Expect(entries).To(Equal([]Entry{
+			{Type: ENTRY_PUT, Key: []byte("xk"), Value: []byte("short"), Offset: logHeaderSize, KeyLen: 2, ValueLen: 5},
+			{Type: ENTRY_PUT, Key: []byte("yk"), Value: []byte("longvalue"), Offset: logHeaderSize + 9, KeyLen: 2, ValueLen: 9},
+			{Type: ENTRY_PUT, Key: []byte("zk"), Value: []byte(veryLongString), Offset: logHeaderSize + 22, KeyLen: 2, ValueLen: 8000},
+			{Type: ENTRY_DELETE, Key: []byte("yk"), Offset: logHeaderSize + 8027, KeyLen: 2},
+		}))
+	})
+
+	It("should respect contexts", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		Expect(subject.NextContext(ctx)).To(Succeed())
+		Expect(kv()).To(Equal("xk:short"))
+
+		cancel()
+		Expect(subject.NextContext(ctx)).To(Equal(context.Canceled))
+		Expect(subject.State()).To(Equal(ITERATOR_ACTIVE))
+	})
+
+	It("should spill values to files", func() {
+		_, err := subject.ValueToFile(testDir)
+		Expect(err).To(Equal(ERROR_LOG_ITERATOR_INACTIVE))
+
+		Expect(subject.Next()).To(Succeed())
+		val, file, err := subject.ValueOrFile(100, testDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file).To(BeNil())
+		Expect(string(val)).To(Equal("short"))
+
+		Expect(subject.Skip(2)).To(Succeed())
+		val, file, err = subject.ValueOrFile(100, testDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(BeNil())
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		Expect(filepath.Dir(file.Name())).To(Equal(testDir))
+		data, err := ioutil.ReadAll(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(veryLongString))
+	})
+
 	It("should navigate", func() {
 		// Next
 		Expect(subject.Next()).NotTo(HaveOccurred())
@@ -383,4 +596,50 @@ var _ = Describe("HashIter", func() {
 		Expect(val).To(BeNil())
 	})
 
+	It("should retrieve values into buffers", func() {
+		buf := make([]byte, 0, 64)
+		val, err := subject.GetInto([]byte("yk"), buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(BeNil())
+
+		val, err = subject.GetInto([]byte("xk"), buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(val)).To(Equal("short"))
+		Expect(&val[0]).To(Equal(&buf[:1][0]))
+	})
+
+	It("should retrieve value readers", func() {
+		r, n, err := subject.GetReader([]byte("yk"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r).To(BeNil())
+		Expect(n).To(Equal(int64(0)))
+
+		r, n, err = subject.GetReader([]byte("zk"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(len(veryLongString))))
+
+		buf := new(bytes.Buffer)
+		Expect(io.Copy(buf, r)).To(Equal(n))
+		Expect(buf.String()).To(Equal(veryLongString))
+	})
+
+	It("should clone", func() {
+		clone, err := subject.Clone()
+		Expect(err).NotTo(HaveOccurred())
+		defer clone.Close()
+
+		Expect(clone.NextLive()).To(Succeed())
+		Expect(clone.Key()).To(Equal([]byte("xk")))
+		Expect(clone.Get([]byte("zk"))).To(Equal([]byte(veryLongString)))
+		Expect(subject.State()).To(Equal(ITERATOR_NEW))
+	})
+
+	It("should check existence", func() {
+		Expect(subject.Exists([]byte("missing"))).To(BeFalse())
+		Expect(subject.Exists([]byte("yk"))).To(BeFalse())
+		Expect(subject.Exists([]byte("zk"))).To(BeTrue())
+		Expect(subject.State()).To(Equal(ITERATOR_ACTIVE))
+		Expect(subject.Exists([]byte("xk"))).To(BeTrue())
+	})
+
 })
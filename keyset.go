@@ -0,0 +1,143 @@
+package sparkey
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// ErrKeySetCorrupt is returned by ReadKeySet when the data is malformed.
+var ErrKeySetCorrupt = errors.New("sparkey: key set is corrupt")
+
+const keySetMagic = "SPKS\x01"
+
+// ExportKeySet writes the set of live keys of a reader to w, in a compact
+// form that can be used to test for membership without shipping the values,
+// e.g. for anti-joins, see ReadKeySet.
+//
+// The set consists of the 64-bit hashes of the keys, as used by
+// HASH_SIZE_64BIT hash files with a seed of 0. It starts with the magic
+// "SPKS\x01", followed by the number of hashes and the sorted hashes as
+// deltas to their predecessor, all encoded as uvarints.
+func ExportKeySet(reader *HashReader, w io.Writer) error {
+	iter, err := reader.Iterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	hashes := make([]uint64, 0, reader.Len())
+	var key []byte
+	for {
+		if err := iter.NextLive(); err != nil {
+			return err
+		} else if !iter.Valid() {
+			break
+		}
+
+		if key, err = iter.Key(); err != nil {
+			return err
+		}
+		hashes = append(hashes, keySetHash(key))
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	// collisions are stored once
+	n := 0
+	for i, h := range hashes {
+		if i == 0 || h != hashes[n-1] {
+			hashes[n] = h
+			n++
+		}
+	}
+	hashes = hashes[:n]
+
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, binary.MaxVarintLen64)
+	if _, err := bw.WriteString(keySetMagic); err != nil {
+		return err
+	}
+	if _, err := bw.Write(buf[:binary.PutUvarint(buf, uint64(len(hashes)))]); err != nil {
+		return err
+	}
+
+	var prev uint64
+	for _, h := range hashes {
+		if _, err := bw.Write(buf[:binary.PutUvarint(buf, h-prev)]); err != nil {
+			return err
+		}
+		prev = h
+	}
+	return bw.Flush()
+}
+
+// KeySet is a set of keys, read by ReadKeySet. Since keys are represented
+// by 64-bit hashes, Contains may report false positives, with a probability
+// of roughly Len/2^64.
+type KeySet struct {
+	hashes []uint64 // sorted
+}
+
+// ReadKeySet reads a key set written by ExportKeySet.
+func ReadKeySet(r io.Reader) (*KeySet, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(keySetMagic))
+	if _, err := io.ReadFull(br, magic); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrKeySetCorrupt
+	} else if err != nil {
+		return nil, err
+	} else if string(magic) != keySetMagic {
+		return nil, ErrKeySetCorrupt
+	}
+
+	count, err := readKeySetUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// don't trust the count for allocations
+	capacity := count
+	if capacity > 1<<20 {
+		capacity = 1 << 20
+	}
+
+	set := &KeySet{hashes: make([]uint64, 0, capacity)}
+	var prev uint64
+	for i := uint64(0); i < count; i++ {
+		delta, err := readKeySetUvarint(br)
+		if err != nil {
+			return nil, err
+		} else if i > 0 && (delta == 0 || prev+delta < prev) {
+			return nil, ErrKeySetCorrupt
+		}
+
+		prev += delta
+		set.hashes = append(set.hashes, prev)
+	}
+	return set, nil
+}
+
+func readKeySetUvarint(r *bufio.Reader) (uint64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, ErrKeySetCorrupt
+	}
+	return v, err
+}
+
+// Len returns the number of keys in the set.
+func (s *KeySet) Len() int { return len(s.hashes) }
+
+// Contains returns true if the set (probably) contains key.
+func (s *KeySet) Contains(key []byte) bool {
+	h := keySetHash(key)
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= h })
+	return i < len(s.hashes) && s.hashes[i] == h
+}
+
+func keySetHash(key []byte) uint64 {
+	return murmur64(key, 0)
+}
@@ -0,0 +1,51 @@
+package sparkey
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeySet", func() {
+	var reader *HashReader
+
+	BeforeEach(func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		reader, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		reader.Close()
+	})
+
+	It("should export and read key sets", func() {
+		buf := new(bytes.Buffer)
+		Expect(ExportKeySet(reader, buf)).To(Succeed())
+		Expect(buf.String()).To(HavePrefix("SPKS\x01\x02"))
+
+		set, err := ReadKeySet(buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(set.Len()).To(Equal(2))
+		Expect(set.Contains([]byte("xk"))).To(BeTrue())
+		Expect(set.Contains([]byte("yk"))).To(BeFalse())
+		Expect(set.Contains([]byte("zk"))).To(BeTrue())
+		Expect(set.Contains([]byte("missing"))).To(BeFalse())
+	})
+
+	It("should reject corrupt key sets", func() {
+		buf := new(bytes.Buffer)
+		Expect(ExportKeySet(reader, buf)).To(Succeed())
+		data := buf.Bytes()
+
+		_, err := ReadKeySet(bytes.NewReader(data[:len(data)-1]))
+		Expect(err).To(Equal(ErrKeySetCorrupt))
+		_, err = ReadKeySet(bytes.NewReader([]byte("SPKX\x01\x00")))
+		Expect(err).To(Equal(ErrKeySetCorrupt))
+		_, err = ReadKeySet(bytes.NewReader([]byte("SPKS\x01\x02\x01\x00")))
+		Expect(err).To(Equal(ErrKeySetCorrupt))
+	})
+
+})
@@ -1,117 +1,394 @@
 package sparkey
 
-//#cgo LDFLAGS: -lsparkey
-//#include <stdlib.h>
-//#include <sparkey/sparkey.h>
-import "C"
-import "unsafe"
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInvalidEntryType is returned by PutBatch for entries with an unknown type
+var ErrInvalidEntryType = errors.New("sparkey: invalid entry type")
+
+// ErrKeyTooLong is returned by writers for keys that exceed the configured
+// maximum key length, see WithMaxKeyLen.
+var ErrKeyTooLong = errors.New("sparkey: key too long")
+
+// ErrCompressionLevelUnsupported is returned by writers of the default cgo
+// build when a compression level is set, libsparkey always compresses at its
+// default level.
+var ErrCompressionLevelUnsupported = errors.New("sparkey: compression levels are not supported by libsparkey")
 
 /* LogWriter */
 
+// Entry is a put or delete operation. Writers accept entries in PutBatch,
+// iterators and readers return them with KeyLen and ValueLen set, and
+// Offset where it is known.
+type Entry struct {
+	Type   EntryType
+	Key    []byte
+	Value  []byte // ignored by deletes
+	Offset uint64 // position in the log file when read, see LogIter.Offset, ignored by writers
+
+	// Lengths of the key and value in the log when read, ignored by writers
+	KeyLen, ValueLen uint64
+}
+
 type LogWriter struct {
-	name string
-	log  *C.sparkey_logwriter
+	name      string
+	log       *logWriterHandle
+	sync      bool
+	maxKeyLen uint64
+	dups      *duplicateFilter // see WithDuplicatePolicy
+	stats     LogWriterStats   // entry counts, maintained by cgo builds only
+}
+
+// LogWriterStats are returned by LogWriter.Stats. They cover the whole log,
+// including entries written before it was opened for appending.
+type LogWriterStats struct {
+	Puts    uint64
+	Deletes uint64
+	// Size of all entries, before compression
+	RawBytes uint64
+	// Size of the entry data in the file, after compression. Data of the
+	// current, incomplete compression block is not included.
+	CompressedBytes uint64
+	// Size of the file, including the header
+	FileSize uint64
+	// Length of the longest key in the log
+	MaxKeyLen uint64
+}
+
+// CompressionRatio returns the ratio of raw to compressed bytes, it
+// returns 1 for empty logs.
+func (s LogWriterStats) CompressionRatio() float64 {
+	if s.CompressedBytes == 0 {
+		return 1
+	}
+	return float64(s.RawBytes) / float64(s.CompressedBytes)
 }
 
 // CreateLogWriter creates a new Sparkey log file, possibly overwriting an already existing.
 func CreateLogWriter(fname string, opts *Options) (*LogWriter, error) {
-	writer := LogWriter{name: LogFileName(fname)}
-	blockSize := C.int(opts.GetCompressionBlockSize())
-	compression := C.sparkey_compression_type(opts.GetCompression())
-	filename := C.CString(writer.name)
-	defer C.free(unsafe.Pointer(filename))
+	return NewLogWriter(fname,
+		WithCompression(opts.GetCompression()),
+		WithBlockSize(opts.GetCompressionBlockSize()),
+		WithCompressionLevel(opts.GetCompressionLevel()),
+	)
+}
+
+// NewLogWriter creates a new Sparkey log file, possibly overwriting an already existing.
+// Supported options are WithCompression, WithBlockSize, WithCompressionLevel,
+// WithMaxKeyLen, WithSyncOnFlush, WithDuplicatePolicy and WithMergeFunc.
+func NewLogWriter(fname string, opts ...Option) (*LogWriter, error) {
+	conf := newConfig(opts)
+	if err := conf.check(logWriterOptions); err != nil {
+		return nil, err
+	}
+	return newLogWriter(fname, conf)
+}
 
-	rc := C.sparkey_logwriter_create(&writer.log, filename, compression, blockSize)
-	if rc == rc_SUCCESS {
-		return &writer, nil
+// newLogWriter creates a log writer with a checked configuration.
+func newLogWriter(fname string, conf *config) (*LogWriter, error) {
+	writer := LogWriter{name: LogFileName(fname), sync: conf.syncOnFlush, maxKeyLen: conf.maxKeyLen, dups: newDuplicateFilter(conf)}
+	log, err := createLogWriter(writer.name, conf.GetCompression(), conf.GetCompressionBlockSize(), conf.GetCompressionLevel())
+	if err != nil {
+		return nil, err
 	}
-	return nil, Error(rc)
+	writer.log = log
+	return &writer, nil
 }
 
 // OpenLogWriter opens an existing Sparkey log file.
 func OpenLogWriter(fname string) (*LogWriter, error) {
-	writer := LogWriter{name: LogFileName(fname)}
-	filename := C.CString(writer.name)
-	defer C.free(unsafe.Pointer(filename))
+	return AppendLogWriter(fname)
+}
 
-	rc := C.sparkey_logwriter_append(&writer.log, filename)
-	if rc == rc_SUCCESS {
-		return &writer, nil
+// AppendLogWriter opens an existing Sparkey log file for appending.
+// Supported options are WithMaxKeyLen, WithSyncOnFlush, WithDuplicatePolicy
+// and WithMergeFunc. Duplicates are only detected among the keys put
+// through the returned writer.
+func AppendLogWriter(fname string, opts ...Option) (*LogWriter, error) {
+	conf := newConfig(opts)
+	if err := conf.check(appendWriterOptions); err != nil {
+		return nil, err
 	}
-	return nil, Error(rc)
+	return appendToLog(fname, conf)
+}
+
+// appendToLog opens a log writer with a checked configuration.
+func appendToLog(fname string, conf *config) (*LogWriter, error) {
+	writer := LogWriter{name: LogFileName(fname), sync: conf.syncOnFlush, maxKeyLen: conf.maxKeyLen, dups: newDuplicateFilter(conf)}
+	err := retryOpen("append", writer.name, func() (err error) {
+		writer.log, err = appendLogWriter(writer.name)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.loadStats(); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	return &writer, nil
+}
+
+// AppendAndReindex opens an existing log for appending, calls fn to append
+// entries and updates the hash file afterwards. The existing hash is
+// extended with the new entries rather than rebuilt, so the cost is
+// proportional to the number of appended entries. A full rebuild is only
+// performed if the hash is missing or incompatible, e.g. because a
+// different WithHashSize or WithHashSeed is requested.
+//
+// If fn returns an error, the writer is closed and the hash is left
+// untouched. Supported options are those of AppendLogWriter and
+// BuildHashFile.
+func AppendAndReindex(fname string, fn func(*LogWriter) error, opts ...Option) error {
+	conf := newConfig(opts)
+	if err := conf.check(appendWriterOptions | hashOptions); err != nil {
+		return err
+	}
+
+	writer, err := appendToLog(fname, conf)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(writer); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return buildHash(context.Background(), fname, conf)
 }
 
 // Name returns the associated file name
 func (w *LogWriter) Name() string { return w.name }
 
-// Put appends a key/value pair to the log file
-func (w *LogWriter) Put(key, value []byte) error {
-	var ck, cv *C.uint8_t
-	lk, lv := len(key), len(value)
+// WriteHashFile will (re-)write a hashfile for the current log file
+func (w *LogWriter) WriteHashFile(size HashSize) error {
+	w.Flush() // Try to flush
+	return WriteHashFile(w.name, size)
+}
 
-	if lk > 0 {
-		ck = (*C.uint8_t)(&key[0])
+// Flush flushes any open compression block to file buffer.
+// Writers created WithSyncOnFlush also sync the file to disk.
+func (w *LogWriter) Flush() error {
+	if err := w.flush(); err != nil {
+		return err
 	}
-	if lv > 0 {
-		cv = (*C.uint8_t)(&value[0])
+	if w.sync {
+		return w.syncFile()
 	}
+	return nil
+}
 
-	rc := C.sparkey_logwriter_put(w.log, C.uint64_t(lk), ck, C.uint64_t(lv), cv)
-	return errorOrNil(rc)
+// Put appends a key/value pair to the log file
+func (w *LogWriter) Put(key, value []byte) error {
+	if err := w.checkKey(key); err != nil {
+		return err
+	} else if w.dups != nil {
+		return w.putFiltered(key, value)
+	}
+	return w.put(key, value)
 }
 
 // Delete appends a delete operation for a key to the log file
 func (w *LogWriter) Delete(key []byte) error {
-	var k *C.uint8_t
-	if len(key) != 0 {
-		k = (*C.uint8_t)(&key[0])
+	if err := w.checkKey(key); err != nil {
+		return err
+	} else if err := w.delete(key); err != nil {
+		return err
+	}
+	if w.dups != nil {
+		w.dups.delete(key)
 	}
+	return nil
+}
 
-	rc := C.sparkey_logwriter_delete(w.log, C.uint64_t(len(key)), k)
-	return errorOrNil(rc)
+// PutReader appends a key/value pair to the log file, reading exactly size
+// bytes of the value from r. This allows large values to be written without
+// buffering them in memory first. Pass a negative size if the length of the
+// value is unknown, the value is then spooled to a temporary file next to
+// the log, until r is exhausted.
+func (w *LogWriter) PutReader(key []byte, r io.Reader, size int64) error {
+	if w.log == nil {
+		return ERROR_LOG_CLOSED
+	} else if err := w.checkKey(key); err != nil {
+		return err
+	} else if w.dups != nil {
+		return w.putReaderFiltered(key, r, size)
+	}
+	return w.putReader(key, r, size)
 }
 
-// Flush flushes any open compression block to file buffer
-func (w *LogWriter) Flush() error {
-	rc := C.sparkey_logwriter_flush(w.log)
-	return errorOrNil(rc)
+// PutBatch appends many put and delete operations to the log file, in order.
+// It is considerably more efficient than individual calls to Put or Delete
+// when writing large numbers of small entries. If an error occurs, the
+// entries preceding the failed one will have been written.
+func (w *LogWriter) PutBatch(entries []Entry) error {
+	if w.log == nil {
+		return ERROR_LOG_CLOSED
+	}
+	for _, e := range entries {
+		if e.Type != ENTRY_PUT && e.Type != ENTRY_DELETE {
+			return ErrInvalidEntryType
+		} else if err := w.checkKey(e.Key); err != nil {
+			return err
+		}
+	}
+
+	if w.dups != nil {
+		for _, e := range entries {
+			var err error
+			if e.Type == ENTRY_PUT {
+				err = w.putFiltered(e.Key, e.Value)
+			} else {
+				err = w.Delete(e.Key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return w.putBatch(entries)
 }
 
-// WriteHashFile will (re-)write a hashfile for the current log file
-func (w *LogWriter) WriteHashFile(size HashSize) error {
-	w.Flush() // Try to flush
-	return WriteHashFile(w.name, size)
+// checkKey validates the length of a key
+func (w *LogWriter) checkKey(key []byte) error {
+	if w.maxKeyLen > 0 && uint64(len(key)) > w.maxKeyLen {
+		return ErrKeyTooLong
+	}
+	return nil
 }
 
-// Close closes an open log-writer
-func (w *LogWriter) Close() error {
-	if w.log == nil {
-		return nil
+// spoolValue copies a value into a temporary file next to the log. It returns
+// the file, positioned at the start, together with the size of the value.
+func (w *LogWriter) spoolValue(r io.Reader, size int64) (*os.File, int64, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(w.name), "."+filepath.Base(w.name)+".")
+	if err != nil {
+		return nil, 0, fileError(err)
+	}
+
+	var n int64
+	if size < 0 {
+		n, err = io.Copy(tmp, r)
+	} else if n, err = io.CopyN(tmp, r, size); err == io.EOF {
+		err = ERROR_UNEXPECTED_EOF
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
 	}
-	rc := C.sparkey_logwriter_close(&w.log)
-	w.log = nil
-	return errorOrNil(rc)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, n, nil
 }
 
 /* LogReader */
 
 type LogReader struct {
-	name string
-	log  *C.sparkey_logreader
+	name   string
+	file   *os.File // set by OpenLogReaderFile
+	shared bool
+
+	mu      sync.RWMutex
+	snap    *logSnapshot   // current snapshot, nil once closed
+	header  *LogHeader     // header of the latest snapshot
+	retired []*logSnapshot // previous snapshots, until their iterators are closed
+
+	offsets   []uint64 // sparse entry offset index, see IteratorAt
+	offsetsMu sync.Mutex
+}
+
+// logSnapshot is a log handle of a LogReader. It is reference counted by
+// the reader and by the iterators created from it, and closed once the
+// reader has moved on to a newer snapshot and the last iterator is closed.
+type logSnapshot struct {
+	log    *logReaderHandle
+	header *LogHeader
+	refs   int32
+	once   sync.Once
+}
+
+func newLogSnapshot(log *logReaderHandle, header *LogHeader) *logSnapshot {
+	return &logSnapshot{log: log, header: header, refs: 1}
+}
+
+// retain adds a reference and returns the snapshot, nil is retained as nil.
+func (s *logSnapshot) retain() *logSnapshot {
+	if s != nil {
+		atomic.AddInt32(&s.refs, 1)
+	}
+	return s
+}
+
+// release drops a reference and closes the handle once it was the last.
+func (s *logSnapshot) release() {
+	if atomic.AddInt32(&s.refs, -1) == 0 {
+		s.close()
+	}
+}
+
+// close closes the handle, regardless of the references.
+func (s *logSnapshot) close() {
+	s.once.Do(func() {
+		atomic.StoreInt32(&s.refs, 0)
+		closeLogReader(s.log)
+	})
+}
+
+// released returns true once the handle has been closed.
+func (s *logSnapshot) released() bool {
+	return atomic.LoadInt32(&s.refs) <= 0
 }
 
 // OpenLogReader opens an existing Sparkey log file for reading
 // The reader is threadsafe, except during opening or closing.
+//
+// The reader captures the length of the log data at the time it is opened,
+// iteration is confined to that snapshot, even if another process continues
+// to append to the log. Use Refresh to extend the view.
 func OpenLogReader(fname string) (*LogReader, error) {
-	reader := LogReader{name: LogFileName(fname)}
-	filename := C.CString(reader.name)
-	defer C.free(unsafe.Pointer(filename))
+	reader := &LogReader{name: LogFileName(fname)}
+	err := retryOpen("open", reader.name, func() error {
+		log, header, err := openLogReaderWithHeader(reader.name)
+		if err == nil {
+			reader.snap, reader.header = newLogSnapshot(log, header), header
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
 
-	rc := C.sparkey_logreader_open(&reader.log, filename)
-	if rc == rc_SUCCESS {
-		return &reader, nil
+// OpenLogReaderFile opens an already open Sparkey log file for reading, e.g.
+// a descriptor received from another process. The file must remain open
+// for as long as the reader may be refreshed, it is never closed by the reader.
+func OpenLogReaderFile(file *os.File) (*LogReader, error) {
+	reader := &LogReader{name: file.Name(), file: file}
+	err := retryOpen("open", reader.name, func() error {
+		log, header, err := openLogReaderFile(file)
+		if err == nil {
+			reader.snap, reader.header = newLogSnapshot(log, header), header
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, Error(rc)
+	return reader, nil
 }
 
 // Close closes a reader
@@ -119,43 +396,233 @@ func OpenLogReader(fname string) (*LogReader, error) {
 // Further operations on such logiterators will fail.
 // This is a failsafe operation.
 func (r *LogReader) Close() error {
-	if r.log != nil {
-		C.sparkey_logreader_close(&r.log)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.snap != nil {
+		r.snap.close()
+	}
+	r.snap = nil
+	for _, snap := range r.retired {
+		snap.close()
+	}
+	r.retired = nil
+	return nil
+}
+
+// Refresh re-opens the log file and extends the snapshot to include all
+// data that was flushed by writers since the reader was opened (or last refreshed).
+// Iterators created before the refresh continue to see their original snapshot,
+// new iterators will see the extended view. The original snapshot is
+// released once its last iterator is closed. Refresh is safe to call
+// concurrently with other methods, except Close.
+// Refresh is not supported on readers obtained via HashReader.Log().
+func (r *LogReader) Refresh() error {
+	if r.shared {
+		return ErrSharedLogReader
+	} else if r.current() == nil {
+		return ERROR_LOG_CLOSED
+	}
+
+	var log *logReaderHandle
+	var header *LogHeader
+	err := retryOpen("refresh", r.name, func() (err error) {
+		if r.file != nil {
+			log, header, err = openLogReaderFile(r.file)
+		} else {
+			log, header, err = openLogReaderWithHeader(r.name)
+		}
+		return
+	})
+	if err != nil {
+		return err
 	}
-	r.log = nil
+
+	r.mu.Lock()
+	old := r.snap
+	if old == nil {
+		r.mu.Unlock()
+		closeLogReader(log)
+		return ERROR_LOG_CLOSED
+	}
+	r.snap, r.header = newLogSnapshot(log, header), header
+
+	retired := r.retired[:0]
+	for _, snap := range r.retired {
+		if !snap.released() {
+			retired = append(retired, snap)
+		}
+	}
+	r.retired = append(retired, old)
+	r.mu.Unlock()
+	old.release()
+
+	r.offsetsMu.Lock()
+	r.offsets = nil
+	r.offsetsMu.Unlock()
 	return nil
 }
 
+// current returns the current snapshot, or nil if the reader is closed.
+func (r *LogReader) current() *logSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.snap
+}
+
+// acquire returns the current snapshot with an additional reference, which
+// must be released.
+func (r *LogReader) acquire() (*logSnapshot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.snap == nil {
+		return nil, ERROR_LOG_CLOSED
+	}
+	return r.snap.retain(), nil
+}
+
 // Name returns the hash file name
 func (r *LogReader) Name() string { return r.name }
 
-// MaxKeyLen gets the size of the largest key in the log.
-func (r *LogReader) MaxKeyLen() uint64 {
-	return uint64(C.sparkey_logreader_maxkeylen(r.log))
+// Header returns the log file header, as of the time the reader
+// was opened (or last refreshed).
+func (r *LogReader) Header() LogHeader {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return *r.header
 }
 
+// MaxKeyLen gets the size of the largest key in the log.
+func (r *LogReader) MaxKeyLen() uint64 { return r.Header().MaxKeyLen }
+
 // MaxValueLen gets the size of the largest value in the log.
-func (r *LogReader) MaxValueLen() uint64 {
-	return uint64(C.sparkey_logreader_maxvaluelen(r.log))
-}
+func (r *LogReader) MaxValueLen() uint64 { return r.Header().MaxValueLen }
 
 // Compression returns the compression type.
-func (r *LogReader) Compression() CompressionType {
-	return CompressionType(C.sparkey_logreader_get_compression_type(r.log))
-}
+func (r *LogReader) Compression() CompressionType { return r.Header().Compression }
 
 // CompressionBlockSize returns the compression block size.
-func (r *LogReader) CompressionBlockSize() int {
-	return int(C.sparkey_logreader_get_compression_blocksize(r.log))
+func (r *LogReader) CompressionBlockSize() int { return int(r.Header().CompressionBlockSize) }
+
+// openLogReaderWithHeader opens a log reader together with the file header
+func openLogReaderWithHeader(name string) (*logReaderHandle, *LogHeader, error) {
+	log, err := openLogReader(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := logReaderHeader(log, name)
+	if err != nil {
+		closeLogReader(log)
+		return nil, nil, err
+	}
+	return log, header, nil
 }
 
-// Iterator initializes an iterator and associates it with the reader.
-// The reader must be open. The iterator is not threadsafe.
-func (r *LogReader) Iterator() (*LogIter, error) {
-	iter := LogIter{log: r.log}
-	rc := C.sparkey_logiter_create(&iter.iter, r.log)
-	if rc == rc_SUCCESS {
-		return &iter, nil
+// IteratorAt initializes an iterator positioned at the entry with the given
+// (zero-based) index. If the index is beyond the last entry, the state of the
+// iterator will be ITERATOR_CLOSED.
+//
+// For uncompressed logs, a sparse index of entry offsets is built lazily on the
+// first call, subsequent calls skip directly to the nearest indexed entry.
+// Compressed logs are always skipped from the start.
+//
+//  Example usage:
+//
+//     iter, _ := reader.IteratorAt(1000)
+//     for ; iter.Valid(); iter.Next() {
+//         ...
+//     }
+//
+func (r *LogReader) IteratorAt(index uint64) (*LogIter, error) {
+	iter, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	skip := index + 1
+	if r.Compression() == COMPRESSION_NONE {
+		offsets, err := r.entryOffsets()
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+
+		if n := index / entryOffsetInterval; n < uint64(len(offsets)) {
+			if err := iter.seek(offsets[n]); err != nil {
+				iter.Close()
+				return nil, err
+			}
+			skip -= n * entryOffsetInterval
+		}
+	}
+
+	for skip > 0 && iter.State() != ITERATOR_CLOSED {
+		n := skip
+		if n > uint64(maxInt32) {
+			n = uint64(maxInt32)
+		}
+		if err := iter.Skip(int(n)); err != nil {
+			iter.Close()
+			return nil, err
+		}
+		skip -= n
 	}
-	return nil, Error(rc)
+	return iter, nil
+}
+
+// IteratorAtOffset initializes an iterator positioned at the entry at
+// offset, as returned by LogIter.Offset. Together, they allow to checkpoint
+// the processing of large logs and resume it later. Offsets are only
+// supported by uncompressed logs.
+func (r *LogReader) IteratorAtOffset(offset uint64) (*LogIter, error) {
+	iter, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := iter.SeekOffset(offset); err != nil {
+		iter.Close()
+		return nil, err
+	}
+	if err := iter.Next(); err != nil {
+		iter.Close()
+		return nil, err
+	}
+	return iter, nil
+}
+
+// entryOffsets returns the sparse entry offset index, building it if necessary
+func (r *LogReader) entryOffsets() ([]uint64, error) {
+	r.offsetsMu.Lock()
+	defer r.offsetsMu.Unlock()
+
+	if r.offsets != nil {
+		return r.offsets, nil
+	}
+
+	iter, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	offsets := make([]uint64, 0, 1)
+	offset := uint64(logHeaderSize)
+	for n := uint64(0); ; n++ {
+		if err := iter.Next(); err != nil {
+			return nil, err
+		} else if !iter.Valid() {
+			break
+		}
+
+		if n%entryOffsetInterval == 0 {
+			offsets = append(offsets, offset)
+		}
+		offset += iter.entrySize()
+	}
+
+	r.offsets = offsets
+	return offsets, nil
 }
@@ -0,0 +1,331 @@
+//go:build !purego
+// +build !purego
+
+package sparkey
+
+/*
+#cgo LDFLAGS: -lsparkey
+#include <stdlib.h>
+#include <sparkey/sparkey.h>
+
+// Writes n entries. The keys and values of all entries are concatenated
+// in data, lens contains the key and value length of each entry. The
+// number of successfully written entries is stored in written.
+static sparkey_returncode sparkey_go_logwriter_batch(sparkey_logwriter *log,
+		uint8_t *types, uint8_t *data, uint64_t *lens, int n, int *written) {
+	sparkey_returncode rc = SPARKEY_SUCCESS;
+	int i;
+
+	for (i = 0; i < n; i++, lens += 2) {
+		if (types[i] == SPARKEY_ENTRY_PUT) {
+			rc = sparkey_logwriter_put(log, lens[0], data, lens[1], data + lens[0]);
+		} else {
+			rc = sparkey_logwriter_delete(log, lens[0], data);
+		}
+		if (rc != SPARKEY_SUCCESS) {
+			break;
+		}
+		data += lens[0] + lens[1];
+	}
+	*written = i;
+	return rc;
+}
+*/
+import "C"
+import (
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"unsafe"
+)
+
+type (
+	logWriterHandle = C.sparkey_logwriter
+	logReaderHandle = C.sparkey_logreader
+)
+
+// createLogWriter creates a new log, libsparkey always compresses zstd
+// blocks at its default level, so other levels are rejected.
+func createLogWriter(name string, compression CompressionType, blockSize, level int) (*logWriterHandle, error) {
+	if level != 0 {
+		return nil, ErrCompressionLevelUnsupported
+	}
+
+	filename := C.CString(name)
+	defer C.free(unsafe.Pointer(filename))
+
+	var log *C.sparkey_logwriter
+	rc := C.sparkey_logwriter_create(&log, filename, C.sparkey_compression_type(compression), C.int(blockSize))
+	return log, errorOrNil(rc)
+}
+
+func appendLogWriter(name string) (*logWriterHandle, error) {
+	filename := C.CString(name)
+	defer C.free(unsafe.Pointer(filename))
+
+	var log *C.sparkey_logwriter
+	rc := C.sparkey_logwriter_append(&log, filename)
+	return log, errorOrNil(rc)
+}
+
+func openLogReader(name string) (*logReaderHandle, error) {
+	filename := C.CString(name)
+	defer C.free(unsafe.Pointer(filename))
+
+	var log *C.sparkey_logreader
+	rc := C.sparkey_logreader_open(&log, filename)
+	return log, errorOrNil(rc)
+}
+
+// openLogReaderFile opens the log via the descriptor path of the file,
+// libsparkey can only open files by name.
+func openLogReaderFile(file *os.File) (*logReaderHandle, *LogHeader, error) {
+	return openLogReaderWithHeader(fdPath(file))
+}
+
+func logReaderHeader(_ *logReaderHandle, name string) (*LogHeader, error) {
+	return readLogHeader(name)
+}
+
+func closeLogReader(log *logReaderHandle) {
+	C.sparkey_logreader_close(&log)
+}
+
+// fdPath returns a path that refers to the descriptor of an open file.
+func fdPath(file *os.File) string {
+	if runtime.GOOS == "linux" {
+		return "/proc/self/fd/" + strconv.Itoa(int(file.Fd()))
+	}
+	return "/dev/fd/" + strconv.Itoa(int(file.Fd()))
+}
+
+/* LogWriter */
+
+func (w *LogWriter) put(key, value []byte) error {
+	var ck, cv *C.uint8_t
+	lk, lv := len(key), len(value)
+
+	if lk > 0 {
+		ck = (*C.uint8_t)(&key[0])
+	}
+	if lv > 0 {
+		cv = (*C.uint8_t)(&value[0])
+	}
+
+	rc := C.sparkey_logwriter_put(w.log, C.uint64_t(lk), ck, C.uint64_t(lv), cv)
+	if rc != rc_SUCCESS {
+		return Error(rc)
+	}
+	w.countPut(key, value)
+	return nil
+}
+
+// putReader spools the value into a temporary file, which is then memory
+// mapped and passed to libsparkey in a single call.
+func (w *LogWriter) putReader(key []byte, r io.Reader, size int64) error {
+	tmp, _, err := w.spoolValue(r, size)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	value, unmap, err := mapFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer unmap()
+
+	return w.put(key, value)
+}
+
+// putBatch copies the entries into a flat buffer and writes them with
+// a single call into libsparkey per putBatchBufferSize.
+func (w *LogWriter) putBatch(entries []Entry) error {
+	var (
+		types []C.uint8_t
+		data  []byte
+		lens  []C.uint64_t
+	)
+
+	for n, e := range entries {
+		value := e.Value
+		if e.Type != ENTRY_PUT {
+			value = nil
+		}
+		types = append(types, C.uint8_t(e.Type))
+		data = append(data, e.Key...)
+		data = append(data, value...)
+		lens = append(lens, C.uint64_t(len(e.Key)), C.uint64_t(len(value)))
+
+		if len(data) < putBatchBufferSize && n < len(entries)-1 {
+			continue
+		}
+
+		var cd *C.uint8_t
+		if len(data) > 0 {
+			cd = (*C.uint8_t)(&data[0])
+		}
+		var written C.int
+		rc := C.sparkey_go_logwriter_batch(w.log, &types[0], cd, &lens[0], C.int(len(types)), &written)
+		for _, e := range entries[n+1-len(types) : n+1-len(types)+int(written)] {
+			if e.Type == ENTRY_PUT {
+				w.countPut(e.Key, e.Value)
+			} else {
+				w.countDelete(e.Key)
+			}
+		}
+		if rc != rc_SUCCESS {
+			return Error(rc)
+		}
+		types, data, lens = types[:0], data[:0], lens[:0]
+	}
+	return nil
+}
+
+func (w *LogWriter) delete(key []byte) error {
+	var k *C.uint8_t
+	if len(key) != 0 {
+		k = (*C.uint8_t)(&key[0])
+	}
+
+	rc := C.sparkey_logwriter_delete(w.log, C.uint64_t(len(key)), k)
+	if rc != rc_SUCCESS {
+		return Error(rc)
+	}
+	w.countDelete(key)
+	return nil
+}
+
+// Stats returns statistics about the log. As libsparkey buffers writes
+// internally, FileSize and CompressedBytes only include data that has
+// been passed on to the file system.
+func (w *LogWriter) Stats() (LogWriterStats, error) {
+	if w.log == nil {
+		return LogWriterStats{}, ERROR_LOG_CLOSED
+	}
+
+	info, err := os.Stat(w.name)
+	if err != nil {
+		return LogWriterStats{}, fileError(err)
+	}
+
+	stats := w.stats
+	stats.FileSize = uint64(info.Size())
+	if stats.FileSize > logHeaderSize {
+		stats.CompressedBytes = stats.FileSize - logHeaderSize
+	}
+	return stats, nil
+}
+
+// loadStats initialises the entry counts from the header of an
+// existing log.
+func (w *LogWriter) loadStats() error {
+	header, err := readLogHeader(w.name)
+	if err != nil {
+		return err
+	}
+	w.stats.Puts, w.stats.Deletes = header.NumPuts, header.NumDeletes
+	w.stats.RawBytes = header.PutSize + header.DeleteSize
+	w.stats.MaxKeyLen = header.MaxKeyLen
+	return nil
+}
+
+func (w *LogWriter) countPut(key, value []byte) {
+	lk, lv := uint64(len(key)), uint64(len(value))
+	w.stats.Puts++
+	w.stats.RawBytes += vlqLen(lk+1) + vlqLen(lv) + lk + lv
+	if lk > w.stats.MaxKeyLen {
+		w.stats.MaxKeyLen = lk
+	}
+}
+
+func (w *LogWriter) countDelete(key []byte) {
+	lk := uint64(len(key))
+	w.stats.Deletes++
+	w.stats.RawBytes += vlqLen(0) + vlqLen(lk) + lk
+	if lk > w.stats.MaxKeyLen {
+		w.stats.MaxKeyLen = lk
+	}
+}
+
+func (w *LogWriter) flush() error {
+	rc := C.sparkey_logwriter_flush(w.log)
+	return errorOrNil(rc)
+}
+
+func (w *LogWriter) syncFile() error {
+	file, err := os.OpenFile(w.name, os.O_RDWR, 0)
+	if err != nil {
+		return fileError(err)
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fileError(err)
+	}
+	return nil
+}
+
+// Close closes an open log-writer
+func (w *LogWriter) Close() error {
+	if w.log == nil {
+		return nil
+	}
+	var err error
+	if w.sync {
+		err = w.Flush()
+	}
+	rc := C.sparkey_logwriter_close(&w.log)
+	w.log = nil
+	if err != nil {
+		return err
+	}
+	return errorOrNil(rc)
+}
+
+/* LogReader */
+
+// nativeLog maps the snapshot of the reader for native Go access, which
+// allows to skip over values without decompressing them. It returns the
+// log and a function which releases it.
+func (r *LogReader) nativeLog() (*logFile, func(), error) {
+	if r.current() == nil {
+		return nil, nil, ERROR_LOG_CLOSED
+	}
+
+	file := r.file
+	if file == nil {
+		f, err := os.Open(r.name)
+		if err != nil {
+			return nil, nil, fileError(err)
+		}
+		defer f.Close()
+		file = f
+	}
+
+	header := r.Header()
+	log, err := openLogFileSnapshot(file, &header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return log, log.close, nil
+}
+
+// Iterator initializes an iterator and associates it with the reader.
+// The reader must be open. The iterator is not threadsafe.
+func (r *LogReader) Iterator() (*LogIter, error) {
+	snap, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	iter := LogIter{log: snap.log, snap: snap}
+	rc := C.sparkey_logiter_create(&iter.iter, snap.log)
+	if rc != rc_SUCCESS {
+		snap.release()
+		return nil, Error(rc)
+	}
+	return &iter, nil
+}
@@ -0,0 +1,159 @@
+//go:build purego
+// +build purego
+
+package sparkey
+
+import (
+	"io"
+	"os"
+)
+
+type (
+	logWriterHandle = logFileWriter
+	logReaderHandle = logFile
+)
+
+func createLogWriter(name string, compression CompressionType, blockSize, level int) (*logWriterHandle, error) {
+	return createLogFileWriter(name, compression, blockSize, level)
+}
+
+func appendLogWriter(name string) (*logWriterHandle, error) {
+	return appendLogFileWriter(name)
+}
+
+func openLogReader(name string) (*logReaderHandle, error) {
+	return openLogFile(name)
+}
+
+func openLogReaderFile(file *os.File) (*logReaderHandle, *LogHeader, error) {
+	log, err := openLogFileFrom(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return log, log.header, nil
+}
+
+func logReaderHeader(log *logReaderHandle, _ string) (*LogHeader, error) {
+	return log.header, nil
+}
+
+func closeLogReader(log *logReaderHandle) {
+	log.close()
+}
+
+/* LogWriter */
+
+func (w *LogWriter) put(key, value []byte) error {
+	return w.log.put(key, value)
+}
+
+// putReader streams the value into the log, values of unknown size are
+// spooled into a temporary file first.
+func (w *LogWriter) putReader(key []byte, r io.Reader, size int64) error {
+	if size < 0 {
+		tmp, n, err := w.spoolValue(r, size)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		r, size = tmp, n
+	}
+	return w.log.putReader(key, r, uint64(size))
+}
+
+// putBatch writes the entries one by one.
+func (w *LogWriter) putBatch(entries []Entry) error {
+	for _, e := range entries {
+		var err error
+		if e.Type == ENTRY_PUT {
+			err = w.log.put(e.Key, e.Value)
+		} else {
+			err = w.log.delete(e.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *LogWriter) delete(key []byte) error {
+	return w.log.delete(key)
+}
+
+// Stats returns statistics about the log. Uncompressed data is accounted
+// for as soon as it is written, even if it is still buffered.
+func (w *LogWriter) Stats() (LogWriterStats, error) {
+	if w.log == nil {
+		return LogWriterStats{}, ERROR_LOG_CLOSED
+	}
+
+	h := &w.log.header
+	return LogWriterStats{
+		Puts:            h.NumPuts,
+		Deletes:         h.NumDeletes,
+		RawBytes:        h.PutSize + h.DeleteSize,
+		CompressedBytes: h.DataEnd - logHeaderSize,
+		FileSize:        h.DataEnd,
+		MaxKeyLen:       h.MaxKeyLen,
+	}, nil
+}
+
+func (w *LogWriter) loadStats() error { return nil }
+
+func (w *LogWriter) flush() error {
+	return w.log.flush()
+}
+
+func (w *LogWriter) syncFile() error {
+	if err := w.log.file.Sync(); err != nil {
+		return fileError(err)
+	}
+	return nil
+}
+
+// Close closes an open log-writer
+func (w *LogWriter) Close() error {
+	if w.log == nil {
+		return nil
+	}
+	var err error
+	if w.sync {
+		err = w.Flush()
+	}
+	if e := w.log.close(); err == nil {
+		err = e
+	}
+	w.log = nil
+	return err
+}
+
+/* LogReader */
+
+// nativeLog returns the snapshot of the reader for native Go access, and a
+// function which releases it.
+func (r *LogReader) nativeLog() (*logFile, func(), error) {
+	snap, err := r.acquire()
+	if err != nil {
+		return nil, nil, err
+	}
+	return snap.log, snap.release, nil
+}
+
+// Iterator initializes an iterator and associates it with the reader.
+// The reader must be open. The iterator is not threadsafe.
+func (r *LogReader) Iterator() (*LogIter, error) {
+	snap, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := newLogCursor(snap.log)
+	if err != nil {
+		snap.release()
+		return nil, err
+	}
+	return &LogIter{iter: iter, log: snap.log, snap: snap}, nil
+}
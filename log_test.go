@@ -1,7 +1,12 @@
 package sparkey
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -51,6 +56,122 @@ var _ = Describe("LogWriter", func() {
 		Expect(subject.Put([]byte("k2"), []byte("v2"))).NotTo(HaveOccurred())
 	})
 
+	It("should write batches", func() {
+		entries := []Entry{
+			{Type: ENTRY_PUT, Key: []byte("k1"), Value: []byte("v1")},
+			{Type: ENTRY_PUT, Key: []byte("k2"), Value: []byte("v2")},
+			{Type: ENTRY_DELETE, Key: []byte("k1"), Value: []byte("ignored")},
+			{Type: ENTRY_PUT, Key: []byte{}, Value: []byte{}},
+		}
+		for i := 0; i < 200; i++ {
+			entries = append(entries, Entry{Key: []byte(fmt.Sprintf("x%03d", i)), Value: []byte(veryLongString)})
+		}
+		Expect(subject.PutBatch(nil)).To(Succeed())
+		Expect(subject.PutBatch(entries)).To(Succeed())
+		Expect(subject.PutBatch([]Entry{{Type: 7, Key: []byte("k3")}})).To(Equal(ErrInvalidEntryType))
+		Expect(subject.WriteHashFile(HASH_SIZE_AUTO)).To(Succeed())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.NumSlots()).To(Equal(uint64(202)))
+		Expect(reader.Get([]byte("k1"))).To(BeNil())
+		Expect(reader.Get([]byte("k2"))).To(Equal([]byte("v2")))
+		Expect(reader.Get([]byte(""))).To(Equal([]byte{}))
+		Expect(reader.Get([]byte("x199"))).To(Equal([]byte(veryLongString)))
+
+		header, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.NumPuts).To(Equal(uint64(203)))
+		Expect(header.NumDeletes).To(Equal(uint64(1)))
+	})
+
+	It("should add pairs from readers", func() {
+		Expect(subject.PutReader([]byte("k1"), strings.NewReader("v1"), 2)).To(Succeed())
+		Expect(subject.PutReader([]byte("k2"), strings.NewReader(veryLongString), -1)).To(Succeed())
+		Expect(subject.PutReader([]byte("k3"), strings.NewReader("v3 and more"), 2)).To(Succeed())
+		Expect(subject.PutReader([]byte("k4"), strings.NewReader(""), 0)).To(Succeed())
+		Expect(subject.PutReader([]byte("k5"), strings.NewReader("short"), 10)).To(Equal(ERROR_UNEXPECTED_EOF))
+		Expect(subject.PutReader([]byte("k6"), failingReader{}, 10)).To(MatchError("failed"))
+		Expect(subject.WriteHashFile(HASH_SIZE_AUTO)).To(Succeed())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.NumSlots()).To(Equal(uint64(4)))
+		Expect(reader.Get([]byte("k1"))).To(Equal([]byte("v1")))
+		Expect(reader.Get([]byte("k2"))).To(Equal([]byte(veryLongString)))
+		Expect(reader.Get([]byte("k3"))).To(Equal([]byte("v3")))
+		Expect(reader.Get([]byte("k4"))).To(Equal([]byte{}))
+
+		entries, _ := filepath.Glob(filepath.Join(testDir, "*"))
+		Expect(entries).To(HaveLen(2))
+	})
+
+	It("should report stats", func() {
+		Expect(subject.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(subject.PutBatch([]Entry{
+			{Type: ENTRY_PUT, Key: []byte("k2"), Value: []byte(veryLongString)},
+			{Type: ENTRY_DELETE, Key: []byte("k1")},
+		})).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+
+		stats, err := subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		header, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(LogWriterStats{
+			Puts:            2,
+			Deletes:         1,
+			RawBytes:        header.PutSize + header.DeleteSize,
+			CompressedBytes: header.DataEnd - logHeaderSize,
+			FileSize:        header.DataEnd,
+			MaxKeyLen:       2,
+		}))
+		Expect(stats.CompressionRatio()).To(Equal(1.0))
+		Expect(subject.Close()).To(Succeed())
+
+		_, err = subject.Stats()
+		Expect(err).To(Equal(ERROR_LOG_CLOSED))
+
+		subject, err = AppendLogWriter(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.Delete([]byte("k2"))).To(Succeed())
+		stats, err = subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Puts).To(Equal(uint64(2)))
+		Expect(stats.Deletes).To(Equal(uint64(2)))
+		Expect(stats.RawBytes).To(Equal(header.PutSize + header.DeleteSize + 4))
+		Expect(stats.MaxKeyLen).To(Equal(uint64(2)))
+
+		Expect(subject.Put([]byte("longer"), []byte("v"))).To(Succeed())
+		stats, err = subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.MaxKeyLen).To(Equal(uint64(6)))
+	})
+
+	It("should report compression stats", func() {
+		Expect(subject.Close()).To(Succeed())
+
+		var err error
+		subject, err = NewLogWriter(fname, WithCompression(COMPRESSION_SNAPPY), WithBlockSize(1024))
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 100; i++ {
+			Expect(subject.Put([]byte(fmt.Sprintf("k%02d", i)), []byte(veryLongString))).To(Succeed())
+		}
+		Expect(subject.Flush()).To(Succeed())
+
+		stats, err := subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Puts).To(Equal(uint64(100)))
+		Expect(stats.RawBytes).To(BeNumerically(">", 100*len(veryLongString)))
+		Expect(stats.CompressedBytes).To(BeNumerically("<", stats.RawBytes))
+		Expect(stats.FileSize).To(Equal(stats.CompressedBytes + logHeaderSize))
+		Expect(stats.CompressionRatio()).To(BeNumerically(">", 1))
+	})
+
 	It("should re-write hash-files", func() {
 		Expect(subject.WriteHashFile(HASH_SIZE_AUTO)).NotTo(HaveOccurred())
 		entries, _ := filepath.Glob(filepath.Join(testDir, "*"))
@@ -60,8 +181,43 @@ var _ = Describe("LogWriter", func() {
 		}))
 	})
 
+	It("should append and reindex", func() {
+		Expect(subject.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(subject.Put([]byte("k2"), []byte("v2"))).To(Succeed())
+		Expect(subject.WriteHashFile(HASH_SIZE_AUTO)).To(Succeed())
+		Expect(subject.Close()).To(Succeed())
+
+		Expect(AppendAndReindex(fname, func(w *LogWriter) error {
+			if err := w.Put([]byte("k2"), []byte("v2b")); err != nil {
+				return err
+			}
+			return w.Put([]byte("k3"), []byte("v3"))
+		})).To(Succeed())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Header().NumEntries).To(Equal(uint64(3)))
+		Expect(reader.Get([]byte("k1"))).To(Equal([]byte("v1")))
+		Expect(reader.Get([]byte("k2"))).To(Equal([]byte("v2b")))
+		Expect(reader.Get([]byte("k3"))).To(Equal([]byte("v3")))
+
+		failed := errors.New("failed")
+		Expect(AppendAndReindex(fname, func(w *LogWriter) error {
+			if err := w.Delete([]byte("k1")); err != nil {
+				return err
+			}
+			return failed
+		})).To(Equal(failed))
+		Expect(reader.Get([]byte("k1"))).To(Equal([]byte("v1")))
+	})
+
 })
 
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("failed") }
+
 var _ = Describe("LogReader", func() {
 	var subject *LogReader
 
@@ -77,7 +233,7 @@ var _ = Describe("LogReader", func() {
 	})
 
 	It("should open log files", func() {
-		Expect(subject.log).NotTo(BeNil())
+		Expect(subject.snap).NotTo(BeNil())
 		Expect(subject.Name()).To(ContainSubstring("test.spl"))
 		Expect(subject.MaxKeyLen()).To(Equal(uint64(2)))
 		Expect(subject.MaxValueLen()).To(Equal(uint64(len(veryLongString))))
@@ -92,4 +248,248 @@ var _ = Describe("LogReader", func() {
 		iter.Close()
 	})
 
+	It("should refresh snapshots", func() {
+		var count = func(iter *LogIter) (n int) {
+			for iter.Next(); iter.Valid(); iter.Next() {
+				n++
+			}
+			return n
+		}
+
+		writer, err := OpenLogWriter(subject.Name())
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+		Expect(writer.Put([]byte("ak"), []byte("appended"))).NotTo(HaveOccurred())
+		Expect(writer.Flush()).NotTo(HaveOccurred())
+
+		before, err := subject.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer before.Close()
+
+		Expect(subject.Header().NumPuts).To(Equal(uint64(3)))
+		Expect(subject.Refresh()).NotTo(HaveOccurred())
+		Expect(subject.Header().NumPuts).To(Equal(uint64(4)))
+		after, err := subject.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer after.Close()
+
+		Expect(count(before)).To(Equal(4))
+		Expect(count(after)).To(Equal(5))
+	})
+
+	It("should release retired snapshots", func() {
+		before, err := subject.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(subject.Refresh()).To(Succeed())
+		Expect(subject.retired).To(HaveLen(1))
+		retired := subject.retired[0]
+		Expect(retired.released()).To(BeFalse())
+
+		before.Close()
+		before.Close()
+		Expect(retired.released()).To(BeTrue())
+
+		Expect(subject.Refresh()).To(Succeed())
+		Expect(subject.retired).To(HaveLen(1))
+		Expect(subject.retired[0].released()).To(BeTrue())
+		Expect(subject.Refresh()).To(Succeed())
+		Expect(subject.retired).To(HaveLen(1))
+	})
+
+	It("should refresh while iterating", func() {
+		writer, err := OpenLogWriter(subject.Name())
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+
+		done := make(chan struct{})
+		errs := make(chan error, 1)
+		go func() {
+			defer close(errs)
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := writer.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v")); err != nil {
+					errs <- err
+					return
+				} else if err := writer.Flush(); err != nil {
+					errs <- err
+					return
+				} else if err := subject.Refresh(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < 50; i++ {
+			iter, err := subject.Iterator()
+			Expect(err).NotTo(HaveOccurred())
+			n := 0
+			for iter.Next(); iter.Valid(); iter.Next() {
+				n++
+			}
+			Expect(iter.Err()).NotTo(HaveOccurred())
+			Expect(n).To(BeNumerically(">=", 4))
+			iter.Close()
+
+			Expect(subject.Header().NumPuts).To(BeNumerically(">=", 3))
+			Expect(subject.Compression()).To(Equal(COMPRESSION_NONE))
+		}
+		close(done)
+		Expect(<-errs).NotTo(HaveOccurred())
+	})
+
+	It("should open and refresh open files", func() {
+		file, err := os.Open(subject.Name())
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		reader, err := OpenLogReaderFile(file)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Name()).To(Equal(subject.Name()))
+		Expect(reader.Header().NumPuts).To(Equal(uint64(3)))
+
+		writer, err := OpenLogWriter(subject.Name())
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+		Expect(writer.Put([]byte("ak"), []byte("appended"))).To(Succeed())
+		Expect(writer.Flush()).To(Succeed())
+
+		Expect(reader.Refresh()).To(Succeed())
+		Expect(reader.Header().NumPuts).To(Equal(uint64(4)))
+	})
+
+	It("should not refresh logs owned by hash readers", func() {
+		hash, err := Open(subject.Name())
+		Expect(err).NotTo(HaveOccurred())
+		defer hash.Close()
+		Expect(hash.Log().Refresh()).To(Equal(ErrSharedLogReader))
+	})
+
+})
+
+var _ = Describe("LogReader.IteratorAt", func() {
+
+	var writeLog = func(opts *Options) *LogReader {
+		fname := filepath.Join(testDir, "entries.spl")
+		w, err := CreateLogWriter(fname, opts)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 3000; i++ {
+			key := []byte(fmt.Sprintf("k%04d", i))
+			if i%7 == 0 {
+				Expect(w.Delete(key)).NotTo(HaveOccurred())
+			} else {
+				Expect(w.Put(key, bytes.Repeat([]byte{'v'}, i%200))).NotTo(HaveOccurred())
+			}
+		}
+		Expect(w.Close()).NotTo(HaveOccurred())
+
+		reader, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		return reader
+	}
+
+	var entryAt = func(reader *LogReader, index uint64) string {
+		iter, err := reader.IteratorAt(index)
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+
+		if iter.State() == ITERATOR_CLOSED {
+			return "<closed>"
+		}
+		key, err := iter.Key()
+		Expect(err).NotTo(HaveOccurred())
+		return string(key)
+	}
+
+	for _, opts := range []*Options{nil, {Compression: COMPRESSION_SNAPPY, CompressionBlockSize: 1024}} {
+		opts := opts
+
+		It(fmt.Sprintf("should position iterators (compression: %d)", opts.GetCompression()), func() {
+			reader := writeLog(opts)
+			defer reader.Close()
+
+			Expect(entryAt(reader, 0)).To(Equal("k0000"))
+			Expect(entryAt(reader, 1)).To(Equal("k0001"))
+			Expect(entryAt(reader, 1023)).To(Equal("k1023"))
+			Expect(entryAt(reader, 1024)).To(Equal("k1024"))
+			Expect(entryAt(reader, 2345)).To(Equal("k2345"))
+			Expect(entryAt(reader, 2999)).To(Equal("k2999"))
+			Expect(entryAt(reader, 3000)).To(Equal("<closed>"))
+			Expect(entryAt(reader, 9999)).To(Equal("<closed>"))
+		})
+	}
+
+	It("should continue iteration", func() {
+		reader := writeLog(nil)
+		defer reader.Close()
+
+		iter, err := reader.IteratorAt(2997)
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			key, err := iter.Key()
+			Expect(err).NotTo(HaveOccurred())
+			keys = append(keys, string(key))
+		}
+		Expect(keys).To(Equal([]string{"k2997", "k2998", "k2999"}))
+		Expect(iter.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should resume from offsets", func() {
+		reader := writeLog(nil)
+		defer reader.Close()
+
+		iter, err := reader.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+		iter.TrackOffsets(true)
+		Expect(iter.Skip(1503)).To(Succeed())
+		offset := iter.Offset()
+		Expect(offset).To(BeNumerically(">", logHeaderSize))
+
+		resumed, err := reader.IteratorAtOffset(offset)
+		Expect(err).NotTo(HaveOccurred())
+		defer resumed.Close()
+		Expect(resumed.Offset()).To(Equal(offset))
+		Expect(resumed.Key()).To(Equal([]byte("k1502")))
+
+		Expect(resumed.Next()).To(Succeed())
+		Expect(resumed.Offset()).To(BeNumerically(">", offset))
+		Expect(resumed.Key()).To(Equal([]byte("k1503")))
+
+		Expect(resumed.SeekOffset(offset)).To(Succeed())
+		Expect(resumed.Offset()).To(BeZero())
+		Expect(resumed.Next()).To(Succeed())
+		Expect(resumed.Key()).To(Equal([]byte("k1502")))
+
+		first, err := reader.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer first.Close()
+		first.TrackOffsets(true)
+		Expect(first.Offset()).To(BeZero())
+		Expect(first.Next()).To(Succeed())
+		Expect(first.Offset()).To(Equal(uint64(logHeaderSize)))
+	})
+
+	It("should reject offsets in compressed logs", func() {
+		reader := writeLog(&Options{Compression: COMPRESSION_SNAPPY})
+		defer reader.Close()
+
+		iter, err := reader.IteratorAt(3)
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+		Expect(iter.Offset()).To(BeZero())
+
+		_, err = reader.IteratorAtOffset(logHeaderSize)
+		Expect(err).To(Equal(ErrOffsetUnsupported))
+	})
+
 })
@@ -0,0 +1,408 @@
+package sparkey
+
+import "bytes"
+
+// logCursor is a native Go iterator over the entries of a logFile. It
+// mirrors the semantics of the libsparkey log iterator.
+type logCursor struct {
+	log   *logFile
+	state IteratorState
+	typ   EntryType
+
+	pos              logPos // position of the next entry
+	entry            logPos // position of the current entry
+	payload          logPos // position of the current key
+	read             logPos // position of the next unconsumed key or value byte
+	headerLen        uint64 // encoded size of the current entry header
+	keyLen, valueLen uint64
+	keyRemaining     uint64
+	valueRemaining   uint64
+
+	entryBlock uint64 // block of the current entry, compressed logs only
+	entryIndex uint64 // index of the current entry within its block
+
+	block     uint64 // file position of the cached block
+	nextBlock uint64 // file position of the block that follows it
+	buf       []byte // uncompressed contents of the cached block
+}
+
+func newLogCursor(log *logFile) (*logCursor, error) {
+	if log == nil || log.closed {
+		return nil, ERROR_LOG_CLOSED
+	}
+	c := &logCursor{log: log, pos: log.start()}
+	c.clear()
+	return c, nil
+}
+
+// check returns an error if the cursor or its log are closed.
+func (c *logCursor) check() error {
+	if c == nil {
+		return ERROR_LOG_ITERATOR_CLOSED
+	} else if c.log.closed {
+		return ERROR_LOG_CLOSED
+	}
+	return nil
+}
+
+// checkActive returns an error if the cursor is not positioned on an entry.
+func (c *logCursor) checkActive() error {
+	if err := c.check(); err != nil {
+		return err
+	} else if c.state != ITERATOR_ACTIVE {
+		return ERROR_LOG_ITERATOR_INACTIVE
+	}
+	return nil
+}
+
+func (c *logCursor) clear() {
+	c.typ = ENTRY_DELETE
+	c.headerLen, c.keyLen, c.valueLen = 0, 0, 0
+	c.keyRemaining, c.valueRemaining = 0, 0
+}
+
+// next moves the cursor to the next entry.
+func (c *logCursor) next() error {
+	if c != nil && c.state == ITERATOR_CLOSED {
+		return nil
+	} else if err := c.check(); err != nil {
+		return err
+	}
+	return c.readEntry()
+}
+
+// skip moves the cursor forward by count entries.
+func (c *logCursor) skip(count int) error {
+	for ; count > 0; count-- {
+		if err := c.next(); err != nil {
+			return err
+		} else if c.state != ITERATOR_ACTIVE {
+			break
+		}
+	}
+	return nil
+}
+
+// seek positions the cursor before the entry at the given file position.
+func (c *logCursor) seek(pos uint64) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+
+	p, ok := c.log.posAt(pos)
+	if !ok {
+		return ERROR_LOG_ITERATOR_INACTIVE
+	}
+
+	c.clear()
+	c.pos = p
+	c.entryBlock = 0
+	if pos < c.log.header.DataEnd {
+		c.state = ITERATOR_NEW
+	} else {
+		c.state = ITERATOR_CLOSED
+	}
+	return nil
+}
+
+// reset rewinds the cursor to the start of the current entry.
+func (c *logCursor) reset() error {
+	if err := c.checkActive(); err != nil {
+		return err
+	}
+	c.read = c.payload
+	c.keyRemaining = c.keyLen
+	c.valueRemaining = c.valueLen
+	return nil
+}
+
+// readEntry reads the header of the entry at c.pos.
+func (c *logCursor) readEntry() error {
+	c.clear()
+	c.state = ITERATOR_INVALID
+	if buf, err := c.view(&c.pos); err != nil {
+		return err
+	} else if len(buf) == 0 {
+		c.state = ITERATOR_CLOSED
+		return nil
+	}
+
+	c.entry = c.pos
+	a, err := c.readVlq()
+	if err != nil {
+		return err
+	}
+	b, err := c.readVlq()
+	if err != nil {
+		return err
+	}
+
+	if a == 0 {
+		c.typ, c.keyLen = ENTRY_DELETE, b
+	} else {
+		c.typ, c.keyLen, c.valueLen = ENTRY_PUT, a-1, b
+	}
+	if c.keyLen+c.valueLen < c.keyLen {
+		return ERROR_UNEXPECTED_EOF
+	}
+
+	c.payload = c.pos
+	c.pos.off += c.keyLen + c.valueLen
+	if err := c.normalize(&c.pos); err != nil {
+		return err
+	}
+
+	c.read = c.payload
+	c.keyRemaining, c.valueRemaining = c.keyLen, c.valueLen
+	c.state = ITERATOR_ACTIVE
+
+	if c.log.header.Compression != COMPRESSION_NONE {
+		if c.entry.block != c.entryBlock {
+			c.entryBlock, c.entryIndex = c.entry.block, 0
+		} else {
+			c.entryIndex++
+		}
+	}
+	return nil
+}
+
+// readVlq reads a variable-length quantity at c.pos.
+func (c *logCursor) readVlq() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		buf, err := c.view(&c.pos)
+		if err != nil {
+			return 0, err
+		} else if len(buf) == 0 {
+			return 0, ERROR_UNEXPECTED_EOF
+		}
+
+		c.pos.off++
+		c.headerLen++
+		v |= uint64(buf[0]&0x7f) << shift
+		if buf[0] < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, ERROR_LOG_HEADER_CORRUPT
+}
+
+// normalize moves p forward to the block that contains it, blocks are
+// skipped without decompressing them. Positions at the end of the stream
+// are valid, positions beyond it are not.
+func (c *logCursor) normalize(p *logPos) error {
+	log := c.log
+	if log.header.Compression == COMPRESSION_NONE {
+		if p.off > log.header.DataEnd-logHeaderSize {
+			return ERROR_UNEXPECTED_EOF
+		}
+		return nil
+	}
+
+	for p.block < log.header.DataEnd {
+		size, next, err := c.blockSize(p.block)
+		if err != nil {
+			return err
+		} else if p.off < size {
+			return nil
+		}
+		p.block, p.off = next, p.off-size
+	}
+	if p.off != 0 {
+		return ERROR_UNEXPECTED_EOF
+	}
+	return nil
+}
+
+// blockSize returns the uncompressed size of the block at the file position
+// pos and the position of the following block.
+func (c *logCursor) blockSize(pos uint64) (uint64, uint64, error) {
+	if pos == c.block && c.buf != nil {
+		return uint64(len(c.buf)), c.nextBlock, nil
+	}
+
+	data, next, err := c.log.readBlock(pos)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := blockDecodedLen(c.log.header.Compression, data)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, next, nil
+}
+
+// view returns the contiguous bytes of the entry stream, starting at p,
+// which is normalized first. It returns an empty slice at the end of the
+// stream.
+func (c *logCursor) view(p *logPos) ([]byte, error) {
+	log := c.log
+	if err := c.normalize(p); err != nil {
+		return nil, err
+	} else if log.header.Compression == COMPRESSION_NONE {
+		return log.data[logHeaderSize+p.off:], nil
+	} else if p.block >= log.header.DataEnd {
+		return nil, nil
+	}
+
+	if p.block != c.block || c.buf == nil {
+		data, next, err := log.readBlock(p.block)
+		if err != nil {
+			return nil, err
+		}
+		size, err := blockDecodedLen(log.header.Compression, data)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := decodeBlock(log.header.Compression, data)
+		if err != nil || uint64(len(buf)) != size {
+			return nil, ERROR_INTERNAL_ERROR
+		}
+		c.block, c.nextBlock, c.buf = p.block, next, buf
+	}
+	return c.buf[p.off:], nil
+}
+
+// keyChunk consumes up to max bytes from the current key.
+func (c *logCursor) keyChunk(max uint64) ([]byte, error) {
+	if err := c.checkActive(); err != nil {
+		return nil, err
+	}
+	return c.chunk(&c.keyRemaining, max)
+}
+
+// valueChunk consumes up to max bytes from the current value.
+func (c *logCursor) valueChunk(max uint64) ([]byte, error) {
+	if err := c.checkActive(); err != nil {
+		return nil, err
+	}
+	c.skipKey()
+	return c.chunk(&c.valueRemaining, max)
+}
+
+// skipKey consumes the remainder of the current key.
+func (c *logCursor) skipKey() {
+	c.read.off += c.keyRemaining
+	c.keyRemaining = 0
+}
+
+// chunk consumes up to max of the remaining bytes of the current section.
+func (c *logCursor) chunk(remaining *uint64, max uint64) ([]byte, error) {
+	n := *remaining
+	if n > max {
+		n = max
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	buf, err := c.view(&c.read)
+	if err != nil {
+		return nil, err
+	} else if len(buf) == 0 {
+		return nil, ERROR_UNEXPECTED_EOF
+	} else if uint64(len(buf)) > n {
+		buf = buf[:n]
+	}
+	c.read.off += uint64(len(buf))
+	*remaining -= uint64(len(buf))
+	return buf, nil
+}
+
+// fillChunks copies chunks into b until b is full or the chunks are exhausted.
+func fillChunks(b []byte, chunk func(uint64) ([]byte, error)) (int, error) {
+	n := 0
+	for n < len(b) {
+		buf, err := chunk(uint64(len(b) - n))
+		if err != nil {
+			return n, err
+		} else if len(buf) == 0 {
+			break
+		}
+		n += copy(b[n:], buf)
+	}
+	return n, nil
+}
+
+// appendKey appends the current key to dst, without consuming it.
+func (c *logCursor) appendKey(dst []byte) ([]byte, error) {
+	if err := c.checkActive(); err != nil {
+		return dst, err
+	}
+
+	for p, n := c.payload, c.keyLen; n > 0; {
+		buf, err := c.view(&p)
+		if err != nil {
+			return dst, err
+		} else if len(buf) == 0 {
+			return dst, ERROR_UNEXPECTED_EOF
+		} else if uint64(len(buf)) > n {
+			buf = buf[:n]
+		}
+		dst = append(dst, buf...)
+		p.off += uint64(len(buf))
+		n -= uint64(len(buf))
+	}
+	return dst, nil
+}
+
+// compareKey compares the current key with key, without consuming it.
+func (c *logCursor) compareKey(key []byte) (int, error) {
+	if err := c.checkActive(); err != nil {
+		return 0, err
+	}
+
+	for p, n := c.payload, c.keyLen; n > 0; {
+		buf, err := c.view(&p)
+		if err != nil {
+			return 0, err
+		} else if len(buf) == 0 {
+			return 0, ERROR_UNEXPECTED_EOF
+		} else if uint64(len(buf)) > n {
+			buf = buf[:n]
+		}
+
+		if len(buf) > len(key) {
+			if cmp := bytes.Compare(buf[:len(key)], key); cmp != 0 {
+				return cmp, nil
+			}
+			return 1, nil
+		} else if cmp := bytes.Compare(buf, key[:len(buf)]); cmp != 0 {
+			return cmp, nil
+		}
+		key = key[len(buf):]
+		p.off += uint64(len(buf))
+		n -= uint64(len(buf))
+	}
+
+	if len(key) != 0 {
+		return -1, nil
+	}
+	return 0, nil
+}
+
+// entrySize returns the encoded size of the current entry.
+func (c *logCursor) entrySize() uint64 {
+	return c.headerLen + c.keyLen + c.valueLen
+}
+
+// address returns the hash file address of the current entry.
+func (c *logCursor) address(entryBlockBits uint32) uint64 {
+	if c.log.header.Compression == COMPRESSION_NONE {
+		return c.log.filePos(c.entry)
+	}
+	return c.entryBlock<<entryBlockBits | c.entryIndex
+}
+
+// seekAddress positions the cursor on the entry at a hash file address.
+func (c *logCursor) seekAddress(addr uint64, entryBlockBits uint32) error {
+	if err := c.seek(addr >> entryBlockBits); err != nil {
+		return err
+	}
+
+	index := addr & (1<<entryBlockBits - 1)
+	if index >= uint64(maxInt32) {
+		return ERROR_LOG_ITERATOR_INACTIVE
+	}
+	return c.skip(int(index) + 1)
+}
@@ -0,0 +1,603 @@
+package sparkey
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"os"
+)
+
+// Supported format versions
+const (
+	logMajorVersion  = 1
+	logMinorVersion  = 1
+	hashMajorVersion = 1
+	hashMinorVersion = 1
+)
+
+// Limits of the compression block size
+const (
+	minCompressionBlockSize = 10
+	maxCompressionBlockSize = 100 * MiB
+)
+
+/* Log file */
+
+// logFile is a read-only, native Go view of a log file. The blocks of
+// compressed logs are located on demand, starting from a known block
+// position, such as the start of the data or a hash file address.
+type logFile struct {
+	header *LogHeader
+	data   []byte // file contents
+	unmap  func() error
+	closed bool
+}
+
+// logPos is a position in the entry stream of a log. In compressed logs,
+// block is the file position of a block and off an offset into its
+// uncompressed contents; in uncompressed logs, block is zero and off is
+// relative to the end of the header.
+type logPos struct {
+	block, off uint64
+}
+
+// less returns true if p is before o.
+func (p logPos) less(o logPos) bool {
+	return p.block < o.block || (p.block == o.block && p.off < o.off)
+}
+
+// openLogFile maps a log file into memory.
+func openLogFile(name string) (*logFile, error) {
+	return mapLogFile(mapFile(name))
+}
+
+// openLogFileFrom maps an open log file into memory.
+func openLogFileFrom(file *os.File) (*logFile, error) {
+	return mapLogFile(mapOpenFile(file))
+}
+
+// mapLogFile parses the (mapped) contents of a log file.
+func mapLogFile(data []byte, unmap func() error, err error) (*logFile, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := newLogFile(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	log.unmap = unmap
+	return log, nil
+}
+
+// openLogFileSnapshot maps an open log file into memory, limited to the
+// data described by header, which was read from the same file earlier.
+func openLogFileSnapshot(file *os.File, header *LogHeader) (*logFile, error) {
+	data, unmap, err := mapOpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if current, err := decodeLogHeader(data); err != nil {
+		unmap()
+		return nil, err
+	} else if current.FileIdentifier != header.FileIdentifier {
+		unmap()
+		return nil, ERROR_FILE_IDENTIFIER_MISMATCH
+	}
+
+	log, err := newLogFileSnapshot(data, header)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	log.unmap = unmap
+	return log, nil
+}
+
+// newLogFile parses the header of a log.
+func newLogFile(data []byte) (*logFile, error) {
+	header, err := decodeLogHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	return newLogFileSnapshot(data, header)
+}
+
+// newLogFileSnapshot creates a view of a log, limited to the data described
+// by header.
+func newLogFileSnapshot(data []byte, header *LogHeader) (*logFile, error) {
+	if header.MinorVersion > logMinorVersion {
+		return nil, ERROR_UNSUPPORTED_LOG_MINOR_VERSION
+	} else if header.DataEnd < logHeaderSize || header.DataEnd > uint64(len(data)) {
+		return nil, ERROR_LOG_HEADER_CORRUPT
+	}
+
+	switch header.Compression {
+	case COMPRESSION_NONE, COMPRESSION_SNAPPY, COMPRESSION_ZSTD:
+	default:
+		return nil, ERROR_INVALID_COMPRESSION_TYPE
+	}
+	return &logFile{header: header, data: data[:header.DataEnd]}, nil
+}
+
+// close marks the log as closed and releases the mapping.
+func (l *logFile) close() {
+	if l.closed {
+		return
+	}
+	l.closed = true
+	if l.unmap != nil {
+		l.unmap()
+	}
+	l.data = nil
+}
+
+// start returns the position of the first entry.
+func (l *logFile) start() logPos {
+	if l.header.Compression == COMPRESSION_NONE {
+		return logPos{}
+	}
+	return logPos{block: logHeaderSize}
+}
+
+// posAt converts a file position into a stream position. For compressed
+// logs, the position must point to the start of a block.
+func (l *logFile) posAt(pos uint64) (logPos, bool) {
+	if pos < logHeaderSize || pos > l.header.DataEnd {
+		return logPos{}, false
+	} else if l.header.Compression == COMPRESSION_NONE {
+		return logPos{off: pos - logHeaderSize}, true
+	} else if pos < l.header.DataEnd {
+		if _, _, err := l.readBlock(pos); err != nil {
+			return logPos{}, false
+		}
+	}
+	return logPos{block: pos}, true
+}
+
+// filePos converts a stream position into a file position. For compressed
+// logs, this is the position of the block.
+func (l *logFile) filePos(p logPos) uint64 {
+	if l.header.Compression == COMPRESSION_NONE {
+		return logHeaderSize + p.off
+	}
+	return p.block
+}
+
+// readBlock returns the compressed contents of the block at the file
+// position pos and the position of the following block.
+func (l *logFile) readBlock(pos uint64) ([]byte, uint64, error) {
+	if pos < logHeaderSize || pos >= l.header.DataEnd {
+		return nil, 0, ERROR_LOG_ITERATOR_INACTIVE
+	}
+
+	size, n := binary.Uvarint(l.data[pos:])
+	if n <= 0 || size > l.header.DataEnd-pos-uint64(n) {
+		return nil, 0, ERROR_LOG_HEADER_CORRUPT
+	}
+	start := pos + uint64(n)
+	return l.data[start : start+size], start + size, nil
+}
+
+/* Log file writer */
+
+// writableFile is the subset of *os.File used by logFileWriter.
+type writableFile interface {
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// logFileWriter is a native Go log writer.
+type logFileWriter struct {
+	file   writableFile
+	buf    *bufio.Writer
+	header LogHeader
+
+	encoder      *blockEncoder // compressed logs only
+	block        []byte        // pending block, compressed logs only
+	blockEntries uint32
+	spanned      bool // current entry spans multiple blocks
+}
+
+// createLogFileWriter creates a new log file, truncating existing files.
+// The level is only relevant for COMPRESSION_ZSTD.
+func createLogFileWriter(name string, compression CompressionType, blockSize, level int) (*logFileWriter, error) {
+	if err := checkCompression(compression, blockSize); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return newLogFileWriter(file, compression, blockSize, level)
+}
+
+// checkCompression validates compression settings.
+func checkCompression(compression CompressionType, blockSize int) error {
+	switch compression {
+	case COMPRESSION_NONE:
+	case COMPRESSION_SNAPPY, COMPRESSION_ZSTD:
+		if blockSize < minCompressionBlockSize || blockSize > maxCompressionBlockSize {
+			return ERROR_INVALID_COMPRESSION_BLOCK_SIZE
+		}
+	default:
+		return ERROR_INVALID_COMPRESSION_TYPE
+	}
+	return nil
+}
+
+// newLogFileWriter writes the header of a new log to an empty file. The
+// compression settings must be valid, the file is closed on errors.
+func newLogFileWriter(file writableFile, compression CompressionType, blockSize, level int) (*logFileWriter, error) {
+	if compression == COMPRESSION_NONE {
+		blockSize = 0
+	}
+
+	encoder, err := newBlockEncoder(compression, level)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &logFileWriter{
+		file:    file,
+		buf:     bufio.NewWriter(file),
+		encoder: encoder,
+		header: LogHeader{
+			MajorVersion:         logMajorVersion,
+			MinorVersion:         logMinorVersion,
+			FileIdentifier:       rand.Uint32(),
+			DataEnd:              logHeaderSize,
+			Compression:          compression,
+			CompressionBlockSize: uint32(blockSize),
+		},
+	}
+	if blockSize > 0 {
+		w.block = make([]byte, 0, blockSize)
+	}
+	if _, err := file.Write(encodeLogHeader(&w.header)); err != nil {
+		file.Close()
+		return nil, fileError(err)
+	}
+	return w, nil
+}
+
+// appendLogFileWriter opens an existing log file for appending.
+func appendLogFileWriter(name string) (*logFileWriter, error) {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fileError(err)
+	}
+
+	w, err := newAppendingLogFileWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func newAppendingLogFileWriter(file *os.File) (*logFileWriter, error) {
+	buf := make([]byte, logHeaderSize)
+	if _, err := io.ReadFull(file, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ERROR_LOG_TOO_SMALL
+	} else if err != nil {
+		return nil, fileError(err)
+	}
+
+	header, err := decodeLogHeader(buf)
+	if err != nil {
+		return nil, err
+	} else if header.MinorVersion > logMinorVersion {
+		return nil, ERROR_UNSUPPORTED_LOG_MINOR_VERSION
+	} else if header.Compression > COMPRESSION_ZSTD {
+		return nil, ERROR_INVALID_COMPRESSION_TYPE
+	}
+
+	encoder, err := newBlockEncoder(header.Compression, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fileError(err)
+	} else if uint64(info.Size()) < header.DataEnd {
+		return nil, ERROR_LOG_HEADER_CORRUPT
+	}
+
+	if _, err := file.Seek(int64(header.DataEnd), io.SeekStart); err != nil {
+		return nil, fileError(err)
+	}
+
+	w := &logFileWriter{file: file, buf: bufio.NewWriter(file), encoder: encoder, header: *header}
+	if header.Compression != COMPRESSION_NONE {
+		w.block = make([]byte, 0, header.CompressionBlockSize)
+	}
+	return w, nil
+}
+
+// put appends a key/value pair.
+func (w *logFileWriter) put(key, value []byte) error {
+	if w == nil {
+		return ERROR_LOG_CLOSED
+	}
+
+	var head [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(head[:], uint64(len(key))+1)
+	n += binary.PutUvarint(head[n:], uint64(len(value)))
+	if err := w.emit(head[:n], key, value); err != nil {
+		return err
+	}
+
+	w.header.NumPuts++
+	w.header.PutSize += uint64(n + len(key) + len(value))
+	if n := uint64(len(key)); n > w.header.MaxKeyLen {
+		w.header.MaxKeyLen = n
+	}
+	if n := uint64(len(value)); n > w.header.MaxValueLen {
+		w.header.MaxValueLen = n
+	}
+	return nil
+}
+
+// delete appends a delete entry for key.
+func (w *logFileWriter) delete(key []byte) error {
+	if w == nil {
+		return ERROR_LOG_CLOSED
+	}
+
+	var head [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(head[:], 0)
+	n += binary.PutUvarint(head[n:], uint64(len(key)))
+	if err := w.emit(head[:n], key, nil); err != nil {
+		return err
+	}
+
+	w.header.NumDeletes++
+	w.header.DeleteSize += uint64(n + len(key))
+	if n := uint64(len(key)); n > w.header.MaxKeyLen {
+		w.header.MaxKeyLen = n
+	}
+	return nil
+}
+
+// flush writes pending data and the updated header to the file.
+func (w *logFileWriter) flush() error {
+	if w == nil {
+		return ERROR_LOG_CLOSED
+	}
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+	return w.writeHeader()
+}
+
+// close flushes and closes the file.
+func (w *logFileWriter) close() error {
+	err := w.flush()
+	if e := w.file.Close(); err == nil && e != nil {
+		err = fileError(e)
+	}
+	return err
+}
+
+// putReader appends a key/value pair, streaming size bytes of the value from
+// r. If the value cannot be read, the entry is discarded and the log is
+// restored to its previous state.
+func (w *logFileWriter) putReader(key []byte, r io.Reader, size uint64) error {
+	if w == nil {
+		return ERROR_LOG_CLOSED
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fileError(err)
+	}
+	saved := w.checkpoint()
+
+	var head [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(head[:], uint64(len(key))+1)
+	n += binary.PutUvarint(head[n:], size)
+	if err := w.streamEntry(head[:n], key, r, size); err != nil {
+		if e := w.rollback(saved); e != nil {
+			return e
+		}
+		return err
+	}
+
+	w.header.NumPuts++
+	w.header.PutSize += uint64(n+len(key)) + size
+	if n := uint64(len(key)); n > w.header.MaxKeyLen {
+		w.header.MaxKeyLen = n
+	}
+	if size > w.header.MaxValueLen {
+		w.header.MaxValueLen = size
+	}
+	return nil
+}
+
+// streamEntry writes an entry, with a value of size bytes read from r.
+func (w *logFileWriter) streamEntry(head, key []byte, r io.Reader, size uint64) error {
+	if err := w.beginEntry(uint64(len(head)+len(key)) + size); err != nil {
+		return err
+	}
+	if err := w.write(head); err != nil {
+		return err
+	}
+	if err := w.write(key); err != nil {
+		return err
+	}
+
+	chunk := uint64(64 * KiB)
+	if size < chunk {
+		chunk = size
+	}
+	buf := make([]byte, chunk)
+	for size > 0 {
+		if size < uint64(len(buf)) {
+			buf = buf[:size]
+		}
+		if _, err := io.ReadFull(r, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ERROR_UNEXPECTED_EOF
+		} else if err != nil {
+			return err
+		}
+		if err := w.write(buf); err != nil {
+			return err
+		}
+		size -= uint64(len(buf))
+	}
+	return w.endEntry()
+}
+
+// logFileWriterState is a checkpoint of the state of a logFileWriter
+type logFileWriterState struct {
+	header       LogHeader
+	block        []byte
+	blockEntries uint32
+}
+
+// checkpoint captures the current state, buffered data must be flushed
+// to the file beforehand.
+func (w *logFileWriter) checkpoint() *logFileWriterState {
+	return &logFileWriterState{
+		header:       w.header,
+		block:        append([]byte(nil), w.block...),
+		blockEntries: w.blockEntries,
+	}
+}
+
+// rollback discards everything that was written since the checkpoint.
+func (w *logFileWriter) rollback(s *logFileWriterState) error {
+	w.buf.Reset(w.file)
+	if err := w.file.Truncate(int64(s.header.DataEnd)); err != nil {
+		return fileError(err)
+	}
+	if _, err := w.file.Seek(int64(s.header.DataEnd), io.SeekStart); err != nil {
+		return fileError(err)
+	}
+
+	w.header = s.header
+	w.block = append(w.block[:0], s.block...)
+	w.blockEntries = s.blockEntries
+	w.spanned = false
+	return nil
+}
+
+// emit writes the parts of an entry.
+func (w *logFileWriter) emit(parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+
+	if err := w.beginEntry(uint64(size)); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := w.write(p); err != nil {
+			return err
+		}
+	}
+	return w.endEntry()
+}
+
+// beginEntry starts an entry of the given size. In compressed logs, entries
+// are added to the pending block, a block is flushed before an entry that
+// doesn't fit (unless empty) and directly after an entry that spans into it,
+// so that every block starts with an entry header.
+func (w *logFileWriter) beginEntry(size uint64) error {
+	if w.header.Compression == COMPRESSION_NONE {
+		return nil
+	}
+
+	if len(w.block) > 0 && uint64(len(w.block))+size > uint64(cap(w.block)) {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+	w.blockEntries++
+	w.spanned = false
+	return nil
+}
+
+// write writes a part of the current entry.
+func (w *logFileWriter) write(p []byte) error {
+	if w.header.Compression == COMPRESSION_NONE {
+		if _, err := w.buf.Write(p); err != nil {
+			return fileError(err)
+		}
+		w.header.DataEnd += uint64(len(p))
+		return nil
+	}
+
+	blockSize := cap(w.block)
+	for len(p) > 0 {
+		n := blockSize - len(w.block)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.block = append(w.block, p[:n]...)
+		p = p[n:]
+
+		if len(w.block) == blockSize {
+			if err := w.flushBlock(); err != nil {
+				return err
+			}
+			w.spanned = true
+		}
+	}
+	return nil
+}
+
+// endEntry completes the current entry.
+func (w *logFileWriter) endEntry() error {
+	if w.spanned {
+		w.spanned = false
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// flushBlock compresses and writes the pending block.
+func (w *logFileWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+
+	data := w.encoder.encode(w.block)
+	var head [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(head[:], uint64(len(data)))
+	if _, err := w.buf.Write(head[:n]); err != nil {
+		return fileError(err)
+	}
+	if _, err := w.buf.Write(data); err != nil {
+		return fileError(err)
+	}
+
+	w.header.DataEnd += uint64(n + len(data))
+	if w.blockEntries > w.header.MaxEntriesPerBlock {
+		w.header.MaxEntriesPerBlock = w.blockEntries
+	}
+	w.block = w.block[:0]
+	w.blockEntries = 0
+	return nil
+}
+
+// writeHeader flushes buffered data and re-writes the header.
+func (w *logFileWriter) writeHeader() error {
+	if err := w.buf.Flush(); err != nil {
+		return fileError(err)
+	}
+	if _, err := w.file.WriteAt(encodeLogHeader(&w.header), 0); err != nil {
+		return fileError(err)
+	}
+	return nil
+}
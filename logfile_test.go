@@ -0,0 +1,151 @@
+package sparkey
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("logFile", func() {
+
+	var readAll = func(log *logFile) []string {
+		iter, err := newLogCursor(log)
+		Expect(err).NotTo(HaveOccurred())
+
+		var entries []string
+		for {
+			Expect(iter.next()).To(Succeed())
+			if iter.state != ITERATOR_ACTIVE {
+				break
+			}
+
+			key := make([]byte, iter.keyLen)
+			Expect(fillChunks(key, iter.keyChunk)).To(Equal(len(key)))
+			val := make([]byte, iter.valueLen)
+			Expect(fillChunks(val, iter.valueChunk)).To(Equal(len(val)))
+			entries = append(entries, fmt.Sprintf("%d:%s:%d", iter.typ, key, len(val)))
+		}
+		return entries
+	}
+
+	for _, opts := range []*Options{nil, {Compression: COMPRESSION_SNAPPY, CompressionBlockSize: 1024}, {Compression: COMPRESSION_ZSTD, CompressionBlockSize: 1024, CompressionLevel: 19}} {
+		opts := opts
+
+		It(fmt.Sprintf("should read logs (%+v)", opts), func() {
+			fname := filepath.Join(testDir, "test.spl")
+			w, err := CreateLogWriter(fname, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Put([]byte("xk"), []byte("short"))).To(Succeed())
+			Expect(w.Put([]byte("zk"), []byte(veryLongString))).To(Succeed())
+			Expect(w.Delete([]byte("xk"))).To(Succeed())
+			Expect(w.Close()).To(Succeed())
+
+			log, err := openLogFile(fname)
+			Expect(err).NotTo(HaveOccurred())
+			defer log.close()
+
+			Expect(log.header.NumPuts).To(Equal(uint64(2)))
+			Expect(log.header.Compression).To(Equal(opts.GetCompression()))
+			Expect(readAll(log)).To(Equal([]string{"0:xk:5", "0:zk:8000", "1:xk:0"}))
+		})
+
+		It(fmt.Sprintf("should write logs (%+v)", opts), func() {
+			fname := filepath.Join(testDir, "test.spl")
+			w, err := createLogFileWriter(fname, opts.GetCompression(), opts.GetCompressionBlockSize(), opts.GetCompressionLevel())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.put([]byte("xk"), []byte("short"))).To(Succeed())
+			Expect(w.put([]byte("zk"), []byte(veryLongString))).To(Succeed())
+			Expect(w.close()).To(Succeed())
+
+			w, err = appendLogFileWriter(fname)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.delete([]byte("xk"))).To(Succeed())
+			Expect(w.close()).To(Succeed())
+
+			reader, err := OpenLogReader(fname)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+			Expect(reader.MaxValueLen()).To(Equal(uint64(8000)))
+
+			iter, err := reader.Iterator()
+			Expect(err).NotTo(HaveOccurred())
+			defer iter.Close()
+
+			var keys []string
+			for iter.Next(); iter.Valid(); iter.Next() {
+				key, err := iter.Key()
+				Expect(err).NotTo(HaveOccurred())
+				keys = append(keys, string(key))
+			}
+			Expect(iter.Err()).NotTo(HaveOccurred())
+			Expect(keys).To(Equal([]string{"xk", "zk", "xk"}))
+		})
+
+		It(fmt.Sprintf("should stream values (%+v)", opts), func() {
+			fname := filepath.Join(testDir, "test.spl")
+			w, err := createLogFileWriter(fname, opts.GetCompression(), opts.GetCompressionBlockSize(), opts.GetCompressionLevel())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.put([]byte("xk"), []byte("short"))).To(Succeed())
+			Expect(w.putReader([]byte("zk"), strings.NewReader(veryLongString), 8000)).To(Succeed())
+			Expect(w.putReader([]byte("yk"), strings.NewReader(veryLongString), 9000)).To(Equal(ERROR_UNEXPECTED_EOF))
+			Expect(w.put([]byte("yk"), []byte("short"))).To(Succeed())
+			Expect(w.close()).To(Succeed())
+
+			log, err := openLogFile(fname)
+			Expect(err).NotTo(HaveOccurred())
+			defer log.close()
+
+			Expect(log.header.NumPuts).To(Equal(uint64(3)))
+			Expect(log.header.MaxValueLen).To(Equal(uint64(8000)))
+			Expect(readAll(log)).To(Equal([]string{"0:xk:5", "0:zk:8000", "0:yk:5"}))
+		})
+	}
+
+	It("should locate compressed blocks on demand", func() {
+		fname := filepath.Join(testDir, "test.spl")
+		w, err := createLogFileWriter(fname, COMPRESSION_SNAPPY, 1024, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.put([]byte("xk"), []byte("short"))).To(Succeed())
+		Expect(w.put([]byte("zk"), []byte(veryLongString))).To(Succeed())
+		Expect(w.close()).To(Succeed())
+
+		log, err := openLogFile(fname)
+		Expect(err).NotTo(HaveOccurred())
+		last := uint64(logHeaderSize)
+		for pos := last; pos < log.header.DataEnd; {
+			_, next, err := log.readBlock(pos)
+			Expect(err).NotTo(HaveOccurred())
+			last, pos = pos, next
+		}
+		log.close()
+
+		// corrupt the length of the last block
+		f, err := os.OpenFile(fname, os.O_RDWR, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteAt([]byte{0x7f}, int64(last))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		log, err = openLogFile(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer log.close()
+
+		iter, err := newLogCursor(log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(iter.next()).To(Succeed())
+		Expect(iter.appendKey(nil)).To(Equal([]byte("xk")))
+		Expect(iter.next()).To(Equal(ERROR_LOG_HEADER_CORRUPT))
+	})
+
+	It("should reject invalid files", func() {
+		_, err := openLogFile(filepath.Join(testDir, "missing.spl"))
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+		_, err = createLogFileWriter(filepath.Join(testDir, "test.spl"), COMPRESSION_SNAPPY, 1, 0)
+		Expect(err).To(Equal(ERROR_INVALID_COMPRESSION_BLOCK_SIZE))
+	})
+
+})
@@ -0,0 +1,215 @@
+package sparkey
+
+import "io"
+
+/* MemWriter */
+
+// MemWriter writes a log and builds its hash entirely in memory, without
+// touching the filesystem. The results can be opened with OpenMemReader or
+// written to disk and opened like any other hash/log pair.
+// Writers are not threadsafe.
+type MemWriter struct {
+	log  *logFileWriter
+	conf *config
+}
+
+// NewMemWriter creates a new in-memory writer. Supported options are
+// WithCompression, WithBlockSize, WithCompressionLevel, WithMaxKeyLen,
+// WithHashSize and WithHashSeed.
+func NewMemWriter(opts ...Option) (*MemWriter, error) {
+	conf := newConfig(opts)
+	if err := conf.check(memWriterOptions); err != nil {
+		return nil, err
+	}
+	compression, blockSize := conf.GetCompression(), conf.GetCompressionBlockSize()
+	if err := checkCompression(compression, blockSize); err != nil {
+		return nil, err
+	}
+
+	log, err := newLogFileWriter(new(memFile), compression, blockSize, conf.GetCompressionLevel())
+	if err != nil {
+		return nil, err
+	}
+	return &MemWriter{log: log, conf: conf}, nil
+}
+
+// Put appends a key/value pair to the log
+func (w *MemWriter) Put(key, value []byte) error {
+	if w.conf.maxKeyLen > 0 && uint64(len(key)) > w.conf.maxKeyLen {
+		return ErrKeyTooLong
+	}
+	return w.log.put(key, value)
+}
+
+// Delete appends a delete operation for a key to the log
+func (w *MemWriter) Delete(key []byte) error {
+	if w.conf.maxKeyLen > 0 && uint64(len(key)) > w.conf.maxKeyLen {
+		return ErrKeyTooLong
+	}
+	return w.log.delete(key)
+}
+
+// Bytes returns the contents of the log and a hash built from it. The
+// returned slices are copies, the writer can continue to append afterwards.
+func (w *MemWriter) Bytes() (logBytes, hashBytes []byte, err error) {
+	if err := w.log.flush(); err != nil {
+		return nil, nil, err
+	}
+	logBytes = append([]byte(nil), w.log.file.(*memFile).data...)
+
+	log, err := newLogFile(logBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, table, err := buildHashFile(log, nil, w.conf.hashSize, w.conf.seed(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logBytes, append(encodeHashHeader(header), table...), nil
+}
+
+/* MemReader */
+
+// MemReader reads a hash/log pair from byte slices. Readers are safe for
+// concurrent use.
+type MemReader struct {
+	hash *hashFile
+}
+
+// OpenMemReader opens a hash/log pair from the contents of the log and
+// hash files. The slices must not be modified while the reader is in use.
+func OpenMemReader(logBytes, hashBytes []byte) (*MemReader, error) {
+	hash, err := newHashFile(hashBytes)
+	if err != nil {
+		return nil, err
+	}
+	log, err := newLogFile(logBytes)
+	if err != nil {
+		return nil, err
+	} else if log.header.FileIdentifier != hash.header.FileIdentifier {
+		return nil, ERROR_FILE_IDENTIFIER_MISMATCH
+	}
+	hash.log = log
+	return &MemReader{hash: hash}, nil
+}
+
+// Header returns the hash header
+func (r *MemReader) Header() HashHeader { return *r.hash.header }
+
+// LogHeader returns the log header
+func (r *MemReader) LogHeader() LogHeader { return *r.hash.log.header }
+
+// NumEntries returns the number of live keys
+func (r *MemReader) NumEntries() uint64 { return r.hash.header.NumEntries }
+
+// Len returns the number of live keys, see NumEntries.
+func (r *MemReader) Len() int { return int(r.hash.header.NumEntries) }
+
+// Get retrieves the value of a key. It returns nil when a key doesn't exist.
+func (r *MemReader) Get(key []byte) ([]byte, error) {
+	c, err := r.seek(key)
+	if err != nil || c.state != ITERATOR_ACTIVE {
+		return nil, err
+	}
+	return readValue(c)
+}
+
+// Exists returns true if a live entry exists for the given key.
+func (r *MemReader) Exists(key []byte) (bool, error) {
+	c, err := r.seek(key)
+	if err != nil {
+		return false, err
+	}
+	return c.state == ITERATOR_ACTIVE, nil
+}
+
+// ForEach calls fn with every live key/value pair, in log order. Iteration
+// stops at the first error returned by fn. The slices passed to fn are
+// only valid for the duration of the call.
+func (r *MemReader) ForEach(fn func(key, value []byte) error) error {
+	c, err := newLogCursor(r.hash.log)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := r.hash.nextLive(c); err != nil {
+			return err
+		} else if c.state != ITERATOR_ACTIVE {
+			return nil
+		}
+
+		key, err := c.appendKey(make([]byte, 0, c.keyLen))
+		if err != nil {
+			return err
+		}
+		val, err := readValue(c)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, val); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the reader. This is a failsafe operation.
+func (r *MemReader) Close() {
+	r.hash.close()
+}
+
+// seek returns a cursor positioned on the value of key
+func (r *MemReader) seek(key []byte) (*logCursor, error) {
+	return r.hash.lookup(key)
+}
+
+// readValue reads the full value at the cursor
+func readValue(c *logCursor) ([]byte, error) {
+	val := make([]byte, c.valueLen)
+	if _, err := fillChunks(val, c.valueChunk); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+/* memFile */
+
+// memFile is a growable in-memory file, it implements writableFile.
+type memFile struct {
+	data []byte
+	pos  int64
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	n, err := f.WriteAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if size < int64(len(f.data)) {
+		f.data = f.data[:size]
+	}
+	return nil
+}
+
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
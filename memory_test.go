@@ -0,0 +1,168 @@
+package sparkey
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MemWriter", func() {
+	var subject *MemWriter
+
+	BeforeEach(func() {
+		var err error
+		subject, err = NewMemWriter()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.Put([]byte("xk"), []byte("short"))).To(Succeed())
+		Expect(subject.Put([]byte("yk"), []byte("longvalue"))).To(Succeed())
+		Expect(subject.Put([]byte("zk"), []byte(veryLongString))).To(Succeed())
+		Expect(subject.Delete([]byte("yk"))).To(Succeed())
+	})
+
+	It("should validate options", func() {
+		_, err := NewMemWriter(WithCompression(CompressionType(9)))
+		Expect(err).To(Equal(ERROR_INVALID_COMPRESSION_TYPE))
+		_, err = NewMemWriter(WithCompression(COMPRESSION_SNAPPY), WithBlockSize(1))
+		Expect(err).To(Equal(ERROR_INVALID_COMPRESSION_BLOCK_SIZE))
+
+		w, err := NewMemWriter(WithMaxKeyLen(2))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Put([]byte("k22"), nil)).To(Equal(ErrKeyTooLong))
+		Expect(w.Delete([]byte("k22"))).To(Equal(ErrKeyTooLong))
+	})
+
+	It("should produce files readable from disk", func() {
+		logBytes, hashBytes, err := subject.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+
+		fname := filepath.Join(testDir, "mem")
+		Expect(ioutil.WriteFile(fname+".spl", logBytes, 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(fname+".spi", hashBytes, 0644)).To(Succeed())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.NumEntries()).To(Equal(uint64(2)))
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(reader.Get([]byte("yk"))).To(BeNil())
+		Expect(reader.Get([]byte("zk"))).To(Equal([]byte(veryLongString)))
+	})
+
+	It("should continue to append", func() {
+		logBytes, _, err := subject.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(subject.Put([]byte("ak"), []byte("appended"))).To(Succeed())
+		more, hashBytes, err := subject.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(more)).To(BeNumerically(">", len(logBytes)))
+
+		reader, err := OpenMemReader(more, hashBytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reader.Len()).To(Equal(3))
+		Expect(reader.LogHeader().NumPuts).To(Equal(uint64(4)))
+	})
+
+})
+
+var _ = Describe("MemReader", func() {
+
+	var openMem = func(opts ...Option) *MemReader {
+		w, err := NewMemWriter(opts...)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 100; i++ {
+			Expect(w.Put([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprintf("v%d", i)))).To(Succeed())
+		}
+		Expect(w.Put([]byte("empty"), nil)).To(Succeed())
+		for i := 0; i < 100; i += 2 {
+			Expect(w.Delete([]byte(fmt.Sprintf("k%03d", i)))).To(Succeed())
+		}
+
+		logBytes, hashBytes, err := w.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+		r, err := OpenMemReader(logBytes, hashBytes)
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	for _, opts := range [][]Option{nil, {WithCompression(COMPRESSION_SNAPPY), WithBlockSize(64)}, {WithHashSize(HASH_SIZE_32BIT)}} {
+		opts := opts
+
+		It(fmt.Sprintf("should read (options: %d)", len(opts)), func() {
+			subject := openMem(opts...)
+			defer subject.Close()
+
+			Expect(subject.NumEntries()).To(Equal(uint64(51)))
+			Expect(subject.Header().NumPuts).To(Equal(uint64(101)))
+
+			Expect(subject.Get([]byte("k001"))).To(Equal([]byte("v1")))
+			Expect(subject.Get([]byte("k002"))).To(BeNil())
+			Expect(subject.Get([]byte("empty"))).To(Equal([]byte{}))
+			Expect(subject.Get([]byte("missing"))).To(BeNil())
+			Expect(subject.Exists([]byte("k099"))).To(BeTrue())
+			Expect(subject.Exists([]byte("k098"))).To(BeFalse())
+
+			var keys []string
+			Expect(subject.ForEach(func(key, value []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			})).To(Succeed())
+			Expect(keys).To(HaveLen(51))
+			Expect(keys[0]).To(Equal("k001"))
+			Expect(keys[50]).To(Equal("empty"))
+		})
+	}
+
+	It("should stop iterating on errors", func() {
+		subject := openMem()
+		defer subject.Close()
+
+		n := 0
+		err := subject.ForEach(func(key, value []byte) error {
+			if n++; n == 3 {
+				return errors.New("stop")
+			}
+			return nil
+		})
+		Expect(err).To(MatchError("stop"))
+		Expect(n).To(Equal(3))
+	})
+
+	It("should fail on bad input", func() {
+		a, err := NewMemWriter()
+		Expect(err).NotTo(HaveOccurred())
+		b, err := NewMemWriter()
+		Expect(err).NotTo(HaveOccurred())
+
+		logBytes, _, err := a.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+		_, hashBytes, err := b.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = OpenMemReader(logBytes, hashBytes)
+		Expect(err).To(Equal(ERROR_FILE_IDENTIFIER_MISMATCH))
+		_, err = OpenMemReader(logBytes[:10], hashBytes)
+		Expect(err).To(Equal(ERROR_LOG_TOO_SMALL))
+		_, err = OpenMemReader(logBytes, logBytes)
+		Expect(err).To(Equal(ERROR_HASH_TOO_SMALL))
+		_, err = OpenMemReader(hashBytes, hashBytes)
+		Expect(err).To(Equal(ERROR_WRONG_LOG_MAGIC_NUMBER))
+	})
+
+	It("should fail when closed", func() {
+		subject := openMem()
+		subject.Close()
+		subject.Close()
+
+		_, err := subject.Get([]byte("k001"))
+		Expect(err).To(Equal(ERROR_HASH_CLOSED))
+		_, err = subject.Exists([]byte("k001"))
+		Expect(err).To(Equal(ERROR_HASH_CLOSED))
+	})
+
+})
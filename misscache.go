@@ -0,0 +1,83 @@
+package sparkey
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// missCacheShards is the maximum number of independently locked shards of
+// a missCache.
+const missCacheShards = 64
+
+// missCache is a small cache of keys which are known to be missing, see
+// WithNegativeCache. As the files of a reader never change, the cache is
+// only invalidated by opening a new reader.
+//
+// Keys are stored as 64-bit hashes in fixed-size, direct-mapped tables, so
+// that memory use does not depend on the length of keys and adding a key
+// simply evicts the one in its slot. The tables are sharded to reduce lock
+// contention on the read path. A key which is present but shares its hash
+// with a cached missing key is reported as missing, with 64-bit hashes and
+// a random seed this is practically impossible.
+type missCache struct {
+	ttl    time.Duration
+	seed   uint32
+	shards []missShard
+}
+
+type missShard struct {
+	mu    sync.Mutex
+	slots []missSlot
+}
+
+type missSlot struct {
+	hash    uint64
+	expires int64 // in unix nanoseconds, 0 for empty slots
+}
+
+func newMissCache(size int, ttl time.Duration) *missCache {
+	if size < 1 {
+		size = 1
+	}
+	shards := size
+	if shards > missCacheShards {
+		shards = missCacheShards
+	}
+
+	c := &missCache{ttl: ttl, seed: rand.Uint32(), shards: make([]missShard, shards)}
+	for i := range c.shards {
+		c.shards[i].slots = make([]missSlot, (size+shards-1)/shards)
+	}
+	return c
+}
+
+// locate returns the hash of key, its shard and its slot within the shard.
+func (c *missCache) locate(key []byte) (uint64, *missShard, int) {
+	hash := murmur64(key, c.seed)
+	shard := &c.shards[hash%uint64(len(c.shards))]
+	slot := (hash / uint64(len(c.shards))) % uint64(len(shard.slots))
+	return hash, shard, int(slot)
+}
+
+// contains returns true if key is known to be missing.
+func (c *missCache) contains(key []byte) bool {
+	hash, shard, i := c.locate(key)
+	now := time.Now().UnixNano()
+
+	shard.mu.Lock()
+	slot := shard.slots[i]
+	shard.mu.Unlock()
+
+	return slot.hash == hash && slot.expires > now
+}
+
+// add records key as missing, evicting the key in its slot.
+func (c *missCache) add(key []byte) {
+	hash, shard, i := c.locate(key)
+	expires := time.Now().Add(c.ttl).UnixNano()
+
+	shard.mu.Lock()
+	shard.slots[i] = missSlot{hash: hash, expires: expires}
+	shard.mu.Unlock()
+}
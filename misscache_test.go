@@ -0,0 +1,70 @@
+package sparkey
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("missCache", func() {
+
+	It("should expire keys", func() {
+		subject := newMissCache(10, 10*time.Millisecond)
+		subject.add([]byte("k1"))
+		Expect(subject.contains([]byte("k1"))).To(BeTrue())
+		Expect(subject.contains([]byte("k2"))).To(BeFalse())
+
+		time.Sleep(20 * time.Millisecond)
+		Expect(subject.contains([]byte("k1"))).To(BeFalse())
+	})
+
+	It("should limit the size", func() {
+		subject := newMissCache(2, time.Minute)
+		Expect(subject.shards).To(HaveLen(2))
+		Expect(subject.shards[0].slots).To(HaveLen(1))
+
+		var contained = func() (n int) {
+			for _, key := range []string{"k1", "k2", "k3"} {
+				if subject.contains([]byte(key)) {
+					n++
+				}
+			}
+			return n
+		}
+
+		subject.add([]byte("k1"))
+		subject.add([]byte("k2"))
+		subject.add([]byte("k2"))
+		subject.add([]byte("k3"))
+		Expect(contained()).To(BeNumerically("<=", 2))
+		Expect(subject.contains([]byte("k3"))).To(BeTrue())
+
+		subject = newMissCache(1000, time.Minute)
+		Expect(subject.shards).To(HaveLen(missCacheShards))
+		Expect(subject.shards[0].slots).To(HaveLen(16))
+	})
+
+	It("should cache misses of readers", func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		reader, err := Open(fname, WithNegativeCache(10, time.Minute))
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(reader.Get([]byte("yk"))).To(BeNil())
+		Expect(reader.misses.contains([]byte("yk"))).To(BeTrue())
+		Expect(reader.misses.contains([]byte("xk"))).To(BeFalse())
+
+		Expect(reader.Get([]byte("yk"))).To(BeNil())
+		Expect(reader.Exists([]byte("yk"))).To(BeFalse())
+		Expect(reader.Exists([]byte("zk"))).To(BeTrue())
+
+		// keys may evict each other, but lookups are never affected
+		Expect(reader.Exists([]byte("missing"))).To(BeFalse())
+		Expect(reader.misses.contains([]byte("missing"))).To(BeTrue())
+		Expect(reader.Get([]byte("yk"))).To(BeNil())
+	})
+
+})
@@ -0,0 +1,38 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package sparkey
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// mapFile reads a file into memory, platforms without mmap support
+// fall back to a plain read.
+func mapFile(name string) ([]byte, func() error, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, fileError(err)
+	}
+	defer file.Close()
+
+	return mapOpenFile(file)
+}
+
+// mapOpenFile reads an open file into memory, from the start.
+func mapOpenFile(file *os.File) ([]byte, func() error, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, fileError(err)
+	} else if info.IsDir() {
+		return nil, nil, ERROR_FILE_IS_DIRECTORY
+	}
+
+	data, err := ioutil.ReadAll(io.NewSectionReader(file, 0, info.Size()))
+	if err != nil {
+		return nil, nil, fileError(err)
+	}
+	return data, func() error { return nil }, nil
+}
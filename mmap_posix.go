@@ -0,0 +1,42 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package sparkey
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps a file read-only into memory. The returned function
+// unmaps it again.
+func mapFile(name string) ([]byte, func() error, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, fileError(err)
+	}
+	defer file.Close()
+
+	return mapOpenFile(file)
+}
+
+// mapOpenFile maps an open file read-only into memory. The file may be
+// closed once it is mapped.
+func mapOpenFile(file *os.File) ([]byte, func() error, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, fileError(err)
+	} else if info.IsDir() {
+		return nil, nil, ERROR_FILE_IS_DIRECTORY
+	} else if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	} else if int64(int(info.Size())) != info.Size() {
+		return nil, nil, ERROR_FILE_TOO_LARGE
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, ERROR_MMAP_FAILED
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
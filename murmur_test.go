@@ -0,0 +1,23 @@
+package sparkey
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MurmurHash3", func() {
+	fox := []byte("The quick brown fox jumps over the lazy dog")
+
+	It("should hash 32bit", func() {
+		Expect(murmur32(nil, 0)).To(Equal(uint32(0)))
+		Expect(murmur32([]byte("hello"), 0)).To(Equal(uint32(0x248bfa47)))
+		Expect(murmur32(fox, 0)).To(Equal(uint32(0x2e4ff723)))
+	})
+
+	It("should hash 64bit", func() {
+		Expect(murmur64(nil, 0)).To(Equal(uint64(0)))
+		Expect(murmur64([]byte("hello"), 0)).To(Equal(uint64(0xcbd8a7b341bd9b02)))
+		Expect(murmur64(fox, 0)).To(Equal(uint64(0xe34bbc7bbc071b6c)))
+	})
+
+})
@@ -0,0 +1,163 @@
+package sparkey
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// OpenPhase is a phase of opening a hash/log pair, see WithOpenTrace.
+type OpenPhase int
+
+const (
+	// OpenStat stats both files. Only performed while tracing.
+	OpenStat OpenPhase = iota
+	// OpenHeader reads and decodes both headers. Only performed while
+	// tracing.
+	OpenHeader
+	// OpenVerify runs the consistency check, if enabled.
+	OpenVerify
+	// OpenMap opens and maps the files.
+	OpenMap
+)
+
+func (p OpenPhase) String() string {
+	switch p {
+	case OpenStat:
+		return "stat"
+	case OpenHeader:
+		return "header"
+	case OpenVerify:
+		return "verify"
+	case OpenMap:
+		return "map"
+	}
+	return "unknown"
+}
+
+// OpenTiming reports the duration of a phase of opening a hash/log pair,
+// see WithOpenTrace.
+type OpenTiming struct {
+	Phase    OpenPhase
+	Duration time.Duration
+	Err      error // error of the phase, if any
+}
+
+// OpenContext is like Open, but gives up once ctx is done and
+// returns the context's error. File system calls cannot be interrupted, an
+// open that is blocked, e.g. on a cold network file system, is left to
+// complete in the background and the reader closed afterwards. Between its
+// phases, the open is aborted early.
+func OpenContext(ctx context.Context, fname string, opts ...Option) (*HashReader, error) {
+	conf := newConfig(opts)
+	if err := conf.check(readerOptions); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		reader *HashReader
+		err    error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		reader, err := openFiles(ctx, LogFileName(fname), HashFileName(fname), conf)
+		done <- result{reader: reader, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.reader, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.reader != nil {
+				res.reader.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// openFiles opens a hash/log pair in phases, checking ctx in between.
+func openFiles(ctx context.Context, logPath, indexPath string, conf *config) (*HashReader, error) {
+	phase := func(p OpenPhase, fn func() error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		err := fn()
+		if conf.openTrace != nil {
+			conf.openTrace(OpenTiming{Phase: p, Duration: time.Since(start), Err: err})
+		}
+		return err
+	}
+
+	if conf.openTrace != nil {
+		if err := phase(OpenStat, func() error { return statFiles(logPath, indexPath) }); err != nil {
+			return nil, err
+		}
+		if err := phase(OpenHeader, func() error { return readHeaders(logPath, indexPath) }); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.checkConsistency {
+		err := phase(OpenVerify, func() error {
+			report, err := CheckConsistency(logPath, indexPath)
+			if err != nil {
+				return err
+			} else if !report.OK() {
+				return ErrInconsistent
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var reader *HashReader
+	err := phase(OpenMap, func() (err error) {
+		if reader, err = OpenCustomHashReader(indexPath, logPath); err != nil {
+			return err
+		}
+		if conf.faultRecovery {
+			if reader.guarded, err = openHashFile(indexPath, logPath); err != nil {
+				reader.Close()
+				return err
+			}
+		}
+		if conf.negativeCacheSize > 0 {
+			reader.misses = newMissCache(conf.negativeCacheSize, conf.negativeCacheTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the map phase may have completed after ctx was done
+	if err := ctx.Err(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+func statFiles(names ...string) error {
+	for _, name := range names {
+		if _, err := os.Stat(name); err != nil {
+			return fileError(err)
+		}
+	}
+	return nil
+}
+
+func readHeaders(logPath, indexPath string) error {
+	if _, err := readLogHeader(logPath); err != nil {
+		return err
+	}
+	_, err := readHashHeader(indexPath)
+	return err
+}
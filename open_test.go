@@ -0,0 +1,60 @@
+package sparkey
+
+import (
+	"context"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenContext", func() {
+	var fname string
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should open readers", func() {
+		reader, err := OpenContext(context.Background(), fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(reader.Get([]byte("xk"))).To(Equal([]byte("short")))
+	})
+
+	It("should fail when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := OpenContext(ctx, fname)
+		Expect(err).To(Equal(context.Canceled))
+	})
+
+	It("should trace phases", func() {
+		var phases []string
+		reader, err := OpenContext(context.Background(), fname, WithConsistencyCheck(true), WithOpenTrace(func(t OpenTiming) {
+			Expect(t.Err).NotTo(HaveOccurred())
+			Expect(t.Duration).To(BeNumerically(">=", 0))
+			phases = append(phases, t.Phase.String())
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(phases).To(Equal([]string{"stat", "header", "verify", "map"}))
+	})
+
+	It("should trace failed phases", func() {
+		var timings []OpenTiming
+		_, err := Open(filepath.Join(testDir, "missing"), WithOpenTrace(func(t OpenTiming) {
+			timings = append(timings, t)
+		}))
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+		Expect(timings).To(HaveLen(1))
+		Expect(timings[0].Phase).To(Equal(OpenStat))
+		Expect(timings[0].Err).To(Equal(ERROR_FILE_NOT_FOUND))
+	})
+
+})
@@ -0,0 +1,299 @@
+package sparkey
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrOptionUnsupported is returned when an option is passed to a function
+// which does not support it.
+var ErrOptionUnsupported = errors.New("sparkey: option not supported")
+
+// Option configures NewLogWriter, AppendLogWriter, NewAtomicWriter,
+// NewMemWriter, Open, NewReloadingReader, OpenFiles, OpenContext,
+// OpenIsolated, BuildHashFile, Reencode and Advise. Each function lists the
+// options it supports, others are rejected with ErrOptionUnsupported.
+type Option func(*config)
+
+// optionSet is a set of options, by kind.
+type optionSet uint32
+
+const (
+	optCompression optionSet = 1 << iota
+	optBlockSize
+	optCompressionLevel
+	optHashSize
+	optHashSeed
+	optProgress
+	optMaxKeyLen
+	optSyncOnFlush
+	optDuplicatePolicy
+	optMergeFunc
+	optFaultRecovery
+	optNegativeCache
+	optConsistencyCheck
+	optOpenTrace
+	optReloadInterval
+	optCanaryKeys
+	optReloadHook
+	optIsolatedWorker
+	optCompactionRatios
+	optMinSavings
+	optRebuildThroughput
+)
+
+var optionNames = []string{
+	"WithCompression",
+	"WithBlockSize",
+	"WithCompressionLevel",
+	"WithHashSize",
+	"WithHashSeed",
+	"WithProgress",
+	"WithMaxKeyLen",
+	"WithSyncOnFlush",
+	"WithDuplicatePolicy",
+	"WithMergeFunc",
+	"WithFaultRecovery",
+	"WithNegativeCache",
+	"WithConsistencyCheck",
+	"WithOpenTrace",
+	"WithReloadInterval",
+	"WithCanaryKeys",
+	"WithReloadHook",
+	"WithIsolatedWorker",
+	"WithCompactionRatios",
+	"WithMinSavings",
+	"WithRebuildThroughput",
+}
+
+// Options supported by the functions that accept them
+const (
+	appendWriterOptions = optMaxKeyLen | optSyncOnFlush | optDuplicatePolicy | optMergeFunc
+	logWriterOptions    = optCompression | optBlockSize | optCompressionLevel | appendWriterOptions
+	hashOptions         = optHashSize | optHashSeed | optProgress
+	memWriterOptions    = optCompression | optBlockSize | optCompressionLevel | optMaxKeyLen | optHashSize | optHashSeed
+	readerOptions       = optConsistencyCheck | optFaultRecovery | optNegativeCache | optOpenTrace
+	reloadOptions       = readerOptions | optReloadInterval | optCanaryKeys | optReloadHook
+	reencodeOptions     = optCompression | optBlockSize | optCompressionLevel | optMaxKeyLen | optSyncOnFlush | optHashSize
+	adviseOptions       = optCompactionRatios | optMinSavings | optRebuildThroughput
+)
+
+// option creates an Option of the given kind.
+func option(kind optionSet, fn func(*config)) Option {
+	return func(c *config) {
+		fn(c)
+		c.set |= kind
+	}
+}
+
+type config struct {
+	Options
+	set               optionSet // kinds of the applied options
+	hashSize          HashSize
+	hashSeed          uint32
+	fixedSeed         bool
+	progress          func(HashProgress)
+	maxKeyLen         uint64
+	syncOnFlush       bool
+	checkConsistency  bool
+	faultRecovery     bool
+	reloadInterval    time.Duration
+	duplicatePolicy   DuplicatePolicy
+	mergeFunc         MergeFunc
+	openTrace         func(OpenTiming)
+	negativeCacheSize int
+	negativeCacheTTL  time.Duration
+	canaryKeys        [][]byte
+	reloadHook        func(error)
+	isolatedWorker    string
+	adviseNowRatio    float64
+	adviseLaterRatio  float64
+	adviseMinSavings  uint64
+	adviseThroughput  uint64
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		reloadInterval:   defaultReloadInterval,
+		adviseNowRatio:   defaultAdviseNowRatio,
+		adviseLaterRatio: defaultAdviseLaterRatio,
+		adviseMinSavings: defaultAdviseMinSavings,
+		adviseThroughput: defaultAdviseThroughput,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// check returns an error if options other than the supported ones were
+// applied.
+func (c *config) check(supported optionSet) error {
+	for i, name := range optionNames {
+		if kind := optionSet(1) << i; c.set&kind != 0 && supported&kind == 0 {
+			return fmt.Errorf("%w: %s", ErrOptionUnsupported, name)
+		}
+	}
+	return nil
+}
+
+// WithCompression sets the compression type of new logs. Default: COMPRESSION_NONE
+func WithCompression(compression CompressionType) Option {
+	return option(optCompression, func(c *config) { c.Compression = compression })
+}
+
+// WithBlockSize sets the compression block size of new logs. Only relevant
+// if compression is enabled. Default: 4k
+func WithBlockSize(size int) Option {
+	return option(optBlockSize, func(c *config) { c.CompressionBlockSize = size })
+}
+
+// WithCompressionLevel sets the zstd compression level of new logs, using
+// the levels of the reference implementation. Only relevant for
+// COMPRESSION_ZSTD. Levels are only supported by the purego build and by
+// MemWriter, file writers of the default cgo build fail with
+// ErrCompressionLevelUnsupported. Default: 0 (library default)
+func WithCompressionLevel(level int) Option {
+	return option(optCompressionLevel, func(c *config) { c.CompressionLevel = level })
+}
+
+// WithHashSize sets the size of hash values. Default: HASH_SIZE_AUTO
+func WithHashSize(size HashSize) Option {
+	return option(optHashSize, func(c *config) { c.hashSize = size })
+}
+
+// WithHashSeed sets the seed of the hash function, which makes hash files
+// reproducible. Default: random
+func WithHashSeed(seed uint32) Option {
+	return option(optHashSeed, func(c *config) { c.hashSeed, c.fixedSeed = seed, true })
+}
+
+// seed returns the configured hash seed or a random one
+func (c *config) seed() uint32 {
+	if c.fixedSeed {
+		return c.hashSeed
+	}
+	return rand.Uint32()
+}
+
+// WithProgress registers a callback which is invoked periodically while a hash
+// is built, see BuildHashFileContext. Default: none
+func WithProgress(fn func(HashProgress)) Option {
+	return option(optProgress, func(c *config) { c.progress = fn })
+}
+
+// WithMaxKeyLen limits the length of keys accepted by writers, longer keys
+// are rejected with ErrKeyTooLong. Default: 0 (unlimited)
+func WithMaxKeyLen(n uint64) Option {
+	return option(optMaxKeyLen, func(c *config) { c.maxKeyLen = n })
+}
+
+// WithSyncOnFlush makes writers sync the log file to disk on every Flush and
+// on Close. Default: false
+func WithSyncOnFlush(enable bool) Option {
+	return option(optSyncOnFlush, func(c *config) { c.syncOnFlush = enable })
+}
+
+// WithDuplicatePolicy sets how writers handle keys that are put more than
+// once. Policies other than DuplicateLastWins keep track of all keys put
+// through a writer in memory, a delete forgets a key. Memory is not bounded,
+// it grows with the number and length of distinct keys, roughly by the key
+// length plus 50 bytes per key, and is held for the lifetime of the writer.
+// Default: DuplicateLastWins
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return option(optDuplicatePolicy, func(c *config) { c.duplicatePolicy = policy })
+}
+
+// WithMergeFunc makes writers merge the values of keys that are put more
+// than once with fn, and write the merged value instead. It overrides the
+// duplicate policy. The latest value of each key is kept in memory in
+// addition to the key, see WithDuplicatePolicy, so memory grows with the
+// total size of all live entries. It is only suitable for logs which fit
+// into memory. Default: none
+func WithMergeFunc(fn MergeFunc) Option {
+	return option(optMergeFunc, func(c *config) { c.mergeFunc = fn })
+}
+
+// WithFaultRecovery makes readers perform lookups through Get, GetMulti,
+// GetMultiFunc and Exists in a guarded mode, which converts faults on the
+// mapped files into ErrFault, see HashReader. Default: false
+func WithFaultRecovery(enable bool) Option {
+	return option(optFaultRecovery, func(c *config) { c.faultRecovery = enable })
+}
+
+// WithNegativeCache makes readers remember up to size keys which were found
+// to be missing by Get or Exists for ttl, so that repeated lookups of the
+// same missing keys don't have to probe the files. Keys are stored as 64-bit
+// hashes, the cache takes 16 bytes per key regardless of key lengths. The
+// cache belongs to a reader, a ReloadingReader starts with an empty one
+// after each reload. Default: none
+func WithNegativeCache(size int, ttl time.Duration) Option {
+	return option(optNegativeCache, func(c *config) { c.negativeCacheSize, c.negativeCacheTTL = size, ttl })
+}
+
+// WithConsistencyCheck makes readers run CheckConsistency before opening
+// and fail with ErrInconsistent if problems are detected. Default: false
+func WithConsistencyCheck(enable bool) Option {
+	return option(optConsistencyCheck, func(c *config) { c.checkConsistency = enable })
+}
+
+// WithOpenTrace registers a callback which is invoked with the duration of
+// each phase while readers are opened, to diagnose slow opens. While
+// tracing, the files are stat'ed and their headers read before they are
+// mapped, so that slow metadata lookups and slow reads can be told apart.
+// Default: none
+func WithOpenTrace(fn func(OpenTiming)) Option {
+	return option(optOpenTrace, func(c *config) { c.openTrace = fn })
+}
+
+// WithReloadInterval sets the interval at which a ReloadingReader checks its
+// files for replacements, 0 disables periodic checks. Default: 1s
+func WithReloadInterval(d time.Duration) Option {
+	return option(optReloadInterval, func(c *config) { c.reloadInterval = d })
+}
+
+// WithCanaryKeys makes a ReloadingReader verify replaced files before it
+// switches to them: each canary key that exists in the current files must
+// also exist in the replacements, otherwise the replacements are rejected
+// with ErrCanaryFailed, which is reported by Reload and Err. Choose keys
+// which are never deleted. Default: none
+func WithCanaryKeys(keys ...[]byte) Option {
+	return option(optCanaryKeys, func(c *config) { c.canaryKeys = keys })
+}
+
+// WithReloadHook sets a function which a ReloadingReader calls with the
+// error of each failed periodic reload, e.g. one that was rejected by a
+// canary key. The function must not block. Default: none
+func WithReloadHook(fn func(error)) Option {
+	return option(optReloadHook, func(c *config) { c.reloadHook = fn })
+}
+
+// WithIsolatedWorker sets the worker binary started by OpenIsolated, either
+// a path or a name that is looked up in PATH. Default: sparkey-worker
+func WithIsolatedWorker(name string) Option {
+	return option(optIsolatedWorker, func(c *config) { c.isolatedWorker = name })
+}
+
+// WithCompactionRatios sets the garbage ratios at which Advise recommends
+// compaction eventually and immediately. Default: 0.2 and 0.5
+func WithCompactionRatios(later, now float64) Option {
+	return option(optCompactionRatios, func(c *config) { c.adviseLaterRatio, c.adviseNowRatio = later, now })
+}
+
+// WithMinSavings sets the minimum number of bytes a compaction must save
+// for Advise to recommend it at all. Default: 1MiB
+func WithMinSavings(bytes uint64) Option {
+	return option(optMinSavings, func(c *config) { c.adviseMinSavings = bytes })
+}
+
+// WithRebuildThroughput sets the expected rebuild throughput, in bytes per
+// second, which Advise uses to estimate the build time. Default: 100MiB/s
+func WithRebuildThroughput(bytesPerSecond uint64) Option {
+	return option(optRebuildThroughput, func(c *config) {
+		if bytesPerSecond > 0 {
+			c.adviseThroughput = bytesPerSecond
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package sparkey
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Option", func() {
+	var fname string
+
+	BeforeEach(func() {
+		fname = filepath.Join(testDir, "test")
+	})
+
+	It("should apply defaults", func() {
+		conf := newConfig(nil)
+		Expect(conf.GetCompression()).To(Equal(COMPRESSION_NONE))
+		Expect(conf.hashSize).To(Equal(HASH_SIZE_AUTO))
+		Expect(conf.maxKeyLen).To(BeZero())
+		Expect(conf.syncOnFlush).To(BeFalse())
+		Expect(conf.checkConsistency).To(BeFalse())
+
+		conf = newConfig([]Option{WithCompression(COMPRESSION_SNAPPY)})
+		Expect(conf.GetCompressionBlockSize()).To(Equal(4 * KiB))
+
+		conf = newConfig([]Option{WithCompression(COMPRESSION_ZSTD), WithCompressionLevel(9)})
+		Expect(conf.GetCompressionBlockSize()).To(Equal(4 * KiB))
+		Expect(conf.GetCompressionLevel()).To(Equal(9))
+
+		conf = newConfig([]Option{WithCompressionLevel(9)})
+		Expect(conf.GetCompressionLevel()).To(BeZero())
+	})
+
+	It("should create and append to logs", func() {
+		writer, err := NewLogWriter(fname, WithCompression(COMPRESSION_SNAPPY), WithBlockSize(128), WithSyncOnFlush(true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.sync).To(BeTrue())
+		Expect(writer.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(writer.Flush()).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		header, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.Compression).To(Equal(COMPRESSION_SNAPPY))
+		Expect(header.CompressionBlockSize).To(Equal(uint32(128)))
+		Expect(header.NumPuts).To(Equal(uint64(1)))
+
+		writer, err = AppendLogWriter(fname, WithSyncOnFlush(true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Put([]byte("k2"), []byte("v2"))).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		header, err = ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.NumPuts).To(Equal(uint64(2)))
+	})
+
+	It("should create zstd compressed logs", func() {
+		writer, err := NewLogWriter(fname, WithCompression(COMPRESSION_ZSTD), WithBlockSize(128))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Put([]byte("k1"), []byte(veryLongString))).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+		Expect(WriteHashFile(fname, HASH_SIZE_AUTO)).To(Succeed())
+
+		reader, err := Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Log().Compression()).To(Equal(COMPRESSION_ZSTD))
+		Expect(reader.Log().CompressionBlockSize()).To(Equal(128))
+		Expect(reader.Get([]byte("k1"))).To(Equal([]byte(veryLongString)))
+	})
+
+	It("should reject compression levels where unsupported", func() {
+		writer, err := NewLogWriter(fname, WithCompression(COMPRESSION_ZSTD), WithCompressionLevel(3))
+		if err != nil {
+			// cgo build
+			Expect(err).To(Equal(ErrCompressionLevelUnsupported))
+			return
+		}
+		Expect(writer.Put([]byte("k1"), []byte(veryLongString))).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		reader, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Compression()).To(Equal(COMPRESSION_ZSTD))
+	})
+
+	It("should limit key lengths", func() {
+		writer, err := NewLogWriter(fname, WithMaxKeyLen(2))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(writer.Put([]byte("k22"), []byte("v2"))).To(Equal(ErrKeyTooLong))
+		Expect(writer.Delete([]byte("k22"))).To(Equal(ErrKeyTooLong))
+		Expect(writer.PutReader([]byte("k22"), strings.NewReader("v2"), 2)).To(Equal(ErrKeyTooLong))
+		Expect(writer.PutBatch([]Entry{
+			{Key: []byte("k3"), Value: []byte("v3")},
+			{Key: []byte("k33"), Value: []byte("v3")},
+		})).To(Equal(ErrKeyTooLong))
+		Expect(writer.Close()).To(Succeed())
+
+		header, err := ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.NumPuts).To(Equal(uint64(1)))
+		Expect(header.MaxKeyLen).To(Equal(uint64(2)))
+
+		writer, err = AppendLogWriter(fname, WithMaxKeyLen(1))
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+		Expect(writer.Put([]byte("k2"), []byte("v2"))).To(Equal(ErrKeyTooLong))
+	})
+
+	It("should build and open hashes", func() {
+		writer, err := NewLogWriter(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Put([]byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		Expect(BuildHashFile(fname, WithHashSize(HASH_SIZE_64BIT))).To(Succeed())
+		header, err := ReadHashHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.HashSize).To(Equal(HASH_SIZE_64BIT))
+
+		reader, err := Open(fname, WithConsistencyCheck(true))
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+		Expect(reader.Get([]byte("k1"))).To(Equal([]byte("v1")))
+	})
+	It("should reject unsupported options", func() {
+		_, err := NewLogWriter(fname, WithNegativeCache(10, time.Minute))
+		Expect(err).To(MatchError(ErrOptionUnsupported))
+		Expect(err).To(MatchError("sparkey: option not supported: WithNegativeCache"))
+
+		_, err = NewMemWriter(WithDuplicatePolicy(DuplicateError))
+		Expect(err).To(MatchError(ErrOptionUnsupported))
+
+		writer, err := NewLogWriter(fname, WithCompression(COMPRESSION_SNAPPY))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Close()).To(Succeed())
+
+		_, err = AppendLogWriter(fname, WithCompression(COMPRESSION_NONE))
+		Expect(err).To(MatchError(ErrOptionUnsupported))
+		Expect(BuildHashFile(fname, WithSyncOnFlush(true))).To(MatchError(ErrOptionUnsupported))
+		Expect(BuildHashFile(fname, WithHashSize(HASH_SIZE_32BIT))).To(Succeed())
+		_, err = Open(fname, WithHashSize(HASH_SIZE_32BIT))
+		Expect(err).To(MatchError(ErrOptionUnsupported))
+		_, err = NewReloadingReader(fname, WithMaxKeyLen(2))
+		Expect(err).To(MatchError(ErrOptionUnsupported))
+		Expect(AppendAndReindex(fname, func(*LogWriter) error { return nil }, WithMaxKeyLen(2), WithHashSize(HASH_SIZE_32BIT))).To(Succeed())
+	})
+})
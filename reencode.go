@@ -0,0 +1,113 @@
+package sparkey
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrSameLog is returned by Reencode when source and destination are the
+// same file, also through different paths or links.
+var ErrSameLog = errors.New("sparkey: cannot re-encode a log into itself")
+
+// Reencode rewrites the log of src into dst, in a single streaming pass, and
+// builds a new hash file for it. All entries are preserved in order, only the
+// encoding changes. The destination is written through an AtomicWriter, so
+// existing files at dst are only replaced on success and nothing is left
+// behind on errors. Supported options are WithCompression, WithBlockSize,
+// WithCompressionLevel, WithMaxKeyLen, WithSyncOnFlush and WithHashSize.
+// WithDuplicatePolicy and WithMergeFunc are rejected, as they would drop
+// entries.
+func Reencode(src, dst string, opts ...Option) error {
+	conf := newConfig(opts)
+	if err := conf.check(reencodeOptions); err != nil {
+		return err
+	}
+	if same, err := sameFile(LogFileName(src), LogFileName(dst)); err != nil {
+		return err
+	} else if same {
+		return ErrSameLog
+	}
+
+	reader, err := OpenLogReader(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := newAtomicWriter(dst, conf)
+	if err != nil {
+		return err
+	}
+	defer writer.Abort()
+
+	if err := reencodeLog(reader, writer.log); err != nil {
+		return err
+	}
+	return writer.Commit()
+}
+
+// sameFile returns true if both names refer to the same existing file.
+func sameFile(a, b string) (bool, error) {
+	ainfo, err := os.Stat(a)
+	if err != nil {
+		return false, fileError(err)
+	}
+	binfo, err := os.Stat(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fileError(err)
+	}
+	return os.SameFile(ainfo, binfo), nil
+}
+
+// reencodeLog copies all entries, small entries are written in batches,
+// large values are streamed.
+func reencodeLog(reader *LogReader, writer *LogWriter) error {
+	iter, err := reader.Iterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	var batch []Entry
+	var size int
+	for iter.Next(); iter.Valid(); iter.Next() {
+		key, err := iter.Key()
+		if err != nil {
+			return err
+		}
+
+		if iter.EntryType() == ENTRY_PUT && iter.ValueLen() > putBatchBufferSize {
+			if err := writer.PutBatch(batch); err != nil {
+				return err
+			}
+			batch, size = batch[:0], 0
+
+			if err := writer.PutReader(key, iter.ValueReader(), int64(iter.ValueLen())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry := Entry{Type: iter.EntryType(), Key: key}
+		if entry.Type == ENTRY_PUT {
+			if entry.Value, err = iter.Value(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, entry)
+		size += len(entry.Key) + len(entry.Value)
+
+		if size >= putBatchBufferSize {
+			if err := writer.PutBatch(batch); err != nil {
+				return err
+			}
+			batch, size = batch[:0], 0
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return writer.PutBatch(batch)
+}
@@ -0,0 +1,114 @@
+package sparkey
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reencode", func() {
+	var src string
+
+	var readKeys = func(fname string) []string {
+		reader, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		iter, err := reader.Iterator()
+		Expect(err).NotTo(HaveOccurred())
+		defer iter.Close()
+
+		var keys []string
+		for iter.Next(); iter.Valid(); iter.Next() {
+			key, err := iter.Key()
+			Expect(err).NotTo(HaveOccurred())
+			keys = append(keys, fmt.Sprintf("%d:%s", iter.EntryType(), key))
+		}
+		Expect(iter.Err()).NotTo(HaveOccurred())
+		return keys
+	}
+
+	BeforeEach(func() {
+		var err error
+		src, err = writeTestHash(testDir, func(w *LogWriter) error {
+			for i := 0; i < 100; i++ {
+				if err := w.Put([]byte(fmt.Sprintf("k%02d", i)), []byte(veryLongString)); err != nil {
+					return err
+				}
+			}
+			if err := w.Put([]byte("big"), []byte(strings.Repeat("x", putBatchBufferSize+1))); err != nil {
+				return err
+			}
+			return w.Delete([]byte("k01"))
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should change compression settings", func() {
+		dst := filepath.Join(testDir, "dst")
+		Expect(Reencode(src, dst, WithCompression(COMPRESSION_SNAPPY), WithBlockSize(1024), WithHashSize(HASH_SIZE_32BIT))).To(Succeed())
+		Expect(readKeys(dst)).To(Equal(readKeys(src)))
+
+		header, err := ReadLogHeader(dst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.Compression).To(Equal(COMPRESSION_SNAPPY))
+		Expect(header.CompressionBlockSize).To(Equal(uint32(1024)))
+
+		hheader, err := ReadHashHeader(dst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hheader.HashSize).To(Equal(HASH_SIZE_32BIT))
+
+		a, err := Open(src)
+		Expect(err).NotTo(HaveOccurred())
+		defer a.Close()
+		b, err := Open(dst)
+		Expect(err).NotTo(HaveOccurred())
+		defer b.Close()
+		Expect(SameContent(a, b)).To(BeTrue())
+		Expect(b.Get([]byte("k01"))).To(BeNil())
+		Expect(b.Get([]byte("big"))).To(HaveLen(putBatchBufferSize + 1))
+
+		// and back
+		back := filepath.Join(testDir, "back")
+		Expect(Reencode(dst, back)).To(Succeed())
+		Expect(readKeys(back)).To(Equal(readKeys(src)))
+	})
+
+	It("should not leave partial files behind", func() {
+		dst := filepath.Join(testDir, "dst")
+		Expect(Reencode(src, dst, WithMaxKeyLen(2))).To(Equal(ErrKeyTooLong))
+
+		files, err := filepath.Glob(filepath.Join(testDir, "*dst*"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(BeEmpty())
+
+		// existing files are kept
+		Expect(Reencode(src, dst)).To(Succeed())
+		Expect(Reencode(src, dst, WithMaxKeyLen(2))).To(Equal(ErrKeyTooLong))
+		Expect(readKeys(dst)).To(Equal(readKeys(src)))
+
+		files, err = filepath.Glob(filepath.Join(testDir, "*dst*"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(ConsistOf(LogFileName(dst), HashFileName(dst)))
+	})
+
+	It("should reject in-place conversions", func() {
+		Expect(Reencode(src, src+".spl")).To(Equal(ErrSameLog))
+
+		Expect(Reencode(src, filepath.Dir(src)+"/./"+filepath.Base(src))).To(Equal(ErrSameLog))
+
+		link := filepath.Join(testDir, "link")
+		Expect(os.Link(LogFileName(src), LogFileName(link))).To(Succeed())
+		Expect(Reencode(link, src)).To(Equal(ErrSameLog))
+	})
+
+	It("should reject options which drop entries", func() {
+		dst := filepath.Join(testDir, "dst")
+		Expect(Reencode(src, dst, WithDuplicatePolicy(DuplicateFirstWins))).To(MatchError(ErrOptionUnsupported))
+		Expect(Reencode(src, dst, WithMergeFunc(func(_, old, new []byte) ([]byte, error) { return new, nil }))).To(MatchError(ErrOptionUnsupported))
+	})
+})
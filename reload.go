@@ -0,0 +1,318 @@
+package sparkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrCanaryFailed is returned by ReloadingReader.Reload when a canary key,
+// see WithCanaryKeys, cannot be found in the replaced files.
+var ErrCanaryFailed = errors.New("sparkey: canary key missing after reload")
+
+// ReloadingReader is a HashReader that follows atomic replacements of its
+// hash/log pair, e.g. by an AtomicWriter. It checks the files periodically
+// and opens the new pair once both files have been replaced. Lookups that
+// are in flight during a reload complete on the old pair, which is closed
+// afterwards. Readers are safe for concurrent use.
+//
+// A failed reload, typically because only one of the files has been
+// replaced yet, leaves the current pair in use and is retried on the next
+// check. The same applies to replacements which fail the verification of
+// canary keys, see WithCanaryKeys. Errors of periodic reloads are reported
+// by Err and to the function set by WithReloadHook.
+type ReloadingReader struct {
+	fname      string
+	conf       *config
+	canaryKeys [][]byte
+	reloadHook func(error)
+
+	mu      sync.RWMutex
+	current *reloadingSnapshot
+	err     error     // result of the last reload
+	checked time.Time // start of the last successful check
+
+	reloadMu sync.Mutex // serialises reloads
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// reloadingSnapshot is a reader together with the stamp of its files and
+// the number of in-flight operations.
+type reloadingSnapshot struct {
+	reader *HashReader
+	stamp  fileStamp
+	active sync.WaitGroup
+}
+
+// NewReloadingReader opens a hash/log pair and starts watching it. Supported
+// options are those of Open, WithReloadInterval, WithCanaryKeys and
+// WithReloadHook.
+func NewReloadingReader(fname string, opts ...Option) (*ReloadingReader, error) {
+	conf := newConfig(opts)
+	if err := conf.check(reloadOptions); err != nil {
+		return nil, err
+	}
+	r := &ReloadingReader{fname: fname, conf: conf, canaryKeys: conf.canaryKeys, reloadHook: conf.reloadHook}
+	checked := time.Now()
+	snap, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	r.current, r.checked = snap, checked
+
+	if interval := conf.reloadInterval; interval > 0 {
+		r.stop, r.done = make(chan struct{}), make(chan struct{})
+		go r.loop(interval)
+	}
+	return r, nil
+}
+
+// View calls fn with the current reader. The reader is not closed while fn
+// is running, it must not be retained or closed by fn. fn must not call
+// Reload or Close.
+func (r *ReloadingReader) View(fn func(*HashReader) error) error {
+	snap, err := r.acquire()
+	if err != nil {
+		return err
+	}
+	defer snap.active.Done()
+
+	return fn(snap.reader)
+}
+
+// Get retrieves the value of a key, see HashReader.Get.
+func (r *ReloadingReader) Get(key []byte) (val []byte, err error) {
+	err = r.View(func(reader *HashReader) (err error) {
+		val, err = reader.Get(key)
+		return
+	})
+	return
+}
+
+// GetFresh is like Get, but guarantees that the files have been checked for
+// replacements within maxStaleness. If the last successful check is older,
+// it triggers a reload and waits for it, or until ctx is done, before
+// answering. Errors of the reload are returned.
+func (r *ReloadingReader) GetFresh(ctx context.Context, key []byte, maxStaleness time.Duration) ([]byte, error) {
+	r.mu.RLock()
+	checked := r.checked
+	r.mu.RUnlock()
+
+	if time.Since(checked) > maxStaleness {
+		reloaded := make(chan error, 1)
+		go func() {
+			_, err := r.Reload()
+			reloaded <- err
+		}()
+
+		select {
+		case err := <-reloaded:
+			if err != nil {
+				return nil, err
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return r.Get(key)
+}
+
+// GetMulti retrieves the values of multiple keys, see HashReader.GetMulti.
+func (r *ReloadingReader) GetMulti(keys [][]byte) (vals [][]byte, err error) {
+	err = r.View(func(reader *HashReader) (err error) {
+		vals, err = reader.GetMulti(keys)
+		return
+	})
+	return
+}
+
+// Exists checks the existence of a key, see HashReader.Exists.
+func (r *ReloadingReader) Exists(key []byte) (ok bool, err error) {
+	err = r.View(func(reader *HashReader) (err error) {
+		ok, err = reader.Exists(key)
+		return
+	})
+	return
+}
+
+// Header returns the header of the current hash file.
+func (r *ReloadingReader) Header() (header HashHeader) {
+	r.View(func(reader *HashReader) error {
+		header = reader.Header()
+		return nil
+	})
+	return
+}
+
+// Err returns the error of the last failed reload, it is reset by the next
+// successful one.
+func (r *ReloadingReader) Err() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.err
+}
+
+// Reload checks the files for replacements immediately and reopens them if
+// necessary. It returns true if a new pair was opened.
+func (r *ReloadingReader) Reload() (bool, error) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+	if current == nil {
+		return false, ERROR_HASH_CLOSED
+	}
+
+	checked := time.Now()
+	stamp, err := stampFiles(r.fname)
+	if err == nil && stamp.equal(current.stamp) {
+		r.mu.Lock()
+		r.checked = checked
+		r.mu.Unlock()
+		return false, nil
+	}
+
+	var snap *reloadingSnapshot
+	if err == nil {
+		snap, err = r.open()
+	}
+	if err == nil {
+		if err = r.verify(current.reader, snap.reader); err != nil {
+			snap.close()
+		}
+	}
+
+	r.mu.Lock()
+	if err == nil {
+		r.current, r.checked = snap, checked
+	}
+	r.err = err
+	r.mu.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+	current.close()
+	return true, nil
+}
+
+// Close stops watching the files and closes the current reader, once all
+// in-flight operations have completed.
+func (r *ReloadingReader) Close() {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+		r.stop = nil
+	}
+
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	r.mu.Lock()
+	current := r.current
+	r.current = nil
+	r.mu.Unlock()
+
+	if current != nil {
+		current.close()
+	}
+}
+
+// acquire returns the current snapshot and registers an operation on it.
+func (r *ReloadingReader) acquire() (*reloadingSnapshot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.current == nil {
+		return nil, ERROR_HASH_CLOSED
+	}
+	r.current.active.Add(1)
+	return r.current, nil
+}
+
+// open stamps and opens the files. The files are stamped first, so that
+// replacements during opening are picked up by the next check.
+func (r *ReloadingReader) open() (*reloadingSnapshot, error) {
+	stamp, err := stampFiles(r.fname)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := openFiles(context.Background(), LogFileName(r.fname), HashFileName(r.fname), r.conf)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingSnapshot{reader: reader, stamp: stamp}, nil
+}
+
+// verify checks that the canary keys which exist in the current files also
+// exist in the replacements.
+func (r *ReloadingReader) verify(current, next *HashReader) error {
+	for _, key := range r.canaryKeys {
+		if ok, err := current.Exists(key); err != nil || !ok {
+			continue
+		}
+		if ok, err := next.Exists(key); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w: %s", ErrCanaryFailed, formatKey(key))
+		}
+	}
+	return nil
+}
+
+func (r *ReloadingReader) loop(interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if _, err := r.Reload(); err != nil && r.reloadHook != nil {
+				r.reloadHook(err)
+			}
+		}
+	}
+}
+
+// close waits for in-flight operations and closes the reader.
+func (s *reloadingSnapshot) close() {
+	s.active.Wait()
+	s.reader.Close()
+}
+
+/* File stamps */
+
+// fileStamp identifies the versions of a hash/log pair.
+type fileStamp struct {
+	log, hash os.FileInfo
+}
+
+func stampFiles(fname string) (fileStamp, error) {
+	log, err := os.Stat(LogFileName(fname))
+	if err != nil {
+		return fileStamp{}, fileError(err)
+	}
+	hash, err := os.Stat(HashFileName(fname))
+	if err != nil {
+		return fileStamp{}, fileError(err)
+	}
+	return fileStamp{log: log, hash: hash}, nil
+}
+
+func (s fileStamp) equal(o fileStamp) bool {
+	return sameFileVersion(s.log, o.log) && sameFileVersion(s.hash, o.hash)
+}
+
+func sameFileVersion(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
@@ -0,0 +1,187 @@
+package sparkey
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReloadingReader", func() {
+	var fname string
+	var subject *ReloadingReader
+
+	replace := func(key, value string) {
+		w, err := NewAtomicWriter(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Put([]byte(key), []byte(value))).To(Succeed())
+		Expect(w.Commit()).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		fname, err = writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		subject, err = NewReloadingReader(fname, WithReloadInterval(0))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should retrieve values", func() {
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(subject.GetMulti([][]byte{[]byte("xk"), []byte("yk")})).To(Equal([][]byte{[]byte("short"), nil}))
+		Expect(subject.Exists([]byte("zk"))).To(BeTrue())
+		Expect(subject.Header().NumEntries).To(Equal(uint64(2)))
+	})
+
+	It("should reload replaced files", func() {
+		Expect(subject.Reload()).To(BeFalse())
+
+		replace("nk", "new")
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(subject.Reload()).To(BeTrue())
+		Expect(subject.Get([]byte("xk"))).To(BeNil())
+		Expect(subject.Get([]byte("nk"))).To(Equal([]byte("new")))
+		Expect(subject.Reload()).To(BeFalse())
+		Expect(subject.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should keep the current files on errors", func() {
+		Expect(os.Remove(fname + ".spi")).To(Succeed())
+		_, err := subject.Reload()
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+		Expect(subject.Err()).To(Equal(ERROR_FILE_NOT_FOUND))
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+
+		replace("nk", "new")
+		Expect(subject.Reload()).To(BeTrue())
+		Expect(subject.Err()).NotTo(HaveOccurred())
+		Expect(subject.Get([]byte("nk"))).To(Equal([]byte("new")))
+	})
+
+	It("should reject replacements which miss canary keys", func() {
+		subject.Close()
+
+		var err error
+		subject, err = NewReloadingReader(fname, WithReloadInterval(0), WithCanaryKeys([]byte("xk"), []byte("missing")))
+		Expect(err).NotTo(HaveOccurred())
+
+		replace("nk", "new")
+		_, err = subject.Reload()
+		Expect(errors.Is(err, ErrCanaryFailed)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(`"xk"`))
+		Expect(subject.Err()).To(Equal(err))
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+		Expect(subject.Get([]byte("nk"))).To(BeNil())
+
+		replace("xk", "new")
+		Expect(subject.Reload()).To(BeTrue())
+		Expect(subject.Err()).NotTo(HaveOccurred())
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("new")))
+	})
+
+	It("should reload stale files before fresh reads", func() {
+		ctx := context.Background()
+
+		replace("nk", "new")
+		Expect(subject.GetFresh(ctx, []byte("nk"), time.Hour)).To(BeNil())
+		Expect(subject.GetFresh(ctx, []byte("nk"), 0)).To(Equal([]byte("new")))
+
+		Expect(os.Remove(fname + ".spi")).To(Succeed())
+		_, err := subject.GetFresh(ctx, []byte("nk"), 0)
+		Expect(err).To(Equal(ERROR_FILE_NOT_FOUND))
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		subject.reloadMu.Lock()
+		_, err = subject.GetFresh(cancelled, []byte("nk"), 0)
+		subject.reloadMu.Unlock()
+		Expect(err).To(Equal(context.Canceled))
+	})
+
+	It("should drain in-flight operations", func() {
+		started, release := make(chan struct{}), make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- subject.View(func(r *HashReader) error {
+				close(started)
+				<-release
+				_, err := r.Get([]byte("xk"))
+				return err
+			})
+		}()
+		<-started
+
+		replace("nk", "new")
+		reloaded := make(chan bool, 1)
+		go func() {
+			ok, _ := subject.Reload()
+			reloaded <- ok
+		}()
+
+		for i := 0; i < 100; i++ {
+			if v, _ := subject.Get([]byte("nk")); v != nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		Expect(subject.Get([]byte("nk"))).To(Equal([]byte("new")))
+		Expect(reloaded).To(BeEmpty())
+
+		close(release)
+		Expect(<-done).To(Succeed())
+		Expect(<-reloaded).To(BeTrue())
+	})
+
+	It("should reload periodically", func() {
+		subject.Close()
+
+		var err error
+		subject, err = NewReloadingReader(fname, WithReloadInterval(5*time.Millisecond))
+		Expect(err).NotTo(HaveOccurred())
+
+		replace("nk", "new")
+		for i := 0; i < 200; i++ {
+			if v, _ := subject.Get([]byte("nk")); v != nil {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		Expect(subject.Get([]byte("nk"))).To(Equal([]byte("new")))
+	})
+
+	It("should report errors of periodic reloads", func() {
+		subject.Close()
+
+		errs := make(chan error, 1)
+		hook := func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+
+		var err error
+		subject, err = NewReloadingReader(fname, WithReloadInterval(5*time.Millisecond), WithCanaryKeys([]byte("xk")), WithReloadHook(hook))
+		Expect(err).NotTo(HaveOccurred())
+
+		replace("nk", "new")
+		Eventually(errs).Should(Receive(MatchError(ErrCanaryFailed)))
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+	})
+
+	It("should fail after close", func() {
+		subject.Close()
+		_, err := subject.Get([]byte("xk"))
+		Expect(err).To(Equal(ERROR_HASH_CLOSED))
+		_, err = subject.Reload()
+		Expect(err).To(Equal(ERROR_HASH_CLOSED))
+	})
+
+})
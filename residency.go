@@ -0,0 +1,115 @@
+package sparkey
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrResidencyUnsupported is returned by Residency on platforms
+// without mincore support.
+var ErrResidencyUnsupported = errors.New("sparkey: residency reports are not supported on this platform")
+
+// RegionResidency reports the page-cache residency of a region within a file
+type RegionResidency struct {
+	// Name of the region, e.g. "header" or "data"
+	Name string
+	// Offset and length of the region, in bytes
+	Offset, Length int64
+	// Number of resident and total pages covered by the region
+	ResidentPages, TotalPages int
+}
+
+// Fraction returns the resident fraction of the region, between 0 and 1.
+func (r *RegionResidency) Fraction() float64 {
+	return residentFraction(r.ResidentPages, r.TotalPages)
+}
+
+// FileResidency reports the page-cache residency of a file
+type FileResidency struct {
+	// File name
+	Name string
+	// File size, in bytes
+	Size int64
+	// Number of resident and total pages of the file
+	ResidentPages, TotalPages int
+	// Per-region breakdown
+	Regions []RegionResidency
+}
+
+// Fraction returns the resident fraction of the file, between 0 and 1.
+func (r *FileResidency) Fraction() float64 {
+	return residentFraction(r.ResidentPages, r.TotalPages)
+}
+
+// ResidencyReport reports the page-cache residency of a hash/log pair
+type ResidencyReport struct {
+	Log, Hash FileResidency
+}
+
+// Residency reports what fraction of the log and hash files associated with the
+// reader are currently resident in memory. It is useful to verify warmup and
+// to size hosts correctly.
+func Residency(r *HashReader) (*ResidencyReport, error) {
+	log, err := fileResidency(r.LogName(), "data", logHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := fileResidency(r.Name(), "slots", hashHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ResidencyReport{Log: *log, Hash: *hash}, nil
+}
+
+func fileResidency(name, body string, headerSize int64) (*FileResidency, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := residentPages(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := int64(os.Getpagesize())
+	report := &FileResidency{Name: name, Size: stat.Size(), TotalPages: len(pages)}
+	for _, resident := range pages {
+		if resident {
+			report.ResidentPages++
+		}
+	}
+
+	if headerSize > stat.Size() {
+		headerSize = stat.Size()
+	}
+	for _, region := range []RegionResidency{
+		{Name: "header", Offset: 0, Length: headerSize},
+		{Name: body, Offset: headerSize, Length: stat.Size() - headerSize},
+	} {
+		if region.Length > 0 {
+			first, last := region.Offset/pageSize, (region.Offset+region.Length-1)/pageSize
+			for _, resident := range pages[first : last+1] {
+				region.TotalPages++
+				if resident {
+					region.ResidentPages++
+				}
+			}
+		}
+		report.Regions = append(report.Regions, region)
+	}
+	return report, nil
+}
+
+func residentFraction(resident, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(resident) / float64(total)
+}
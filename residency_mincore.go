@@ -0,0 +1,40 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package sparkey
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func residentPages(file *os.File, size int64) ([]bool, error) {
+	if size == 0 {
+		return nil, nil
+	} else if size > int64(maxInt) {
+		return nil, ERROR_FILE_TOO_LARGE
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Munmap(data)
+
+	pageSize := os.Getpagesize()
+	vec := make([]byte, (len(data)+pageSize-1)/pageSize)
+	_, _, errno := syscall.Syscall(syscall.SYS_MINCORE,
+		uintptr(unsafe.Pointer(&data[0])),
+		uintptr(len(data)),
+		uintptr(unsafe.Pointer(&vec[0])))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	pages := make([]bool, len(vec))
+	for i, b := range vec {
+		pages[i] = b&1 == 1
+	}
+	return pages, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package sparkey
+
+import "os"
+
+func residentPages(_ *os.File, _ int64) ([]bool, error) {
+	return nil, ErrResidencyUnsupported
+}
@@ -0,0 +1,55 @@
+package sparkey
+
+import (
+	"runtime"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Residency", func() {
+	var subject *HashReader
+
+	BeforeEach(func() {
+		if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+			Skip("mincore is not supported on " + runtime.GOOS)
+		}
+
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		subject, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if subject != nil {
+			subject.Close()
+		}
+	})
+
+	It("should report residency", func() {
+		_, err := subject.Get([]byte("zk"))
+		Expect(err).NotTo(HaveOccurred())
+
+		report, err := Residency(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Log.Name).To(Equal(subject.LogName()))
+		Expect(report.Log.Size).To(BeNumerically(">", len(veryLongString)))
+		Expect(report.Log.TotalPages).To(BeNumerically(">", 1))
+		Expect(report.Log.ResidentPages).To(BeNumerically(">", 0))
+		Expect(report.Log.Fraction()).To(BeNumerically(">", 0))
+		Expect(report.Log.Fraction()).To(BeNumerically("<=", 1))
+		Expect(report.Log.Regions).To(HaveLen(2))
+		Expect(report.Log.Regions[0].Name).To(Equal("header"))
+		Expect(report.Log.Regions[0].Length).To(Equal(int64(84)))
+		Expect(report.Log.Regions[1].Name).To(Equal("data"))
+		Expect(report.Log.Regions[1].Offset).To(Equal(int64(84)))
+
+		Expect(report.Hash.Name).To(Equal(subject.Name()))
+		Expect(report.Hash.TotalPages).To(Equal(1))
+		Expect(report.Hash.Regions[0].Name).To(Equal("header"))
+		Expect(report.Hash.Regions[1].Name).To(Equal("slots"))
+		Expect(report.Hash.Regions[1].TotalPages).To(Equal(1))
+	})
+
+})
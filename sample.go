@@ -0,0 +1,51 @@
+package sparkey
+
+import "math/rand"
+
+// Sample returns up to n live entries, chosen uniformly at random, e.g. for
+// spot checks. It performs a single pass over the log, using reservoir
+// sampling, but only reads the keys and values of entries which are chosen,
+// so memory use is bounded by the sample. Entries are returned in no
+// particular order, all live entries are returned if there are at most n.
+func (r *HashReader) Sample(n int) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	iter, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	size := n
+	if live := r.Len(); live < size {
+		size = live
+	}
+
+	sample := make([]Entry, 0, size)
+	for seen := 0; ; seen++ {
+		if err := iter.NextLive(); err != nil {
+			return nil, err
+		} else if !iter.Valid() {
+			return sample, nil
+		}
+
+		pos := seen
+		if seen >= n {
+			if pos = rand.Intn(seen + 1); pos >= n {
+				continue
+			}
+		}
+
+		entry, err := iter.Entry()
+		if err != nil {
+			return nil, err
+		}
+		if pos < len(sample) {
+			sample[pos] = entry
+		} else {
+			sample = append(sample, entry)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package sparkey
+
+import (
+	"math"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HashReader.Sample", func() {
+	var reader *HashReader
+
+	BeforeEach(func() {
+		fname, err := writeTestHash(testDir, func(w *LogWriter) error {
+			for i := 0; i < 100; i++ {
+				key := []byte(strconv.Itoa(i))
+				if err := w.Put(key, key); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < 100; i += 2 {
+				if err := w.Delete([]byte(strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		reader, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		reader.Close()
+	})
+
+	It("should sample live entries", func() {
+		sample, err := reader.Sample(10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sample).To(HaveLen(10))
+
+		seen := make(map[string]bool)
+		for _, entry := range sample {
+			Expect(entry.Type).To(Equal(ENTRY_PUT))
+			Expect(entry.Value).To(Equal(entry.Key))
+
+			n, err := strconv.Atoi(string(entry.Key))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n % 2).To(Equal(1))
+			Expect(seen).NotTo(HaveKey(string(entry.Key)))
+			seen[string(entry.Key)] = true
+		}
+	})
+
+	It("should return all live entries if there are few", func() {
+		sample, err := reader.Sample(80)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sample).To(HaveLen(50))
+
+		sample, err = reader.Sample(math.MaxInt)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sample).To(HaveLen(50))
+
+		sample, err = reader.Sample(0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sample).To(BeEmpty())
+	})
+
+	It("should cover all live entries", func() {
+		seen := make(map[string]bool)
+		for i := 0; i < 200; i++ {
+			sample, err := reader.Sample(5)
+			Expect(err).NotTo(HaveOccurred())
+			for _, entry := range sample {
+				seen[string(entry.Key)] = true
+			}
+		}
+		Expect(seen).To(HaveLen(50))
+	})
+})
@@ -0,0 +1,148 @@
+package sparkey
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelScan calls fn for each entry in the log, using the given number
+// of workers, or GOMAXPROCS if workers <= 0. The log is split into
+// segments at entry boundaries, each scanned by a worker with its own
+// iterator, hence fn is called concurrently and entries are not passed in
+// log order. Entries are owned by fn, delete operations are passed with a
+// nil value and offsets are set for uncompressed logs. It stops at the
+// first error, either of the iteration or returned by fn.
+//
+// Uncompressed logs are split using the sparse entry offset index, see
+// IteratorAt. Compressed logs can only be split in front of blocks which
+// are known to start with an entry, logs written with small block sizes
+// and few large values parallelise best.
+func (r *LogReader) ParallelScan(workers int, fn func(Entry) error) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	log, release, err := r.nativeLog()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	bounds, err := r.scanBounds(log, workers)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		stopped int32
+		scanErr error
+	)
+	for i := 0; i+1 < len(bounds); i++ {
+		wg.Add(1)
+		go func(start, end uint64) {
+			defer wg.Done()
+
+			if err := scanSegment(log, start, end, &stopped, fn); err != nil {
+				once.Do(func() { scanErr = err })
+				atomic.StoreInt32(&stopped, 1)
+			}
+		}(bounds[i], bounds[i+1])
+	}
+	wg.Wait()
+	return scanErr
+}
+
+// scanBounds returns up to workers+1 ascending file positions, which split
+// the log into segments that start with an entry. The last position is the
+// end of the data.
+func (r *LogReader) scanBounds(log *logFile, workers int) ([]uint64, error) {
+	var candidates []uint64
+	if log.header.Compression == COMPRESSION_NONE {
+		offsets, err := r.entryOffsets()
+		if err != nil {
+			return nil, err
+		}
+		candidates = offsets
+	} else {
+		offsets, err := blockOffsets(log)
+		if err != nil {
+			return nil, err
+		}
+		candidates = offsets
+	}
+
+	bounds := make([]uint64, 0, workers+1)
+	for w := 0; w < workers && len(candidates) != 0; w++ {
+		pos := candidates[w*len(candidates)/workers]
+		if n := len(bounds); n == 0 || bounds[n-1] < pos {
+			bounds = append(bounds, pos)
+		}
+	}
+	return append(bounds, log.header.DataEnd), nil
+}
+
+// blockOffsets returns the file positions of the blocks of a compressed log
+// which start with an entry. Blocks that follow a full block may start with
+// the continuation of an entry.
+func blockOffsets(log *logFile) ([]uint64, error) {
+	var offsets []uint64
+	full := false
+	for pos := uint64(logHeaderSize); pos < log.header.DataEnd; {
+		data, next, err := log.readBlock(pos)
+		if err != nil {
+			return nil, err
+		}
+		size, err := blockDecodedLen(log.header.Compression, data)
+		if err != nil {
+			return nil, err
+		}
+
+		if !full {
+			offsets = append(offsets, pos)
+		}
+		full = size >= uint64(log.header.CompressionBlockSize)
+		pos = next
+	}
+	return offsets, nil
+}
+
+// scanSegment calls fn for each entry that starts between the file
+// positions start and end, until stopped is set.
+func scanSegment(log *logFile, start, end uint64, stopped *int32, fn func(Entry) error) error {
+	c, err := newLogCursor(log)
+	if err != nil {
+		return err
+	}
+	if err := c.seek(start); err != nil {
+		return err
+	}
+
+	for atomic.LoadInt32(stopped) == 0 {
+		if err := c.next(); err != nil {
+			return err
+		} else if c.state != ITERATOR_ACTIVE || log.filePos(c.entry) >= end {
+			return nil
+		}
+
+		entry := Entry{Type: c.typ, KeyLen: c.keyLen, ValueLen: c.valueLen}
+		if log.header.Compression == COMPRESSION_NONE {
+			entry.Offset = log.filePos(c.entry)
+		}
+		if entry.Key, err = c.appendKey(nil); err != nil {
+			return err
+		}
+		if c.typ == ENTRY_PUT {
+			entry.Value = make([]byte, c.valueLen)
+			if _, err := fillChunks(entry.Value, c.valueChunk); err != nil {
+				return err
+			}
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
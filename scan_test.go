@@ -0,0 +1,110 @@
+package sparkey
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParallelScan", func() {
+
+	writeScanLog := func(name string, opts ...Option) (*LogReader, []string) {
+		fname := filepath.Join(testDir, name)
+		w, err := NewLogWriter(fname, opts...)
+		Expect(err).NotTo(HaveOccurred())
+		defer w.Close()
+
+		var expected []string
+		for i := 0; i < 5000; i++ {
+			key, value := fmt.Sprintf("k%05d", i), fmt.Sprintf("v%d", i)
+			if i%500 == 0 {
+				value = strings.Repeat(value, 100)
+			}
+			Expect(w.Put([]byte(key), []byte(value))).To(Succeed())
+			expected = append(expected, key+":"+value)
+
+			if i%7 == 0 {
+				Expect(w.Delete([]byte(key))).To(Succeed())
+				expected = append(expected, key+":<nil>")
+			}
+		}
+		Expect(w.Close()).To(Succeed())
+		sort.Strings(expected)
+
+		reader, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		return reader, expected
+	}
+
+	scan := func(reader *LogReader, workers int) []string {
+		var mu sync.Mutex
+		seen := make([]string, 0)
+		Expect(reader.ParallelScan(workers, func(entry Entry) error {
+			s := string(entry.Key) + ":" + string(entry.Value)
+			if entry.Type == ENTRY_DELETE {
+				Expect(entry.Value).To(BeNil())
+				s = string(entry.Key) + ":<nil>"
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, s)
+			return nil
+		})).To(Succeed())
+		sort.Strings(seen)
+		return seen
+	}
+
+	It("should scan uncompressed logs", func() {
+		reader, expected := writeScanLog("scan")
+		defer reader.Close()
+
+		for _, workers := range []int{0, 1, 3, 16} {
+			Expect(scan(reader, workers)).To(Equal(expected))
+		}
+	})
+
+	It("should scan compressed logs", func() {
+		reader, expected := writeScanLog("scan-snappy", WithCompression(COMPRESSION_SNAPPY), WithBlockSize(64))
+		defer reader.Close()
+
+		for _, workers := range []int{0, 1, 3, 16} {
+			Expect(scan(reader, workers)).To(Equal(expected))
+		}
+	})
+
+	It("should scan empty logs", func() {
+		fname := filepath.Join(testDir, "scan-empty")
+		w, err := NewLogWriter(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+
+		reader, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer reader.Close()
+
+		Expect(scan(reader, 4)).To(BeEmpty())
+	})
+
+	It("should stop on errors", func() {
+		reader, expected := writeScanLog("scan-errors")
+		defer reader.Close()
+
+		failed := errors.New("failed")
+		var n int32
+		Expect(reader.ParallelScan(4, func(Entry) error {
+			atomic.AddInt32(&n, 1)
+			return failed
+		})).To(Equal(failed))
+		Expect(int(atomic.LoadInt32(&n))).To(BeNumerically("<=", 4))
+		Expect(len(expected)).To(BeNumerically(">", 4))
+	})
+
+})
@@ -0,0 +1,175 @@
+package sparkey
+
+import "context"
+
+// All returns an iterator over the key/value pairs of all entries in the
+// log, in log order, which can be used with range-over-func:
+//
+//	for key, value := range reader.All() {
+//		// ...
+//	}
+//
+// Delete operations are yielded with a nil value. Key and value slices are
+// owned by the caller. Iteration stops at the first error, use Entries to
+// observe errors.
+func (r *LogReader) All() func(yield func(key, value []byte) bool) {
+	return func(yield func(key, value []byte) bool) {
+		r.Entries()(func(entry Entry, err error) bool {
+			return err == nil && yield(entry.Key, entry.Value)
+		})
+	}
+}
+
+// Entries returns an iterator over all entries in the log, in log order.
+// Iteration stops after the first error, which is yielded together with a
+// zero Entry.
+func (r *LogReader) Entries() func(yield func(Entry, error) bool) {
+	return func(yield func(Entry, error) bool) {
+		iter, err := r.Iterator()
+		if err != nil {
+			yield(Entry{}, err)
+			return
+		}
+		defer iter.Close()
+
+		if err := yieldEntries(iter, iter.Next, yield); err != nil {
+			yield(Entry{}, err)
+		}
+	}
+}
+
+// ForEachEntry calls fn for each entry in the log, in log order. It stops
+// at the first error, either of the iteration or returned by fn.
+func (r *LogReader) ForEachEntry(fn func(Entry) error) (err error) {
+	r.Entries()(func(entry Entry, e error) bool {
+		if e == nil {
+			e = fn(entry)
+		}
+		err = e
+		return e == nil
+	})
+	return
+}
+
+// ForEachEntryContext is like ForEachEntry, but stops with the context's
+// error once ctx is done.
+func (r *LogReader) ForEachEntryContext(ctx context.Context, fn func(Entry) error) error {
+	return r.ForEachEntry(func(entry Entry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(entry)
+	})
+}
+
+// ForEachKey calls fn with the type and key of each entry in the log, in
+// log order. Values are neither copied nor, where they span whole blocks of
+// compressed logs, decompressed, which makes this considerably faster than
+// ForEachEntry for building external indexes. The key is only valid for the
+// duration of the call. It stops at the first error, either of the
+// iteration or returned by fn.
+func (r *LogReader) ForEachKey(fn func(typ EntryType, key []byte) error) error {
+	log, release, err := r.nativeLog()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	c, err := newLogCursor(log)
+	if err != nil {
+		return err
+	}
+
+	var key []byte
+	for {
+		if err := c.next(); err != nil {
+			return err
+		} else if c.state != ITERATOR_ACTIVE {
+			return nil
+		}
+
+		if key, err = c.appendKey(key[:0]); err != nil {
+			return err
+		} else if err := fn(c.typ, key); err != nil {
+			return err
+		}
+	}
+}
+
+// Entries returns an iterator over all entries of the log, in log order,
+// including deletes and puts that have been superseded, e.g. to replicate
+// the log including deletions. Offsets are set for uncompressed logs. See
+// LogReader.Entries.
+func (r *HashReader) Entries() func(yield func(Entry, error) bool) {
+	return r.Log().Entries()
+}
+
+// Live returns an iterator over the key/value pairs of all live entries, in
+// log order, see All.
+func (r *HashReader) Live() func(yield func(key, value []byte) bool) {
+	return func(yield func(key, value []byte) bool) {
+		r.LiveEntries()(func(entry Entry, err error) bool {
+			return err == nil && yield(entry.Key, entry.Value)
+		})
+	}
+}
+
+// LiveEntries returns an iterator over all live entries, in log order, see
+// Entries.
+func (r *HashReader) LiveEntries() func(yield func(Entry, error) bool) {
+	return func(yield func(Entry, error) bool) {
+		iter, err := r.Iterator()
+		if err != nil {
+			yield(Entry{}, err)
+			return
+		}
+		defer iter.Close()
+
+		if err := yieldEntries(iter.LogIter, iter.NextLive, yield); err != nil {
+			yield(Entry{}, err)
+		}
+	}
+}
+
+// ForEach calls fn with the key and value of each live entry, in log order.
+// It stops at the first error, either of the iteration or returned by fn.
+func (r *HashReader) ForEach(fn func(key, value []byte) error) (err error) {
+	r.LiveEntries()(func(entry Entry, e error) bool {
+		if e == nil {
+			e = fn(entry.Key, entry.Value)
+		}
+		err = e
+		return e == nil
+	})
+	return
+}
+
+// ForEachContext is like ForEach, but stops with the context's error once
+// ctx is done.
+func (r *HashReader) ForEachContext(ctx context.Context, fn func(key, value []byte) error) error {
+	return r.ForEach(func(key, value []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+// yieldEntries advances iter with next and yields its entries, until the
+// end of the log is reached or yield returns false.
+func yieldEntries(iter *LogIter, next func() error, yield func(Entry, error) bool) error {
+	for {
+		if err := next(); err != nil {
+			return err
+		} else if !iter.Valid() {
+			return nil
+		}
+
+		entry, err := iter.Entry()
+		if err != nil {
+			return err
+		} else if !yield(entry, nil) {
+			return nil
+		}
+	}
+}
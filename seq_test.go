@@ -0,0 +1,171 @@
+package sparkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sequences", func() {
+	var reader *HashReader
+
+	BeforeEach(func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		reader, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		reader.Close()
+	})
+
+	It("should iterate over all entries", func() {
+		var pairs []string
+		reader.Log().All()(func(key, value []byte) bool {
+			if value == nil {
+				pairs = append(pairs, string(key)+":<nil>")
+			} else {
+				pairs = append(pairs, string(key)+":"+string(value))
+			}
+			return true
+		})
+		Expect(pairs).To(Equal([]string{"xk:short", "yk:longvalue", "zk:" + veryLongString, "yk:<nil>"}))
+
+		var types []EntryType
+		reader.Log().Entries()(func(entry Entry, err error) bool {
+			Expect(err).NotTo(HaveOccurred())
+			types = append(types, entry.Type)
+			return true
+		})
+		Expect(types).To(Equal([]EntryType{ENTRY_PUT, ENTRY_PUT, ENTRY_PUT, ENTRY_DELETE}))
+	})
+
+	It("should iterate over all entries of hashes", func() {
+		var entries []string
+		reader.Entries()(func(entry Entry, err error) bool {
+			Expect(err).NotTo(HaveOccurred())
+			entries = append(entries, fmt.Sprintf("%d:%s@%d", entry.Type, entry.Key, entry.Offset-logHeaderSize))
+			return true
+		})
+		Expect(entries).To(Equal([]string{"0:xk@0", "0:yk@9", "0:zk@22", "1:yk@8027"}))
+	})
+
+	It("should iterate over live entries", func() {
+		var keys []string
+		reader.Live()(func(key, value []byte) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+		Expect(keys).To(Equal([]string{"xk", "zk"}))
+
+		keys = keys[:0]
+		reader.LiveEntries()(func(entry Entry, err error) bool {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entry.Type).To(Equal(ENTRY_PUT))
+			keys = append(keys, string(entry.Key))
+			return false
+		})
+		Expect(keys).To(Equal([]string{"xk"}))
+	})
+
+	It("should call functions for each entry", func() {
+		var keys []string
+		Expect(reader.ForEach(func(key, value []byte) error {
+			keys = append(keys, string(key)+":"+string(value[:5]))
+			return nil
+		})).To(Succeed())
+		Expect(keys).To(Equal([]string{"xk:short", "zk:blahb"}))
+
+		var types []EntryType
+		Expect(reader.Log().ForEachEntry(func(entry Entry) error {
+			types = append(types, entry.Type)
+			return nil
+		})).To(Succeed())
+		Expect(types).To(Equal([]EntryType{ENTRY_PUT, ENTRY_PUT, ENTRY_PUT, ENTRY_DELETE}))
+	})
+
+	It("should stop on errors", func() {
+		failed := errors.New("failed")
+
+		var n int
+		Expect(reader.ForEach(func(_, _ []byte) error {
+			n++
+			return failed
+		})).To(Equal(failed))
+		Expect(n).To(Equal(1))
+
+		n = 0
+		Expect(reader.Log().ForEachEntry(func(entry Entry) error {
+			if n++; entry.Type == ENTRY_DELETE {
+				return failed
+			}
+			return nil
+		})).To(Equal(failed))
+		Expect(n).To(Equal(4))
+	})
+
+	It("should iterate over keys only", func() {
+		var keys []string
+		Expect(reader.Log().ForEachKey(func(typ EntryType, key []byte) error {
+			keys = append(keys, fmt.Sprintf("%d:%s", typ, key))
+			return nil
+		})).To(Succeed())
+		Expect(keys).To(Equal([]string{"0:xk", "0:yk", "0:zk", "1:yk"}))
+	})
+
+	It("should iterate over keys of compressed log snapshots", func() {
+		fname := filepath.Join(testDir, "keys")
+		w, err := NewLogWriter(fname, WithCompression(COMPRESSION_SNAPPY), WithBlockSize(64))
+		Expect(err).NotTo(HaveOccurred())
+		defer w.Close()
+
+		Expect(w.Put([]byte("k1"), []byte(veryLongString))).To(Succeed())
+		Expect(w.Put([]byte("k2"), []byte("short"))).To(Succeed())
+		Expect(w.Flush()).To(Succeed())
+
+		log, err := OpenLogReader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		defer log.Close()
+
+		Expect(w.Put([]byte("k3"), []byte("v3"))).To(Succeed())
+		Expect(w.Flush()).To(Succeed())
+
+		var keys []string
+		Expect(log.ForEachKey(func(_ EntryType, key []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})).To(Succeed())
+		Expect(keys).To(Equal([]string{"k1", "k2"}))
+	})
+
+	It("should respect contexts", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var n int
+		Expect(reader.ForEachContext(ctx, func(_, _ []byte) error {
+			n++
+			cancel()
+			return nil
+		})).To(Equal(context.Canceled))
+		Expect(n).To(Equal(1))
+
+		Expect(reader.Log().ForEachEntryContext(ctx, func(Entry) error {
+			n++
+			return nil
+		})).To(Equal(context.Canceled))
+		Expect(n).To(Equal(1))
+
+		Expect(reader.Log().ForEachEntryContext(context.Background(), func(Entry) error {
+			n++
+			return nil
+		})).To(Succeed())
+		Expect(n).To(Equal(5))
+	})
+
+})
@@ -0,0 +1,101 @@
+package sparkey
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// ShadowMismatch describes a lookup for which the candidate of a
+// ShadowReader disagreed with the primary.
+type ShadowMismatch struct {
+	Key       []byte
+	Primary   []byte // value of the primary, nil if missing
+	Candidate []byte // value of the candidate, nil if missing
+	Err       error  // error of the candidate, if any
+}
+
+// ShadowReader serves lookups from a primary reader while it repeats them
+// asynchronously against a candidate reader, e.g. a new version of the
+// files or a reader opened WithFaultRecovery, which uses the native Go
+// implementation. Mismatches are reported, which allows to verify a
+// migration at production traffic levels before switching.
+//
+// Candidate lookups never delay the primary: if too many are in flight,
+// further ones are dropped. ShadowReaders are safe for concurrent use, they
+// don't take ownership of the readers.
+type ShadowReader struct {
+	primary, candidate *HashReader
+	report             func(ShadowMismatch)
+
+	slots   chan struct{} // limits candidate lookups in flight
+	active  sync.WaitGroup
+	dropped uint64
+}
+
+// NewShadowReader creates a shadow reader, which reports mismatches to
+// report, from a separate goroutine. At most maxInFlight candidate lookups
+// are performed concurrently, at least one.
+func NewShadowReader(primary, candidate *HashReader, report func(ShadowMismatch), maxInFlight int) *ShadowReader {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	return &ShadowReader{
+		primary:   primary,
+		candidate: candidate,
+		report:    report,
+		slots:     make(chan struct{}, maxInFlight),
+	}
+}
+
+// Get retrieves the value of a key from the primary, see HashReader.Get.
+func (r *ShadowReader) Get(key []byte) ([]byte, error) {
+	val, err := r.primary.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+		return val, nil
+	}
+
+	// the caller owns key and value
+	key = append([]byte{}, key...)
+	var expected []byte
+	if val != nil {
+		expected = append([]byte{}, val...)
+	}
+
+	r.active.Add(1)
+	go func() {
+		defer r.active.Done()
+		defer func() { <-r.slots }()
+
+		r.compare(key, expected)
+	}()
+	return val, nil
+}
+
+// Dropped returns the number of candidate lookups which were skipped,
+// because too many were in flight.
+func (r *ShadowReader) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Close waits for candidate lookups in flight. It does not close the
+// readers.
+func (r *ShadowReader) Close() {
+	r.active.Wait()
+}
+
+// compare looks up key in the candidate and reports a mismatch.
+func (r *ShadowReader) compare(key, expected []byte) {
+	val, err := r.candidate.Get(key)
+	if err == nil && (val == nil) == (expected == nil) && bytes.Equal(val, expected) {
+		return
+	}
+	r.report(ShadowMismatch{Key: key, Primary: expected, Candidate: val, Err: err})
+}
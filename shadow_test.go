@@ -0,0 +1,77 @@
+package sparkey
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShadowReader", func() {
+	var primary, candidate *HashReader
+	var subject *ShadowReader
+	var mismatches []ShadowMismatch
+	var mu sync.Mutex
+
+	BeforeEach(func() {
+		fname, err := writeDefaultTestHash()
+		Expect(err).NotTo(HaveOccurred())
+		primary, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		dir := filepath.Join(testDir, "candidate")
+		Expect(os.MkdirAll(dir, 0755)).NotTo(HaveOccurred())
+		fname, err = writeTestHash(dir, func(w *LogWriter) error {
+			if err := w.Put([]byte("xk"), []byte("short")); err != nil {
+				return err
+			}
+			return w.Put([]byte("yk"), []byte("other"))
+		})
+		Expect(err).NotTo(HaveOccurred())
+		candidate, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		mismatches = nil
+		subject = NewShadowReader(primary, candidate, func(m ShadowMismatch) {
+			mu.Lock()
+			defer mu.Unlock()
+			mismatches = append(mismatches, m)
+		}, 1)
+	})
+
+	AfterEach(func() {
+		subject.Close()
+		candidate.Close()
+		primary.Close()
+	})
+
+	It("should serve from the primary and report mismatches", func() {
+		Expect(subject.Get([]byte("xk"))).To(Equal([]byte("short")))
+		subject.Close()
+		Expect(subject.Get([]byte("yk"))).To(BeNil())
+		subject.Close()
+		Expect(subject.Get([]byte("zk"))).To(Equal([]byte(veryLongString)))
+		subject.Close()
+		Expect(subject.Get([]byte("missing"))).To(BeNil())
+		subject.Close()
+
+		Expect(mismatches).To(Equal([]ShadowMismatch{
+			{Key: []byte("yk"), Candidate: []byte("other")},
+			{Key: []byte("zk"), Primary: []byte(veryLongString)},
+		}))
+		Expect(subject.Dropped()).To(BeZero())
+	})
+
+	It("should drop candidate lookups when busy", func() {
+		subject.slots <- struct{}{}
+		Expect(subject.Get([]byte("yk"))).To(BeNil())
+		<-subject.slots
+
+		subject.Close()
+		Expect(mismatches).To(BeEmpty())
+		Expect(subject.Dropped()).To(Equal(uint64(1)))
+	})
+
+})
@@ -0,0 +1,302 @@
+package sparkey
+
+import (
+	"bytes"
+	"container/heap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// defaultSortMemLimit is the default memory limit of SortedIterator
+const defaultSortMemLimit = 64 << 20
+
+// sortEntryOverhead is the estimated memory overhead of a buffered entry
+const sortEntryOverhead = 64
+
+// sortFanIn is the maximum number of runs that are merged at once
+const sortFanIn = 64
+
+// SortedIterator returns an iterator over the live entries of a reader, in
+// key order, e.g. for merge joins. Entries are buffered in memory up to
+// roughly memLimit bytes, or 64MiB if memLimit <= 0, larger sets are sorted
+// in runs which are spilled to uncompressed logs in a temporary directory
+// within tmpDir, or the default directory for temporary files if tmpDir is
+// empty, and merged, at most 64 runs at a time. Offsets are not set. Iteration stops after the first
+// error, which is yielded together with a zero Entry. Temporary files are
+// removed once the iteration ends.
+func SortedIterator(reader *HashReader, tmpDir string, memLimit int) func(yield func(Entry, error) bool) {
+	if memLimit <= 0 {
+		memLimit = defaultSortMemLimit
+	}
+
+	return func(yield func(Entry, error) bool) {
+		s := &entrySorter{tmpDir: tmpDir, memLimit: memLimit, fanIn: sortFanIn}
+		defer s.close()
+
+		if err := s.sort(reader, yield); err != nil {
+			yield(Entry{}, err)
+		}
+	}
+}
+
+// entrySorter sorts entries externally.
+type entrySorter struct {
+	tmpDir   string
+	memLimit int
+	fanIn    int // maximum number of runs to merge at once
+
+	dir  string   // directory of the runs, created on demand
+	runs []string // spilled runs
+	seq  int      // sequence number of the next run
+	buf  []Entry  // buffered entries
+	size int      // estimated size of buf
+}
+
+func (s *entrySorter) sort(reader *HashReader, yield func(Entry, error) bool) (err error) {
+	reader.LiveEntries()(func(entry Entry, e error) bool {
+		if e == nil {
+			e = s.add(entry)
+		}
+		err = e
+		return e == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.sortBuffer()
+	if len(s.runs) == 0 {
+		for _, entry := range s.buf {
+			if !yield(entry, nil) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	if len(s.buf) != 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	return s.merge(yield)
+}
+
+// add buffers an entry and spills the buffer once it exceeds the limit.
+func (s *entrySorter) add(entry Entry) error {
+	entry.Offset = 0
+	s.buf = append(s.buf, entry)
+	s.size += len(entry.Key) + len(entry.Value) + sortEntryOverhead
+	if s.size < s.memLimit {
+		return nil
+	}
+
+	s.sortBuffer()
+	return s.spill()
+}
+
+func (s *entrySorter) sortBuffer() {
+	sort.Slice(s.buf, func(i, j int) bool { return bytes.Compare(s.buf[i].Key, s.buf[j].Key) < 0 })
+}
+
+// spill writes the sorted buffer to a new run.
+func (s *entrySorter) spill() error {
+	w, name, err := s.createRun()
+	if err != nil {
+		return err
+	}
+	if err := w.PutBatch(s.buf); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, name)
+	s.buf, s.size = s.buf[:0], 0
+	return nil
+}
+
+// createRun creates a writer for a new run.
+func (s *entrySorter) createRun() (*LogWriter, string, error) {
+	if s.dir == "" {
+		dir, err := ioutil.TempDir(s.tmpDir, "sparkey-sort-")
+		if err != nil {
+			return nil, "", fileError(err)
+		}
+		s.dir = dir
+	}
+
+	name := filepath.Join(s.dir, strconv.Itoa(s.seq))
+	w, err := NewLogWriter(name)
+	if err != nil {
+		return nil, "", err
+	}
+	s.seq++
+	return w, name, nil
+}
+
+// merge merges the runs. As long as there are more than fanIn runs, groups
+// of fanIn runs are merged into new runs first.
+func (s *entrySorter) merge(yield func(Entry, error) bool) error {
+	for len(s.runs) > s.fanIn {
+		var runs []string
+		for len(s.runs) != 0 {
+			n := s.fanIn
+			if n > len(s.runs) {
+				n = len(s.runs)
+			}
+			name, err := s.mergeGroup(s.runs[:n])
+			if err != nil {
+				return err
+			}
+			runs = append(runs, name)
+			s.runs = s.runs[n:]
+		}
+		s.runs = runs
+	}
+	return mergeRuns(s.runs, yield)
+}
+
+// mergeGroup merges a group of runs into a new run and removes them.
+func (s *entrySorter) mergeGroup(group []string) (string, error) {
+	if len(group) == 1 {
+		return group[0], nil
+	}
+
+	w, name, err := s.createRun()
+	if err != nil {
+		return "", err
+	}
+
+	var werr error
+	if err := mergeRuns(group, func(entry Entry, _ error) bool {
+		werr = w.Put(entry.Key, entry.Value)
+		return werr == nil
+	}); err != nil {
+		w.Close()
+		return "", err
+	} else if werr != nil {
+		w.Close()
+		return "", werr
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	for _, run := range group {
+		os.Remove(LogFileName(run))
+	}
+	return name, nil
+}
+
+// mergeRuns merges runs and yields their entries in key order.
+func mergeRuns(runs []string, yield func(Entry, error) bool) error {
+	h := make(runHeap, 0, len(runs))
+	defer func() {
+		for _, r := range h {
+			r.close()
+		}
+	}()
+
+	for _, name := range runs {
+		r, err := openSortRun(name)
+		if err != nil {
+			return err
+		}
+		if ok, err := r.next(); err != nil {
+			r.close()
+			return err
+		} else if !ok {
+			r.close()
+			continue
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	for len(h) != 0 {
+		r := h[0]
+		if !yield(r.entry, nil) {
+			return nil
+		}
+
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+			r.close()
+		}
+	}
+	return nil
+}
+
+func (s *entrySorter) close() {
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+/* Sorted runs */
+
+// sortRun reads a spilled run.
+type sortRun struct {
+	log   *LogReader
+	iter  *LogIter
+	entry Entry // current entry
+}
+
+func openSortRun(name string) (*sortRun, error) {
+	log, err := OpenLogReader(name)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := log.Iterator()
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	return &sortRun{log: log, iter: iter}, nil
+}
+
+// next reads the next entry, it returns false at the end of the run.
+func (r *sortRun) next() (bool, error) {
+	if err := r.iter.Next(); err != nil {
+		return false, err
+	} else if !r.iter.Valid() {
+		return false, nil
+	}
+
+	entry, err := r.iter.Entry()
+	if err != nil {
+		return false, err
+	}
+	entry.Offset = 0
+	r.entry = entry
+	return true, nil
+}
+
+func (r *sortRun) close() {
+	r.iter.Close()
+	r.log.Close()
+}
+
+// runHeap orders runs by the key of their current entry.
+type runHeap []*sortRun
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return bytes.Compare(h[i].entry.Key, h[j].entry.Key) < 0 }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*sortRun)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
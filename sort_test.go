@@ -0,0 +1,109 @@
+package sparkey
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SortedIterator", func() {
+	var reader *HashReader
+	var tmpDir string
+
+	BeforeEach(func() {
+		fname, err := writeTestHash(testDir, func(w *LogWriter) error {
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("k%03d", (i*7919)%1000)
+				if err := w.Put([]byte(key), []byte("v"+key)); err != nil {
+					return err
+				}
+				if i%10 == 0 {
+					if err := w.Delete([]byte(key)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		reader, err = Open(fname)
+		Expect(err).NotTo(HaveOccurred())
+
+		tmpDir = filepath.Join(testDir, "tmp")
+		Expect(os.Mkdir(tmpDir, 0755)).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		reader.Close()
+	})
+
+	collect := func(memLimit int) []string {
+		var keys []string
+		SortedIterator(reader, tmpDir, memLimit)(func(entry Entry, err error) bool {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(entry.Value)).To(Equal("v" + string(entry.Key)))
+			keys = append(keys, string(entry.Key))
+			return true
+		})
+		return keys
+	}
+
+	It("should sort in memory", func() {
+		var expected []string
+		for i := 0; i < 1000; i++ {
+			if i%10 != 0 {
+				expected = append(expected, fmt.Sprintf("k%03d", i))
+			}
+		}
+		Expect(collect(0)).To(Equal(expected))
+	})
+
+	It("should sort externally", func() {
+		Expect(collect(4096)).To(Equal(collect(0)))
+
+		files, err := ioutil.ReadDir(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("should merge in multiple passes", func() {
+		var keys []string
+		s := &entrySorter{tmpDir: tmpDir, memLimit: 512, fanIn: 3}
+		Expect(s.sort(reader, func(entry Entry, err error) bool {
+			Expect(err).NotTo(HaveOccurred())
+			keys = append(keys, string(entry.Key))
+			return true
+		})).To(Succeed())
+		Expect(keys).To(Equal(collect(0)))
+
+		Expect(s.seq).To(BeNumerically(">", len(s.runs)))
+		Expect(len(s.runs)).To(BeNumerically("<=", 3))
+		files, err := ioutil.ReadDir(s.dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(len(s.runs)))
+
+		s.close()
+		files, err = ioutil.ReadDir(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("should stop early", func() {
+		var keys []string
+		SortedIterator(reader, tmpDir, 4096)(func(entry Entry, err error) bool {
+			keys = append(keys, string(entry.Key))
+			return len(keys) < 3
+		})
+		Expect(keys).To(HaveLen(3))
+		Expect(keys[0] < keys[1] && keys[1] < keys[2]).To(BeTrue())
+
+		files, err := ioutil.ReadDir(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+
+})
@@ -1,15 +1,18 @@
+// Package sparkey wraps Spotify's sparkey key/value store.
+//
+// Empty (zero-length) keys and values are valid. Writers treat nil and empty
+// slices alike, the empty key is indexed and looked up like any other key.
+// Lookups return nil for missing keys and a non-nil, empty slice for existing
+// keys with empty values.
 package sparkey
 
-//#cgo LDFLAGS: -lsparkey
-//#include <stdlib.h>
-//#include <sparkey/sparkey.h>
-import "C"
-import
+import (
+	"path/filepath"
+	"time"
+)
 
 // ** Constants **
 
-"path/filepath"
-
 type CompressionType uint8
 type IteratorState uint8
 type EntryType uint8
@@ -21,20 +24,21 @@ const (
 )
 
 const (
-	COMPRESSION_NONE   = CompressionType(C.SPARKEY_COMPRESSION_NONE)
-	COMPRESSION_SNAPPY = CompressionType(C.SPARKEY_COMPRESSION_SNAPPY)
+	COMPRESSION_NONE   CompressionType = 0
+	COMPRESSION_SNAPPY CompressionType = 1
+	COMPRESSION_ZSTD   CompressionType = 2
 )
 
 const (
-	ITERATOR_NEW     = IteratorState(C.SPARKEY_ITER_NEW)
-	ITERATOR_ACTIVE  = IteratorState(C.SPARKEY_ITER_ACTIVE)
-	ITERATOR_CLOSED  = IteratorState(C.SPARKEY_ITER_CLOSED)
-	ITERATOR_INVALID = IteratorState(C.SPARKEY_ITER_INVALID)
+	ITERATOR_NEW     IteratorState = 0
+	ITERATOR_ACTIVE  IteratorState = 1
+	ITERATOR_CLOSED  IteratorState = 2
+	ITERATOR_INVALID IteratorState = 3
 )
 
 const (
-	ENTRY_PUT    = EntryType(C.SPARKEY_ENTRY_PUT)
-	ENTRY_DELETE = EntryType(C.SPARKEY_ENTRY_DELETE)
+	ENTRY_PUT    EntryType = 0
+	ENTRY_DELETE EntryType = 1
 )
 
 const (
@@ -43,7 +47,34 @@ const (
 	HASH_SIZE_64BIT = HashSize(8)
 )
 
-const maxInt = int(^uint(0) >> 1)
+const (
+	maxInt   = int(^uint(0) >> 1)
+	maxInt32 = int32(^uint32(0) >> 1)
+)
+
+// entryOffsetInterval is the number of entries between two offsets
+// in the sparse entry offset index of a LogReader
+const entryOffsetInterval = 1024
+
+// hashProgressInterval is the number of log entries between two
+// progress reports while hashes are built
+const hashProgressInterval = 64 * 1024
+
+// defaultReloadInterval is the default interval at which a ReloadingReader
+// checks its files for replacements
+const defaultReloadInterval = time.Second
+
+// getMultiBufferSize is the initial size of the value buffer used by GetMulti
+const getMultiBufferSize = 64 * KiB
+
+// putBatchBufferSize is the (soft) limit for entry data passed to
+// libsparkey at once by PutBatch
+const putBatchBufferSize = 1 * MiB
+
+const (
+	logHeaderSize  = 84
+	hashHeaderSize = 112
+)
 
 // ** Options **
 
@@ -52,6 +83,9 @@ type Options struct {
 	Compression CompressionType
 	// Only relevant if compression type is not COMPRESSION_NONE. Default: 4k
 	CompressionBlockSize int
+	// Only relevant if compression type is COMPRESSION_ZSTD, see WithCompressionLevel
+	// for build restrictions. Default: 0 (library default)
+	CompressionLevel int
 }
 
 func (o *Options) GetCompression() CompressionType {
@@ -62,12 +96,19 @@ func (o *Options) GetCompression() CompressionType {
 }
 
 func (o *Options) GetCompressionBlockSize() int {
-	if o == nil || (o.Compression == COMPRESSION_SNAPPY && o.CompressionBlockSize < 1) {
+	if o == nil || (o.Compression != COMPRESSION_NONE && o.CompressionBlockSize < 1) {
 		return 4 * KiB
 	}
 	return o.CompressionBlockSize
 }
 
+func (o *Options) GetCompressionLevel() int {
+	if o == nil || o.Compression != COMPRESSION_ZSTD {
+		return 0
+	}
+	return o.CompressionLevel
+}
+
 // ** File name helpers **
 
 // HashFileName generates a file name with an spi extension
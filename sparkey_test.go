@@ -10,6 +10,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/bsm/go-sparkey/testgen"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -19,6 +20,7 @@ var _ = Describe("Constants", func() {
 	It("should include compression options", func() {
 		Expect(COMPRESSION_NONE).To(Equal(CompressionType(0)))
 		Expect(COMPRESSION_SNAPPY).To(Equal(CompressionType(1)))
+		Expect(COMPRESSION_ZSTD).To(Equal(CompressionType(2)))
 	})
 
 })
@@ -124,6 +126,33 @@ func BenchmarkHashIterGet(b *testing.B) {
 	})
 }
 
+func BenchmarkLogWriterPut(b *testing.B) {
+	dir, err := ioutil.TempDir("", "sparkey-tests")
+	if err != nil {
+		b.Fatal("error creating dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewLogWriter(filepath.Join(dir, "bench"), WithCompression(COMPRESSION_SNAPPY))
+	if err != nil {
+		b.Fatal("error creating writer", err)
+	}
+	defer w.Close()
+
+	gen := testgen.New(testgen.Config{Seed: 1, Count: b.N, KeyDist: testgen.Uniform, SizeDist: testgen.ExponentialSize, Compressibility: 0.5, DeleteRatio: 0.1})
+	b.ResetTimer()
+	for op, ok := gen.Next(); ok; op, ok = gen.Next() {
+		if op.Delete {
+			err = w.Delete(op.Key)
+		} else {
+			err = w.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			b.Fatal("error writing", err)
+		}
+	}
+}
+
 /** Helpers **/
 
 var testDir string
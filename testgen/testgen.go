@@ -0,0 +1,193 @@
+// Package testgen generates reproducible, pseudo-random datasets for
+// benchmarks, fuzzing seeds and soak tests. The same Config always yields
+// the same sequence of operations, on every machine and Go version, so
+// results remain comparable.
+//
+//	gen := testgen.New(testgen.Config{Seed: 1, Count: 1e6, DeleteRatio: 0.1})
+//	for op, ok := gen.Next(); ok; op, ok = gen.Next() {
+//		if op.Delete {
+//			writer.Delete(op.Key)
+//		} else {
+//			writer.Put(op.Key, op.Value)
+//		}
+//	}
+package testgen
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// KeyDist is the distribution of keys.
+type KeyDist int
+
+const (
+	// Sequential uses the keys of the key space in ascending order,
+	// wrapping around at its end.
+	Sequential KeyDist = iota
+	// Uniform picks keys from the key space uniformly at random.
+	Uniform
+	// Zipf picks keys from the key space following a Zipf distribution, a
+	// few keys are picked very often, most rarely.
+	Zipf
+)
+
+// SizeDist is the distribution of value sizes.
+type SizeDist int
+
+const (
+	// UniformSize picks sizes between MinValueSize and MaxValueSize
+	// uniformly at random.
+	UniformSize SizeDist = iota
+	// ExponentialSize picks sizes following an exponential distribution
+	// above MinValueSize, capped at MaxValueSize, i.e. mostly small values
+	// and a long tail of large ones.
+	ExponentialSize
+)
+
+// Config configures a Generator.
+type Config struct {
+	// Seed of the pseudo-random number generator.
+	Seed int64
+	// Count is the number of operations. Default: 1000
+	Count int
+	// KeySpace is the number of distinct keys. Default: Count
+	KeySpace int
+	// KeyDist is the distribution of keys. Default: Sequential
+	KeyDist KeyDist
+	// KeyPrefix is prepended to all keys.
+	KeyPrefix string
+
+	// MinValueSize and MaxValueSize limit the size of values. Default: 16 and
+	// 256
+	MinValueSize, MaxValueSize int
+	// SizeDist is the distribution of value sizes. Default: UniformSize
+	SizeDist SizeDist
+	// Compressibility is the fraction of each value, between 0 and 1, which
+	// consists of repeated bytes, the rest is random. Default: 0
+	Compressibility float64
+
+	// DeleteRatio is the fraction of operations, between 0 and 1, which are
+	// deletes. Default: 0
+	DeleteRatio float64
+}
+
+func (c *Config) norm() {
+	if c.Count <= 0 {
+		c.Count = 1000
+	}
+	if c.KeySpace <= 0 {
+		c.KeySpace = c.Count
+	}
+	if c.MinValueSize <= 0 {
+		c.MinValueSize = 16
+	}
+	if c.MaxValueSize <= 0 {
+		c.MaxValueSize = 256
+	}
+	if c.MaxValueSize < c.MinValueSize {
+		c.MaxValueSize = c.MinValueSize
+	}
+	c.Compressibility = math.Max(0, math.Min(1, c.Compressibility))
+	c.DeleteRatio = math.Max(0, math.Min(1, c.DeleteRatio))
+}
+
+// Op is a generated operation.
+type Op struct {
+	Key    []byte
+	Value  []byte // nil for deletes
+	Delete bool
+}
+
+// Generator generates operations. Generators are not safe for concurrent
+// use.
+type Generator struct {
+	conf Config
+	rnd  *rand.Rand
+	zipf *rand.Zipf
+	n    int // number of generated operations
+}
+
+// New creates a generator.
+func New(conf Config) *Generator {
+	conf.norm()
+
+	g := &Generator{conf: conf}
+	g.Reset()
+	return g
+}
+
+// Reset restarts the sequence of operations.
+func (g *Generator) Reset() {
+	g.rnd = rand.New(rand.NewSource(g.conf.Seed))
+	g.zipf = nil
+	if g.conf.KeyDist == Zipf {
+		g.zipf = rand.NewZipf(g.rnd, 1.1, 1, uint64(g.conf.KeySpace-1))
+	}
+	g.n = 0
+}
+
+// Next returns the next operation, and false once Count operations have
+// been generated. The key and value slices are owned by the caller.
+func (g *Generator) Next() (Op, bool) {
+	if g.n >= g.conf.Count {
+		return Op{}, false
+	}
+
+	op := Op{Key: g.key()}
+	if g.conf.DeleteRatio > 0 && g.rnd.Float64() < g.conf.DeleteRatio {
+		op.Delete = true
+	} else {
+		op.Value = g.value()
+	}
+	g.n++
+	return op, true
+}
+
+// key returns the next key.
+func (g *Generator) key() []byte {
+	var k uint64
+	switch g.conf.KeyDist {
+	case Uniform:
+		k = uint64(g.rnd.Intn(g.conf.KeySpace))
+	case Zipf:
+		k = g.zipf.Uint64()
+	default:
+		k = uint64(g.n % g.conf.KeySpace)
+	}
+
+	key := make([]byte, 0, len(g.conf.KeyPrefix)+20)
+	key = append(key, g.conf.KeyPrefix...)
+	digits := strconv.AppendUint(nil, k, 10)
+	for i := len(digits); i < 12; i++ {
+		key = append(key, '0')
+	}
+	return append(key, digits...)
+}
+
+// value returns the next value.
+func (g *Generator) value() []byte {
+	min, max := g.conf.MinValueSize, g.conf.MaxValueSize
+
+	size := min
+	switch g.conf.SizeDist {
+	case ExponentialSize:
+		mean := float64(max-min) / 8
+		if extra := g.rnd.ExpFloat64() * mean; extra < float64(max-min) {
+			size += int(extra)
+		} else {
+			size = max
+		}
+	default:
+		size += g.rnd.Intn(max - min + 1)
+	}
+
+	value := make([]byte, size)
+	random := size - int(float64(size)*g.conf.Compressibility)
+	g.rnd.Read(value[:random])
+	for i := random; i < size; i++ {
+		value[i] = 'x'
+	}
+	return value
+}
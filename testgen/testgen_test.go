@@ -0,0 +1,83 @@
+package testgen
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generator", func() {
+
+	collect := func(g *Generator) []Op {
+		var ops []Op
+		for op, ok := g.Next(); ok; op, ok = g.Next() {
+			ops = append(ops, op)
+		}
+		return ops
+	}
+
+	It("should generate defaults", func() {
+		ops := collect(New(Config{}))
+		Expect(ops).To(HaveLen(1000))
+		Expect(string(ops[0].Key)).To(Equal("000000000000"))
+		Expect(string(ops[999].Key)).To(Equal("000000000999"))
+		for _, op := range ops {
+			Expect(op.Delete).To(BeFalse())
+			Expect(len(op.Value)).To(BeNumerically(">=", 16))
+			Expect(len(op.Value)).To(BeNumerically("<=", 256))
+		}
+	})
+
+	It("should be reproducible", func() {
+		conf := Config{Seed: 7, Count: 500, KeySpace: 50, KeyDist: Zipf, SizeDist: ExponentialSize, DeleteRatio: 0.2}
+		g := New(conf)
+		ops := collect(g)
+		Expect(collect(New(conf))).To(Equal(ops))
+
+		g.Reset()
+		Expect(collect(g)).To(Equal(ops))
+
+		conf.Seed = 8
+		Expect(collect(New(conf))).NotTo(Equal(ops))
+	})
+
+	It("should generate deletes", func() {
+		ops := collect(New(Config{Seed: 1, Count: 1000, DeleteRatio: 0.25}))
+
+		var deletes int
+		for _, op := range ops {
+			if op.Delete {
+				Expect(op.Value).To(BeNil())
+				deletes++
+			}
+		}
+		Expect(deletes).To(BeNumerically("~", 250, 50))
+	})
+
+	It("should limit keys", func() {
+		for _, dist := range []KeyDist{Sequential, Uniform, Zipf} {
+			keys := make(map[string]bool)
+			for _, op := range collect(New(Config{Count: 1000, KeySpace: 10, KeyDist: dist, KeyPrefix: "k"})) {
+				Expect(string(op.Key)).To(HavePrefix("k"))
+				keys[string(op.Key)] = true
+			}
+			Expect(len(keys)).To(BeNumerically("<=", 10))
+		}
+	})
+
+	It("should generate compressible values", func() {
+		ops := collect(New(Config{Count: 10, MinValueSize: 100, MaxValueSize: 100, Compressibility: 0.75}))
+		for _, op := range ops {
+			Expect(op.Value).To(HaveLen(100))
+			Expect(bytes.Count(op.Value[25:], []byte{'x'})).To(Equal(75))
+		}
+	})
+
+})
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "sparkey/testgen")
+}
@@ -0,0 +1,27 @@
+module github.com/bsm/go-sparkey/v2
+
+go 1.18
+
+require (
+	github.com/bsm/go-sparkey v0.0.0-20261015035640-4cde8a360e7d
+	github.com/onsi/ginkgo v1.16.5
+	github.com/onsi/gomega v1.20.2
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.4.9 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/google/go-cmp v0.5.8 // indirect
+	github.com/klauspost/compress v1.15.15 // indirect
+	github.com/nxadm/tail v1.4.8 // indirect
+	golang.org/x/net v0.0.0-20220722155237-a158d28d115b // indirect
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
+	golang.org/x/text v0.3.7 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+// version 1 is developed in the same repository, the replace directive only
+// applies to development in this tree and is ignored by consumers. Bump the
+// requirement above whenever v2 starts to use new version 1 APIs.
+replace github.com/bsm/go-sparkey => ../
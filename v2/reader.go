@@ -0,0 +1,147 @@
+package sparkey
+
+import (
+	"context"
+
+	v1 "github.com/bsm/go-sparkey"
+)
+
+// ctxCheckInterval is the number of entries between two context checks
+// while iterating
+const ctxCheckInterval = 1024
+
+// Reader reads a hash/log pair. Readers are safe for concurrent use.
+type Reader struct {
+	r *v1.HashReader
+}
+
+// Open opens a hash/log pair for reading. Supported options are
+// WithConsistencyCheck and WithFaultRecovery.
+func Open(ctx context.Context, fname string, opts ...Option) (*Reader, error) {
+	return OpenFiles(ctx, v1.LogFileName(fname), v1.HashFileName(fname), opts...)
+}
+
+// OpenFiles opens a hash/log pair for reading, using explicit paths for the
+// log and the index (hash) file.
+func OpenFiles(ctx context.Context, logPath, indexPath string, opts ...Option) (*Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError("open", indexPath, err)
+	}
+
+	r, err := v1.OpenFiles(logPath, indexPath, opts...)
+	if err != nil {
+		return nil, wrapError("open", indexPath, err)
+	}
+	return &Reader{r: r}, nil
+}
+
+// WrapReader wraps a version 1 reader. The reader remains usable and shares
+// its state with the returned one.
+func WrapReader(r *v1.HashReader) *Reader { return &Reader{r: r} }
+
+// V1 returns the underlying version 1 reader.
+func (r *Reader) V1() *v1.HashReader { return r.r }
+
+// Header returns the hash file header.
+func (r *Reader) Header() HashHeader { return r.r.Header() }
+
+// LogHeader returns the log file header.
+func (r *Reader) LogHeader() LogHeader { return r.r.Log().Header() }
+
+// Len returns the number of live keys.
+func (r *Reader) Len() int { return r.r.Len() }
+
+// Get retrieves the value of a key. It returns nil when a key doesn't exist.
+func (r *Reader) Get(ctx context.Context, key []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, r.wrap("get", err)
+	}
+
+	val, err := r.r.Get(key)
+	return val, r.wrap("get", err)
+}
+
+// GetMulti retrieves the values of multiple keys, nil for keys that don't
+// exist. The context is checked before each lookup.
+func (r *Reader) GetMulti(ctx context.Context, keys [][]byte) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	err := r.r.GetMultiFunc(keys, func(i int, val []byte) error {
+		vals[i] = val
+		return ctx.Err()
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, r.wrap("get", err)
+	}
+	return vals, nil
+}
+
+// Exists returns true if a live entry exists for the given key.
+func (r *Reader) Exists(ctx context.Context, key []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, r.wrap("exists", err)
+	}
+
+	ok, err := r.r.Exists(key)
+	return ok, r.wrap("exists", err)
+}
+
+// All returns an iterator over all live entries, in log order. Iteration
+// stops after the first error, which is yielded together with a zero
+// Entry. The key and value slices are owned by the caller.
+//
+//	for entry, err := range reader.All(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		// ...
+//	}
+func (r *Reader) All(ctx context.Context) func(yield func(Entry, error) bool) {
+	return func(yield func(Entry, error) bool) {
+		if err := r.all(ctx, yield); err != nil {
+			yield(Entry{}, r.wrap("iterate", err))
+		}
+	}
+}
+
+func (r *Reader) all(ctx context.Context, yield func(Entry, error) bool) error {
+	iter, err := r.r.Iterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for n := 0; ; n++ {
+		if n%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		if err := iter.NextLive(); err != nil {
+			return err
+		} else if !iter.Valid() {
+			return nil
+		}
+
+		entry, err := iter.Entry()
+		if err != nil {
+			return err
+		}
+		if !yield(entry, nil) {
+			return nil
+		}
+	}
+}
+
+// Close closes the reader.
+func (r *Reader) Close() error {
+	r.r.Close()
+	return nil
+}
+
+func (r *Reader) wrap(op string, err error) error {
+	return wrapError(op, r.r.Name(), err)
+}
@@ -0,0 +1,88 @@
+package sparkey
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	v1 "github.com/bsm/go-sparkey"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reader", func() {
+	var subject *Reader
+	var ctx = context.Background()
+
+	BeforeEach(func() {
+		var err error
+		subject, err = Open(ctx, writeTestHash())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should open", func() {
+		Expect(subject.Len()).To(Equal(2))
+		Expect(subject.Header().NumEntries).To(Equal(uint64(2)))
+		Expect(subject.LogHeader().NumPuts).To(Equal(uint64(3)))
+
+		_, err := Open(ctx, filepath.Join(testDir, "missing"))
+		Expect(err).To(MatchError(HavePrefix("sparkey: open ")))
+		Expect(errors.Is(err, v1.ERROR_FILE_NOT_FOUND)).To(BeTrue())
+	})
+
+	It("should retrieve values", func() {
+		Expect(subject.Get(ctx, []byte("k1"))).To(Equal([]byte("v1")))
+		Expect(subject.Get(ctx, []byte("k2"))).To(BeNil())
+		Expect(subject.Exists(ctx, []byte("k3"))).To(BeTrue())
+		Expect(subject.GetMulti(ctx, [][]byte{[]byte("k3"), []byte("k2")})).To(Equal([][]byte{[]byte("v3"), nil}))
+	})
+
+	It("should iterate", func() {
+		var keys []string
+		subject.All(ctx)(func(e Entry, err error) bool {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.Type).To(Equal(EntryPut))
+			keys = append(keys, string(e.Key)+"="+string(e.Value))
+			return true
+		})
+		Expect(keys).To(Equal([]string{"k1=v1", "k3=v3"}))
+
+		keys = keys[:0]
+		subject.All(ctx)(func(e Entry, err error) bool {
+			keys = append(keys, string(e.Key))
+			return false
+		})
+		Expect(keys).To(Equal([]string{"k1"}))
+	})
+
+	It("should respect contexts", func() {
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := subject.Get(cctx, []byte("k1"))
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		_, err = subject.Exists(cctx, []byte("k1"))
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		_, err = subject.GetMulti(cctx, [][]byte{[]byte("k1")})
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		_, err = Open(cctx, writeTestHash())
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+
+		var errs []error
+		subject.All(cctx)(func(_ Entry, err error) bool {
+			errs = append(errs, err)
+			return true
+		})
+		Expect(errs).To(HaveLen(1))
+		Expect(errors.Is(errs[0], context.Canceled)).To(BeTrue())
+	})
+
+	It("should convert from and to v1", func() {
+		Expect(WrapReader(subject.V1()).Get(ctx, []byte("k1"))).To(Equal([]byte("v1")))
+	})
+
+})
@@ -0,0 +1,113 @@
+// Package sparkey is version 2 of the sparkey API. It is a layer on top of
+// version 1, which remains supported, with the following differences:
+//
+//   - all blocking operations take a context.Context
+//   - errors are of type *Error and record the operation and the file, the
+//     underlying version 1 error is available via errors.Is and errors.As
+//   - configuration uses functional options only
+//   - iterators are functions that can be used with range-over-func
+//   - constants follow the Go naming conventions
+//
+// Both versions can be used side by side. Existing code can migrate one
+// call site at a time, WrapReader and WrapWriter convert version 1 readers
+// and writers, V1 converts them back.
+package sparkey
+
+import (
+	"context"
+	"strings"
+
+	v1 "github.com/bsm/go-sparkey"
+)
+
+// Types shared with version 1
+type (
+	Option          = v1.Option
+	Entry           = v1.Entry
+	EntryType       = v1.EntryType
+	CompressionType = v1.CompressionType
+	HashSize        = v1.HashSize
+	HashHeader      = v1.HashHeader
+	LogHeader       = v1.LogHeader
+	HashProgress    = v1.HashProgress
+	LogWriterStats  = v1.LogWriterStats
+	DuplicatePolicy = v1.DuplicatePolicy
+	MergeFunc       = v1.MergeFunc
+	OpenTiming      = v1.OpenTiming
+)
+
+const (
+	CompressionNone   = v1.COMPRESSION_NONE
+	CompressionSnappy = v1.COMPRESSION_SNAPPY
+	CompressionZstd   = v1.COMPRESSION_ZSTD
+)
+
+const (
+	EntryPut    = v1.ENTRY_PUT
+	EntryDelete = v1.ENTRY_DELETE
+)
+
+const (
+	HashSizeAuto = v1.HASH_SIZE_AUTO
+	HashSize32   = v1.HASH_SIZE_32BIT
+	HashSize64   = v1.HASH_SIZE_64BIT
+)
+
+const (
+	DuplicateLastWins  = v1.DuplicateLastWins
+	DuplicateFirstWins = v1.DuplicateFirstWins
+	DuplicateError     = v1.DuplicateError
+)
+
+// Options, see the version 1 documentation for details
+var (
+	WithCompression       = v1.WithCompression
+	WithBlockSize         = v1.WithBlockSize
+	WithCompressionLevel  = v1.WithCompressionLevel
+	WithHashSize          = v1.WithHashSize
+	WithHashSeed          = v1.WithHashSeed
+	WithProgress          = v1.WithProgress
+	WithMaxKeyLen         = v1.WithMaxKeyLen
+	WithSyncOnFlush       = v1.WithSyncOnFlush
+	WithConsistencyCheck  = v1.WithConsistencyCheck
+	WithFaultRecovery     = v1.WithFaultRecovery
+	WithDuplicatePolicy   = v1.WithDuplicatePolicy
+	WithMergeFunc         = v1.WithMergeFunc
+	WithNegativeCache     = v1.WithNegativeCache
+	WithOpenTrace         = v1.WithOpenTrace
+	WithReloadInterval    = v1.WithReloadInterval
+	WithCanaryKeys        = v1.WithCanaryKeys
+	WithReloadHook        = v1.WithReloadHook
+	WithIsolatedWorker    = v1.WithIsolatedWorker
+	WithCompactionRatios  = v1.WithCompactionRatios
+	WithMinSavings        = v1.WithMinSavings
+	WithRebuildThroughput = v1.WithRebuildThroughput
+)
+
+// Error records a failed operation and the file that caused it.
+type Error struct {
+	Op   string // operation, e.g. "open" or "get"
+	Path string // log or hash file name
+	Err  error  // underlying error
+}
+
+func (e *Error) Error() string {
+	return "sparkey: " + e.Op + " " + e.Path + ": " + strings.TrimPrefix(e.Err.Error(), "sparkey: ")
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// wrapError wraps non-nil errors.
+func wrapError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Path: path, Err: err}
+}
+
+// BuildHashFile creates a hash table for a log file. Supported options are
+// WithHashSize, WithHashSeed and WithProgress.
+func BuildHashFile(ctx context.Context, fname string, opts ...Option) error {
+	return wrapError("build", v1.HashFileName(fname), v1.BuildHashFileContext(ctx, fname, opts...))
+}
@@ -0,0 +1,86 @@
+package sparkey
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/bsm/go-sparkey"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Error", func() {
+
+	It("should wrap errors", func() {
+		err := wrapError("open", "test.spi", v1.ERROR_FILE_NOT_FOUND)
+		Expect(err).To(MatchError("sparkey: open test.spi: file not found"))
+		Expect(errors.Is(err, v1.ERROR_FILE_NOT_FOUND)).To(BeTrue())
+
+		var serr *Error
+		Expect(errors.As(err, &serr)).To(BeTrue())
+		Expect(serr.Op).To(Equal("open"))
+		Expect(serr.Path).To(Equal("test.spi"))
+		Expect(wrapError("open", "test.spi", nil)).To(BeNil())
+	})
+
+})
+
+var _ = Describe("BuildHashFile", func() {
+
+	It("should build hash files", func() {
+		ctx := context.Background()
+		fname := filepath.Join(testDir, "test")
+		w, err := Create(ctx, fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Put(ctx, []byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		Expect(BuildHashFile(ctx, fname, WithHashSize(HashSize32))).To(Succeed())
+		header, err := v1.ReadHashHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.HashSize).To(Equal(HashSize32))
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		err = BuildHashFile(cctx, fname)
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+	})
+
+})
+
+// --------------------------------------------------------------------
+
+var testDir string
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	BeforeEach(func() {
+		var err error
+		testDir, err = ioutil.TempDir("", "sparkey-v2-tests")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	AfterEach(func() {
+		os.RemoveAll(testDir)
+	})
+	RunSpecs(t, "sparkey/v2")
+}
+
+// writeTestHash writes a hash/log pair with two live keys and a deleted one
+func writeTestHash() string {
+	ctx := context.Background()
+	fname := filepath.Join(testDir, "test")
+
+	w, err := Create(ctx, fname)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(w.Put(ctx, []byte("k1"), []byte("v1"))).To(Succeed())
+	Expect(w.Put(ctx, []byte("k2"), []byte("v2"))).To(Succeed())
+	Expect(w.Put(ctx, []byte("k3"), []byte("v3"))).To(Succeed())
+	Expect(w.Delete(ctx, []byte("k2"))).To(Succeed())
+	Expect(w.Close()).To(Succeed())
+	Expect(BuildHashFile(ctx, fname)).To(Succeed())
+	return fname
+}
@@ -0,0 +1,124 @@
+package sparkey
+
+import (
+	"context"
+	"io"
+
+	v1 "github.com/bsm/go-sparkey"
+)
+
+// batchSize is the number of entries written by PutBatch between two
+// context checks
+const batchSize = 1024
+
+// Writer appends to a log file. Writers are not threadsafe.
+type Writer struct {
+	w *v1.LogWriter
+}
+
+// Create creates a new log file, replacing existing ones. Supported options
+// are WithCompression, WithBlockSize, WithCompressionLevel, WithMaxKeyLen
+// and WithSyncOnFlush.
+func Create(ctx context.Context, fname string, opts ...Option) (*Writer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError("create", v1.LogFileName(fname), err)
+	}
+
+	w, err := v1.NewLogWriter(fname, opts...)
+	if err != nil {
+		return nil, wrapError("create", v1.LogFileName(fname), err)
+	}
+	return &Writer{w: w}, nil
+}
+
+// Append opens an existing log file for appending. Supported options are
+// WithMaxKeyLen and WithSyncOnFlush.
+func Append(ctx context.Context, fname string, opts ...Option) (*Writer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError("append", v1.LogFileName(fname), err)
+	}
+
+	w, err := v1.AppendLogWriter(fname, opts...)
+	if err != nil {
+		return nil, wrapError("append", v1.LogFileName(fname), err)
+	}
+	return &Writer{w: w}, nil
+}
+
+// WrapWriter wraps a version 1 writer. The writer remains usable and shares
+// its state with the returned one.
+func WrapWriter(w *v1.LogWriter) *Writer { return &Writer{w: w} }
+
+// V1 returns the underlying version 1 writer.
+func (w *Writer) V1() *v1.LogWriter { return w.w }
+
+// Name returns the log file name.
+func (w *Writer) Name() string { return w.w.Name() }
+
+// Put appends a key/value pair.
+func (w *Writer) Put(ctx context.Context, key, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return w.wrap("put", err)
+	}
+	return w.wrap("put", w.w.Put(key, value))
+}
+
+// PutReader appends a key/value pair, reading size bytes of the value
+// from r, see the version 1 documentation for details.
+func (w *Writer) PutReader(ctx context.Context, key []byte, r io.Reader, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return w.wrap("put", err)
+	}
+	return w.wrap("put", w.w.PutReader(key, r, size))
+}
+
+// PutBatch appends many entries, in order. The context is checked
+// periodically, entries preceding a failure will have been written.
+func (w *Writer) PutBatch(ctx context.Context, entries []Entry) error {
+	for len(entries) > 0 {
+		if err := ctx.Err(); err != nil {
+			return w.wrap("put", err)
+		}
+
+		n := batchSize
+		if n > len(entries) {
+			n = len(entries)
+		}
+		if err := w.w.PutBatch(entries[:n]); err != nil {
+			return w.wrap("put", err)
+		}
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// Delete appends a delete operation for a key.
+func (w *Writer) Delete(ctx context.Context, key []byte) error {
+	if err := ctx.Err(); err != nil {
+		return w.wrap("delete", err)
+	}
+	return w.wrap("delete", w.w.Delete(key))
+}
+
+// Flush flushes pending data to the file.
+func (w *Writer) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return w.wrap("flush", err)
+	}
+	return w.wrap("flush", w.w.Flush())
+}
+
+// Stats returns statistics about the log.
+func (w *Writer) Stats() (LogWriterStats, error) {
+	stats, err := w.w.Stats()
+	return stats, w.wrap("stats", err)
+}
+
+// Close closes the writer.
+func (w *Writer) Close() error {
+	return w.wrap("close", w.w.Close())
+}
+
+func (w *Writer) wrap(op string, err error) error {
+	return wrapError(op, w.w.Name(), err)
+}
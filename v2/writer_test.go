@@ -0,0 +1,106 @@
+package sparkey
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/bsm/go-sparkey"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	var subject *Writer
+	var fname string
+	var ctx = context.Background()
+
+	BeforeEach(func() {
+		var err error
+		fname = filepath.Join(testDir, "test")
+		subject, err = Create(ctx, fname, WithCompression(CompressionSnappy))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should write", func() {
+		Expect(subject.Name()).To(Equal(fname + ".spl"))
+		Expect(subject.Put(ctx, []byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(subject.PutReader(ctx, []byte("k2"), strings.NewReader("v2"), 2)).To(Succeed())
+		Expect(subject.PutBatch(ctx, []Entry{
+			{Type: EntryPut, Key: []byte("k3"), Value: []byte("v3")},
+			{Type: EntryDelete, Key: []byte("k1")},
+		})).To(Succeed())
+		Expect(subject.Flush(ctx)).To(Succeed())
+
+		stats, err := subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Puts).To(Equal(uint64(3)))
+		Expect(stats.Deletes).To(Equal(uint64(1)))
+		Expect(subject.Close()).To(Succeed())
+
+		subject, err = Append(ctx, fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.Delete(ctx, []byte("k2"))).To(Succeed())
+		Expect(subject.Close()).To(Succeed())
+
+		header, err := v1.ReadLogHeader(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.Compression).To(Equal(CompressionSnappy))
+		Expect(header.NumPuts).To(Equal(uint64(3)))
+		Expect(header.NumDeletes).To(Equal(uint64(2)))
+	})
+
+	It("should respect contexts", func() {
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		for _, err := range []error{
+			subject.Put(cctx, []byte("k1"), []byte("v1")),
+			subject.PutReader(cctx, []byte("k1"), strings.NewReader("v1"), 2),
+			subject.PutBatch(cctx, []Entry{{Key: []byte("k1")}}),
+			subject.Delete(cctx, []byte("k1")),
+			subject.Flush(cctx),
+		} {
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		}
+
+		_, err := Create(cctx, fname)
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		_, err = Append(cctx, fname)
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+
+		stats, err := subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Puts).To(BeZero())
+	})
+
+	It("should convert from and to v1", func() {
+		Expect(WrapWriter(subject.V1()).Put(ctx, []byte("k1"), []byte("v1"))).To(Succeed())
+		stats, err := subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Puts).To(Equal(uint64(1)))
+	})
+
+	It("should accept version 1 options", func() {
+		Expect(subject.Close()).To(Succeed())
+
+		var err error
+		subject, err = Create(ctx, fname, WithDuplicatePolicy(DuplicateError))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.Put(ctx, []byte("k1"), []byte("v1"))).To(Succeed())
+		Expect(errors.Is(subject.Put(ctx, []byte("k1"), []byte("v2")), v1.ErrDuplicateKey)).To(BeTrue())
+	})
+
+	It("should wrap errors", func() {
+		Expect(subject.Close()).To(Succeed())
+		err := subject.Put(ctx, []byte("k1"), []byte("v1"))
+		Expect(err).To(MatchError("sparkey: put " + fname + ".spl: log closed"))
+		Expect(errors.Is(err, v1.ERROR_LOG_CLOSED)).To(BeTrue())
+	})
+
+})